@@ -0,0 +1,464 @@
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through and tracks their error rate.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen fails requests immediately with a CircuitOpenError.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a few probe requests through to decide whether
+	// to close or reopen.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreakerConfig configures the Manager's circuit breakers. Zero
+// fields use the defaults from DefaultCircuitBreakerConfig.
+type CircuitBreakerConfig struct {
+	// Window is the period over which the error rate is measured.
+	Window time.Duration
+
+	// MinRequests is how many requests the window must hold before the
+	// breaker can open, so a few early failures do not open it.
+	MinRequests int
+
+	// ErrorRate is the fraction of failed requests in the window, from 0 to
+	// 1, at which the breaker opens.
+	ErrorRate float64
+
+	// OpenDuration is how long the breaker stays open before probing.
+	OpenDuration time.Duration
+
+	// HalfOpenProbes is how many probe requests are let through when half
+	// open. The breaker closes once they all succeed and reopens as soon as
+	// one fails.
+	HalfOpenProbes int
+}
+
+// DefaultCircuitBreakerConfig returns the default circuit breaker settings.
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Window:         time.Minute,
+		MinRequests:    10,
+		ErrorRate:      0.5,
+		OpenDuration:   30 * time.Second,
+		HalfOpenProbes: 1,
+	}
+}
+
+// withDefaults returns c with zero fields set to their defaults.
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	d := DefaultCircuitBreakerConfig()
+	if c.Window <= 0 {
+		c.Window = d.Window
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = d.MinRequests
+	}
+	if c.ErrorRate <= 0 {
+		c.ErrorRate = d.ErrorRate
+	}
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = d.OpenDuration
+	}
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = d.HalfOpenProbes
+	}
+	return c
+}
+
+// CircuitOpenError is returned without calling the provider when the
+// circuit breaker for the request's provider or model is open.
+type CircuitOpenError struct {
+	// Scope is ScopeProvider or ScopeModel, and Name the provider or model
+	// whose breaker is open.
+	Scope string
+	Name  string
+
+	Model string
+
+	// RetryAfter is when the breaker will let a probe through. It is zero
+	// when the breaker is half open and its probes are in flight.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s %s (model %s), retry after %v",
+		e.Scope, e.Name, e.Model, e.RetryAfter)
+}
+
+// IsCircuitOpenError reports whether err is or wraps a CircuitOpenError.
+func IsCircuitOpenError(err error) bool {
+	var circuitErr *CircuitOpenError
+	return errors.As(err, &circuitErr)
+}
+
+// CircuitStatus is the state of one circuit breaker.
+type CircuitStatus struct {
+	Scope string // ScopeProvider or ScopeModel
+	Name  string
+	State CircuitState
+
+	// Since is when the breaker entered State.
+	Since time.Time
+}
+
+// SetCircuitBreaker enables a circuit breaker for every provider and every
+// model. A request is refused with a CircuitOpenError while either breaker is
+// open. Setting a new config resets all breakers. Fields left at zero take
+// their defaults, and a zero config turns the breakers off.
+func (m *Manager) SetCircuitBreaker(config CircuitBreakerConfig) *Manager {
+	m.circuits.setConfig(config)
+	return m
+}
+
+// CircuitBreakers returns the state of every circuit breaker that has seen a
+// request, providers first.
+func (m *Manager) CircuitBreakers() []CircuitStatus {
+	return m.circuits.statuses()
+}
+
+// logCircuitChange logs a circuit breaker state change.
+func (m *Manager) logCircuitChange(scope, name string, from, to CircuitState) {
+	attrs := []any{
+		"scope", scope,
+		"name", name,
+		"from", from.String(),
+		"to", to.String(),
+	}
+	if to == CircuitOpen {
+		m.logger.Warn("circuit breaker opened", attrs...)
+	} else {
+		m.logger.Info("circuit breaker "+to.String(), attrs...)
+	}
+}
+
+// circuitOutcome is how a request's result counts towards a breaker.
+type circuitOutcome int
+
+const (
+	outcomeIgnored circuitOutcome = iota
+	outcomeSuccess
+	outcomeFailure
+)
+
+// classifyCircuitOutcome decides whether err, returned for a request sent with
+// ctx, shows the provider is failing. Errors the provider answered, such as
+// rate limits and safety blocks, show it is up; requests canceled by the
+// caller, or cut short by the caller's own deadline, say nothing either way.
+func classifyCircuitOutcome(ctx context.Context, err error) circuitOutcome {
+	var providerErr *ProviderError
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case ctx.Err() != nil, errors.Is(err, context.Canceled), isValidationError(err):
+		return outcomeIgnored
+	case IsRateLimitError(err), errors.Is(err, ErrContentBlocked):
+		return outcomeSuccess
+	case errors.As(err, &providerErr):
+		if providerErr.Temporary() {
+			return outcomeFailure
+		}
+		return outcomeSuccess
+	default:
+		// Provider timeouts, connection failures and unrecognized errors
+		return outcomeFailure
+	}
+}
+
+// circuitRegistry holds the breakers for providers and models.
+type circuitRegistry struct {
+	config    *CircuitBreakerConfig // nil if disabled
+	providers map[string]*circuitBreaker
+	models    map[string]*circuitBreaker
+	onChange  func(scope, name string, from, to CircuitState)
+	now       func() time.Time
+
+	mu sync.Mutex
+}
+
+func newCircuitRegistry(onChange func(scope, name string, from, to CircuitState)) *circuitRegistry {
+	return &circuitRegistry{
+		providers: make(map[string]*circuitBreaker),
+		models:    make(map[string]*circuitBreaker),
+		onChange:  onChange,
+		now:       time.Now,
+	}
+}
+
+func (r *circuitRegistry) setConfig(config CircuitBreakerConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.config = nil
+	if config != (CircuitBreakerConfig{}) {
+		withDefaults := config.withDefaults()
+		r.config = &withDefaults
+	}
+	clear(r.providers)
+	clear(r.models)
+}
+
+// circuitTicket is a request admitted by the breakers. Its outcome must be
+// reported with finish, or cancel if the request was never sent.
+type circuitTicket struct {
+	registry *circuitRegistry
+	breakers []*circuitBreaker
+	probes   []bool
+
+	// generations are the breakers' generations when the request was admitted
+	generations []uint64
+}
+
+// allow admits a request for model on provider, or returns a
+// CircuitOpenError. The ticket is nil if circuit breaking is disabled.
+func (r *circuitRegistry) allow(provider Provider, model Model) (*circuitTicket, error) {
+	r.mu.Lock()
+	if r.config == nil {
+		r.mu.Unlock()
+		return nil, nil
+	}
+	breakers := []*circuitBreaker{
+		r.breakerLocked(r.providers, ScopeProvider, string(provider)),
+		r.breakerLocked(r.models, ScopeModel, string(model)),
+	}
+	r.mu.Unlock()
+
+	now := r.now()
+	t := &circuitTicket{registry: r}
+	for _, b := range breakers {
+		probe, generation, retryAfter, ok := b.allow(now, r.onChange)
+		if !ok {
+			t.cancel()
+			return nil, &CircuitOpenError{
+				Scope:      b.scope,
+				Name:       b.name,
+				Model:      string(model),
+				RetryAfter: retryAfter,
+			}
+		}
+		t.breakers = append(t.breakers, b)
+		t.probes = append(t.probes, probe)
+		t.generations = append(t.generations, generation)
+	}
+	return t, nil
+}
+
+// breakerLocked returns the breaker for scope and name, creating it if
+// needed. Must be called while holding r.mu.
+func (r *circuitRegistry) breakerLocked(breakers map[string]*circuitBreaker, scope, name string) *circuitBreaker {
+	b, ok := breakers[name]
+	if !ok {
+		b = &circuitBreaker{
+			config: *r.config,
+			scope:  scope,
+			name:   name,
+			since:  r.now(),
+		}
+		breakers[name] = b
+	}
+	return b
+}
+
+// finish reports the outcome of the admitted request, sent with ctx.
+func (t *circuitTicket) finish(ctx context.Context, err error) {
+	if t == nil {
+		return
+	}
+	outcome := classifyCircuitOutcome(ctx, err)
+	now := t.registry.now()
+	for i, b := range t.breakers {
+		b.record(now, t.probes[i], t.generations[i], outcome, t.registry.onChange)
+	}
+}
+
+// cancel releases the admitted request without counting it.
+func (t *circuitTicket) cancel() {
+	if t == nil {
+		return
+	}
+	now := t.registry.now()
+	for i, b := range t.breakers {
+		b.record(now, t.probes[i], t.generations[i], outcomeIgnored, t.registry.onChange)
+	}
+}
+
+func (r *circuitRegistry) statuses() []CircuitStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var statuses []CircuitStatus
+	for _, breakers := range []map[string]*circuitBreaker{r.providers, r.models} {
+		names := make([]string, 0, len(breakers))
+		for name := range breakers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			statuses = append(statuses, breakers[name].status())
+		}
+	}
+	return statuses
+}
+
+// circuitBreaker is the breaker for one provider or model.
+type circuitBreaker struct {
+	config      CircuitBreakerConfig
+	scope, name string
+
+	state CircuitState
+	since time.Time
+
+	// generation counts state changes, so outcomes of requests admitted in
+	// an earlier state can be told apart
+	generation uint64
+
+	// Closed: outcomes in the window, oldest first
+	buckets []circuitBucket
+
+	// Half open: probes in flight and probes that have succeeded
+	probes    int
+	successes int
+
+	mu sync.Mutex
+}
+
+// circuitBucket counts the outcomes in one tenth of the window.
+type circuitBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// allow reports whether a request may be sent, whether it is a probe and the
+// breaker's generation. If not, it returns how long until the breaker probes.
+func (b *circuitBreaker) allow(now time.Time, onChange func(scope, name string, from, to CircuitState)) (probe bool, generation uint64, retryAfter time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		if wait := b.since.Add(b.config.OpenDuration).Sub(now); wait > 0 {
+			return false, 0, wait, false
+		}
+		b.setStateLocked(CircuitHalfOpen, now, onChange)
+	}
+
+	if b.state == CircuitHalfOpen {
+		if b.probes+b.successes >= b.config.HalfOpenProbes {
+			return false, 0, 0, false
+		}
+		b.probes++
+		return true, b.generation, 0, true
+	}
+
+	return false, b.generation, 0, true
+}
+
+// record counts the outcome of a request admitted by allow in generation.
+func (b *circuitBreaker) record(now time.Time, probe bool, generation uint64, outcome circuitOutcome, onChange func(scope, name string, from, to CircuitState)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The breaker may have moved on since the request was admitted, for
+	// example reopening and half opening again while a probe was in flight
+	if generation != b.generation {
+		return
+	}
+
+	if probe {
+		b.probes--
+		switch outcome {
+		case outcomeFailure:
+			b.setStateLocked(CircuitOpen, now, onChange)
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= b.config.HalfOpenProbes {
+				b.setStateLocked(CircuitClosed, now, onChange)
+			}
+		}
+		return
+	}
+
+	if outcome == outcomeIgnored {
+		return
+	}
+
+	bucketSize := b.config.Window / 10
+	if n := len(b.buckets); n == 0 || !now.Before(b.buckets[n-1].start.Add(bucketSize)) {
+		b.buckets = append(b.buckets, circuitBucket{start: now})
+	}
+	for len(b.buckets) > 0 && !b.buckets[0].start.After(now.Add(-b.config.Window)) {
+		b.buckets = b.buckets[1:]
+	}
+	if len(b.buckets) == 0 {
+		b.buckets = append(b.buckets, circuitBucket{start: now})
+	}
+
+	last := &b.buckets[len(b.buckets)-1]
+	last.requests++
+	if outcome == outcomeFailure {
+		last.failures++
+	}
+
+	requests, failures := 0, 0
+	for _, bucket := range b.buckets {
+		requests += bucket.requests
+		failures += bucket.failures
+	}
+	if requests >= b.config.MinRequests && float64(failures) >= b.config.ErrorRate*float64(requests) {
+		b.setStateLocked(CircuitOpen, now, onChange)
+	}
+}
+
+// setStateLocked moves the breaker to state and resets its counts.
+// Must be called while holding b.mu.
+func (b *circuitBreaker) setStateLocked(state CircuitState, now time.Time, onChange func(scope, name string, from, to CircuitState)) {
+	from := b.state
+	b.state = state
+	b.since = now
+	b.generation++
+	b.buckets = nil
+	b.probes = 0
+	b.successes = 0
+
+	if onChange != nil {
+		onChange(b.scope, b.name, from, state)
+	}
+}
+
+func (b *circuitBreaker) status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return CircuitStatus{
+		Scope: b.scope,
+		Name:  b.name,
+		State: b.state,
+		Since: b.since,
+	}
+}
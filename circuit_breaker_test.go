@@ -0,0 +1,338 @@
+package imagegen_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+)
+
+// flakyGenerator returns a mock serving one model on its own provider that
+// fails with err while *failing is set.
+func flakyGenerator(name string, failing *bool, err error) *imagegentest.MockGenerator {
+	info := imagegentest.MockModelInfo
+	info.Name = name
+	info.APIModelName = name + "-api"
+	info.Provider = imagegen.Provider(name + "-provider")
+
+	return &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			if *failing {
+				return nil, err
+			}
+			return imagegentest.DefaultResult(), nil
+		},
+	}
+}
+
+var errUnavailable = &imagegen.ProviderError{Provider: "primary-provider", StatusCode: 503}
+
+func TestManager_CircuitBreaker_Opens(t *testing.T) {
+	failing := true
+	gen := flakyGenerator("primary", &failing, errUnavailable)
+
+	var logs bytes.Buffer
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("primary"),
+		imagegen.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		imagegen.WithCircuitBreaker(imagegen.CircuitBreakerConfig{MinRequests: 4, ErrorRate: 0.5}),
+	)
+	defer manager.Close()
+	ctx := context.Background()
+
+	for range 4 {
+		if _, err := manager.Generate(ctx, "a cat", nil); !errors.Is(err, errUnavailable) {
+			t.Fatalf("expected the provider error, got %v", err)
+		}
+	}
+
+	// The breaker is open: requests fail fast without reaching the provider
+	_, err := manager.Generate(ctx, "a cat", nil)
+	var circuitErr *imagegen.CircuitOpenError
+	if !errors.As(err, &circuitErr) {
+		t.Fatalf("expected CircuitOpenError, got %v", err)
+	}
+	if circuitErr.Scope != imagegen.ScopeProvider || circuitErr.Name != "primary-provider" || circuitErr.RetryAfter <= 0 {
+		t.Errorf("unexpected error: %+v", circuitErr)
+	}
+	if got := len(gen.Calls()); got != 4 {
+		t.Errorf("provider received %d calls, want 4", got)
+	}
+	if kind := imagegen.ErrorKind(err); kind != "circuit_open" {
+		t.Errorf("ErrorKind() = %q, want circuit_open", kind)
+	}
+
+	for _, status := range manager.CircuitBreakers() {
+		if status.State != imagegen.CircuitOpen {
+			t.Errorf("%s %s breaker is %v, want open", status.Scope, status.Name, status.State)
+		}
+	}
+	if !strings.Contains(logs.String(), "circuit breaker opened") {
+		t.Error("expected the state change to be logged")
+	}
+}
+
+func TestManager_CircuitBreaker_Disable(t *testing.T) {
+	failing := true
+	gen := flakyGenerator("primary", &failing, errUnavailable)
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("primary"),
+		imagegen.WithCircuitBreaker(imagegen.CircuitBreakerConfig{MinRequests: 2}),
+	)
+	defer manager.Close()
+	ctx := context.Background()
+
+	for range 2 {
+		manager.Generate(ctx, "a cat", nil)
+	}
+	if _, err := manager.Generate(ctx, "a cat", nil); !imagegen.IsCircuitOpenError(err) {
+		t.Fatalf("expected CircuitOpenError, got %v", err)
+	}
+
+	// A zero config turns the breakers off
+	manager.SetCircuitBreaker(imagegen.CircuitBreakerConfig{})
+	for range 3 {
+		if _, err := manager.Generate(ctx, "a cat", nil); !errors.Is(err, errUnavailable) {
+			t.Fatalf("expected the provider error, got %v", err)
+		}
+	}
+	if got := len(gen.Calls()); got != 5 {
+		t.Errorf("provider received %d calls, want 5", got)
+	}
+	if statuses := manager.CircuitBreakers(); len(statuses) != 0 {
+		t.Errorf("CircuitBreakers() = %v, want none", statuses)
+	}
+}
+
+func TestManager_CircuitBreaker_HalfOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		recovered bool
+		want      imagegen.CircuitState
+	}{
+		{"probe succeeds", true, imagegen.CircuitClosed},
+		{"probe fails", false, imagegen.CircuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := true
+			gen := flakyGenerator("primary", &failing, errUnavailable)
+			manager := imagegen.NewManager(gen,
+				imagegen.WithDefaultModel("primary"),
+				imagegen.WithCircuitBreaker(imagegen.CircuitBreakerConfig{
+					MinRequests:  2,
+					OpenDuration: 20 * time.Millisecond,
+				}),
+			)
+			defer manager.Close()
+			ctx := context.Background()
+
+			for range 2 {
+				manager.Generate(ctx, "a cat", nil)
+			}
+			if _, err := manager.Generate(ctx, "a cat", nil); !imagegen.IsCircuitOpenError(err) {
+				t.Fatalf("expected CircuitOpenError, got %v", err)
+			}
+
+			time.Sleep(30 * time.Millisecond)
+			failing = !tt.recovered
+
+			// The first request after OpenDuration is sent as a probe
+			manager.Generate(ctx, "a cat", nil)
+			if got := len(gen.Calls()); got != 3 {
+				t.Errorf("provider received %d calls, want 3", got)
+			}
+			for _, status := range manager.CircuitBreakers() {
+				if status.Scope == imagegen.ScopeProvider && status.State != tt.want {
+					t.Errorf("provider breaker is %v, want %v", status.State, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_CircuitBreaker_IgnoresStaleProbes(t *testing.T) {
+	failing := false
+	gen := flakyGenerator("primary", &failing, errUnavailable)
+	release := make(chan struct{})
+	gen.GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		switch prompt {
+		case "fail":
+			return nil, errUnavailable
+		case "slow":
+			<-release
+		}
+		return imagegentest.DefaultResult(), nil
+	}
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("primary"),
+		imagegen.WithCircuitBreaker(imagegen.CircuitBreakerConfig{
+			MinRequests:    2,
+			OpenDuration:   20 * time.Millisecond,
+			HalfOpenProbes: 2,
+		}),
+	)
+	defer manager.Close()
+	ctx := context.Background()
+
+	for range 2 {
+		manager.Generate(ctx, "fail", nil)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// A slow probe is still in flight when the other probe fails and the
+	// breaker reopens
+	slow := make(chan error)
+	go func() {
+		_, err := manager.Generate(ctx, "slow", nil)
+		slow <- err
+	}()
+	waitFor(t, "the slow probe", func() bool { return len(gen.Calls()) == 3 })
+	manager.Generate(ctx, "fail", nil)
+	time.Sleep(30 * time.Millisecond)
+
+	// One probe of the new half-open period succeeds, then the stale one
+	if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("slow probe: %v", err)
+	}
+
+	for _, status := range manager.CircuitBreakers() {
+		if status.State != imagegen.CircuitHalfOpen {
+			t.Errorf("%s %s breaker is %v, want half-open", status.Scope, status.Name, status.State)
+		}
+	}
+}
+
+func TestManager_CircuitBreaker_IgnoresClientErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"rate limited", &imagegen.RateLimitError{LimitType: "requests"}},
+		{"content blocked", imagegen.ErrContentBlocked},
+		{"bad request", &imagegen.ProviderError{StatusCode: 400}},
+		{"canceled", context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := true
+			manager := imagegen.NewManager(flakyGenerator("primary", &failing, tt.err),
+				imagegen.WithDefaultModel("primary"),
+				imagegen.WithCircuitBreaker(imagegen.CircuitBreakerConfig{MinRequests: 2}),
+			)
+			defer manager.Close()
+
+			for range 5 {
+				if _, err := manager.Generate(context.Background(), "a cat", nil); imagegen.IsCircuitOpenError(err) {
+					t.Fatal("breaker opened on errors that do not show an outage")
+				}
+			}
+		})
+	}
+}
+
+func TestManager_CircuitBreaker_Deadlines(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline bool // whether the caller sets a deadline the provider exceeds
+		wantOpen bool
+	}{
+		{"caller deadline", true, false},
+		{"provider timeout", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := flakyGenerator("primary", new(bool), nil)
+			gen.GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+				if tt.deadline {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				return nil, context.DeadlineExceeded
+			}
+			manager := imagegen.NewManager(gen,
+				imagegen.WithDefaultModel("primary"),
+				imagegen.WithCircuitBreaker(imagegen.CircuitBreakerConfig{MinRequests: 2}),
+			)
+			defer manager.Close()
+
+			var opened bool
+			for range 3 {
+				timeout := time.Hour
+				if tt.deadline {
+					timeout = time.Millisecond
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				_, err := manager.Generate(ctx, "a cat", nil)
+				cancel()
+				opened = opened || imagegen.IsCircuitOpenError(err)
+			}
+			if opened != tt.wantOpen {
+				t.Errorf("breaker opened = %v, want %v", opened, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestManager_FallbackModels(t *testing.T) {
+	primaryFailing, fallbackFailing := true, false
+	primary := flakyGenerator("primary", &primaryFailing, errUnavailable)
+	fallback := flakyGenerator("fallback", &fallbackFailing, errUnavailable)
+
+	var served []imagegen.Model
+	var attempts []int
+	manager := imagegen.NewManager(primary,
+		imagegen.WithCircuitBreaker(imagegen.CircuitBreakerConfig{MinRequests: 2}),
+		imagegen.WithInterceptor(func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+			result, err := next(ctx, req)
+			served = append(served, req.Model)
+			attempts = append(attempts, req.Attempts)
+			return result, err
+		}),
+	)
+	manager.AddProvider(fallback)
+	defer manager.Close()
+	ctx := context.Background()
+
+	config := (&imagegen.GenerateConfig{Model: "primary"}).WithFallbackModels("fallback")
+	for i := range 4 {
+		if _, err := manager.Generate(ctx, "a cat", config); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+
+	// The primary failed twice, opening its breaker; later requests skip it
+	if got := len(primary.Calls()); got != 2 {
+		t.Errorf("primary received %d calls, want 2", got)
+	}
+	if got := len(fallback.Calls()); got != 4 {
+		t.Errorf("fallback received %d calls, want 4", got)
+	}
+	for i, model := range served {
+		if model != "fallback" {
+			t.Errorf("request %d reported model %s, want fallback", i, model)
+		}
+		// Refusals by the open breaker count as attempts on the primary
+		if attempts[i] != 2 {
+			t.Errorf("request %d reported %d attempts, want 2", i, attempts[i])
+		}
+	}
+	// The fallback models are not sent to the provider
+	if cfg := fallback.Calls()[0].Config; len(cfg.FallbackModels) != 0 {
+		t.Errorf("provider config has fallback models %v", cfg.FallbackModels)
+	}
+}
@@ -0,0 +1,178 @@
+package imagegen
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// ConcurrencyStats is the state of a concurrency limit.
+type ConcurrencyStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+}
+
+// SetConcurrencyLimit caps how many requests to model may be in flight at
+// once. Further requests queue in arrival order until a slot frees up or
+// their context is done, before rate limits are checked, so a request that
+// gives up has not spent rate limit budget. A limit of zero or less removes
+// the cap.
+func (m *Manager) SetConcurrencyLimit(model Model, limit int) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	setConcurrencyLimit(m.modelConcurrency, model, limit)
+	return m
+}
+
+// SetProviderConcurrencyLimit caps how many requests to all of a provider's
+// models may be in flight at once, on top of any per-model limits.
+func (m *Manager) SetProviderConcurrencyLimit(provider Provider, limit int) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	setConcurrencyLimit(m.providerConcurrency, provider, limit)
+	return m
+}
+
+// ProviderConcurrency returns the state of every provider concurrency limit.
+// Per-model limits are reported by Stats.
+func (m *Manager) ProviderConcurrency() map[Provider]ConcurrencyStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make(map[Provider]ConcurrencyStats, len(m.providerConcurrency))
+	for provider, sem := range m.providerConcurrency {
+		stats[provider] = sem.stats()
+	}
+	return stats
+}
+
+// setConcurrencyLimit sets or removes the semaphore for key. An existing
+// semaphore is resized so requests in flight stay counted.
+func setConcurrencyLimit[K comparable](semaphores map[K]*semaphore, key K, limit int) {
+	if limit <= 0 {
+		delete(semaphores, key)
+		return
+	}
+	if sem, ok := semaphores[key]; ok {
+		sem.setLimit(limit)
+		return
+	}
+	semaphores[key] = newSemaphore(limit)
+}
+
+// acquireConcurrency waits for a slot under the model's and the provider's
+// concurrency limits. The returned func releases them and must be called
+// once the provider call returns.
+func (m *Manager) acquireConcurrency(ctx context.Context, provider Provider, model Model) (func(), error) {
+	m.mu.RLock()
+	var sems []*semaphore
+	if sem := m.modelConcurrency[model]; sem != nil {
+		sems = append(sems, sem)
+	}
+	if sem := m.providerConcurrency[provider]; sem != nil {
+		sems = append(sems, sem)
+	}
+	m.mu.RUnlock()
+
+	release := func(acquired []*semaphore) {
+		for _, sem := range acquired {
+			sem.release()
+		}
+	}
+
+	// Model before provider, so a request never holds a provider slot while
+	// queued for a model
+	for i, sem := range sems {
+		if err := sem.acquire(ctx); err != nil {
+			release(sems[:i])
+			return nil, err
+		}
+	}
+	return func() { release(sems) }, nil
+}
+
+// semaphore limits concurrent holders, queueing waiters in FIFO order.
+type semaphore struct {
+	limit    int
+	inFlight int
+	queue    []chan struct{} // closed when the waiter is given a slot
+
+	mu sync.Mutex
+}
+
+func newSemaphore(limit int) *semaphore {
+	return &semaphore{limit: limit}
+}
+
+// acquire takes a slot, waiting until one is free or ctx is done.
+func (s *semaphore) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.inFlight < s.limit && len(s.queue) == 0 {
+		s.inFlight++
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	s.queue = append(s.queue, ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	if i := slices.Index(s.queue, ready); i >= 0 {
+		s.queue = slices.Delete(s.queue, i, i+1)
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+	s.mu.Unlock()
+
+	// A slot was handed over as ctx was done; pass it on
+	s.release()
+	return ctx.Err()
+}
+
+// release returns a slot, handing it to the longest waiting request.
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight--
+	s.admitLocked()
+}
+
+// setLimit changes the limit, admitting waiters if it grew.
+func (s *semaphore) setLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = limit
+	s.admitLocked()
+}
+
+// admitLocked gives free slots to waiters in order.
+// Must be called while holding s.mu.
+func (s *semaphore) admitLocked() {
+	for len(s.queue) > 0 && s.inFlight < s.limit {
+		s.inFlight++
+		close(s.queue[0])
+		s.queue = s.queue[1:]
+	}
+}
+
+func (s *semaphore) stats() ConcurrencyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return ConcurrencyStats{
+		Limit:    s.limit,
+		InFlight: s.inFlight,
+		Queued:   len(s.queue),
+	}
+}
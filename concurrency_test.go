@@ -0,0 +1,171 @@
+package imagegen_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+// blockingGenerator returns a mock whose requests block until release is
+// closed, serving the given models on one provider.
+func blockingGenerator(release <-chan struct{}, models ...string) *imagegentest.MockGenerator {
+	infos := make([]imagegen.ModelInfo, len(models))
+	for i, name := range models {
+		infos[i] = imagegentest.MockModelInfo
+		infos[i].Name = name
+		infos[i].APIModelName = name
+	}
+
+	return &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo { return infos },
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			select {
+			case <-release:
+				return imagegentest.DefaultResult(), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestManager_ConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	gen := blockingGenerator(release, "slow")
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("slow"),
+		imagegen.WithConcurrencyLimit("slow", 2),
+	)
+	defer manager.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := manager.Generate(context.Background(), "a 4K cat", nil)
+			errs <- err
+		}()
+	}
+
+	waitFor(t, "two requests in flight and one queued", func() bool {
+		s := manager.Stats()["slow"]
+		return s.InFlight == 2 && s.Concurrency != nil && s.Concurrency.Queued == 1
+	})
+	if got := len(gen.Calls()); got != 2 {
+		t.Errorf("provider received %d calls, want 2", got)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("request failed: %v", err)
+		}
+	}
+
+	s := manager.Stats()["slow"]
+	want := imagegen.ConcurrencyStats{Limit: 2}
+	if s.InFlight != 0 || *s.Concurrency != want {
+		t.Errorf("after the requests: in flight %d, concurrency %+v", s.InFlight, *s.Concurrency)
+	}
+}
+
+func TestManager_ConcurrencyLimit_CanceledWhileQueued(t *testing.T) {
+	release := make(chan struct{})
+	gen := blockingGenerator(release, "slow")
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("slow"),
+		imagegen.WithConcurrencyLimit("slow", 1),
+	)
+	defer manager.Close()
+	manager.SetRateLimiter("slow", ratelimiter.NewGCRA(100_000, 10))
+
+	done := make(chan error)
+	go func() {
+		_, err := manager.Generate(context.Background(), "first", nil)
+		done <- err
+	}()
+	waitFor(t, "the first request", func() bool { return manager.Stats()["slow"].InFlight == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := manager.Generate(ctx, "second", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the queued request to time out, got %v", err)
+	}
+	if s := manager.Stats()["slow"].Concurrency; s.Queued != 0 || s.InFlight != 1 {
+		t.Errorf("unexpected concurrency after cancellation: %+v", *s)
+	}
+	if got := len(gen.Calls()); got != 1 {
+		t.Errorf("provider received %d calls, want 1", got)
+	}
+	// The queued request gave up before spending rate limit budget
+	if got := manager.Stats()["slow"].RateLimit.RemainingRequests; got != 9 {
+		t.Errorf("remaining requests = %d, want 9", got)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("first request: %v", err)
+	}
+
+	// The canceled request did not leak its place in the queue
+	if _, err := manager.Generate(context.Background(), "third", nil); err != nil {
+		t.Errorf("third request: %v", err)
+	}
+}
+
+func TestManager_ProviderConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	manager := imagegen.NewManager(blockingGenerator(release, "a", "b"),
+		imagegen.WithProviderConcurrencyLimit(imagegentest.MockProvider, 1),
+	)
+	defer manager.Close()
+
+	var wg sync.WaitGroup
+	for _, model := range []imagegen.Model{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := manager.Generate(context.Background(), "a cat", &imagegen.GenerateConfig{Model: model}); err != nil {
+				t.Errorf("model %s: %v", model, err)
+			}
+		}()
+	}
+
+	// Requests to different models share the provider's slot
+	waitFor(t, "one request in flight and one queued", func() bool {
+		s := manager.ProviderConcurrency()[imagegentest.MockProvider]
+		return s.InFlight == 1 && s.Queued == 1
+	})
+
+	// Raising the limit admits the queued request
+	manager.SetProviderConcurrencyLimit(imagegentest.MockProvider, 2)
+	waitFor(t, "both requests in flight", func() bool {
+		return manager.ProviderConcurrency()[imagegentest.MockProvider].InFlight == 2
+	})
+
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,245 @@
+package imagegen
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// EmulationOptions configures how multi-turn conversations are emulated for
+// providers that do not implement ConversationalImageGenerator.
+type EmulationOptions struct {
+	// MaxReferenceImages is how many of the most recent model images are fed
+	// back as references on each turn, and on the first turn after a
+	// provider handoff. Zero disables reference images.
+	MaxReferenceImages int
+
+	// MaxTranscriptTurns is how many of the most recent turns are included in
+	// the textual transcript. Zero disables the transcript.
+	MaxTranscriptTurns int
+
+	// MaxTranscriptChars caps the transcript length. Older turns are dropped
+	// first, and a single oversized turn is truncated. Zero means no limit.
+	MaxTranscriptChars int
+}
+
+// DefaultEmulationOptions returns the EmulationOptions used by the Manager.
+func DefaultEmulationOptions() EmulationOptions {
+	return EmulationOptions{
+		MaxReferenceImages: 1,
+		MaxTranscriptTurns: 8,
+		MaxTranscriptChars: 4000,
+	}
+}
+
+// imageOnlyInstruction is sent for a turn with images but no prompt or
+// transcript, as stateless generators need an instruction.
+const imageOnlyInstruction = "Continue from the attached image(s)."
+
+// EmulatedConversation implements Conversation on top of a stateless ImageGenerator.
+//
+// On every turn after the first, the latest model images are sent back as
+// reference images through EditMultiple, and the instruction is prefixed with
+// a compacted transcript of the earlier turns, so follow-ups like "make it
+// darker" refer to the previous result.
+type EmulatedConversation struct {
+	generator ImageGenerator
+	opts      EmulationOptions
+	history   []ConversationTurn
+
+	mu sync.Mutex
+}
+
+// Ensure EmulatedConversation implements Conversation.
+var _ Conversation = (*EmulatedConversation)(nil)
+
+// NewEmulatedConversation creates a conversation that emulates multi-turn
+// behavior with the given generator.
+func NewEmulatedConversation(generator ImageGenerator, opts EmulationOptions) *EmulatedConversation {
+	return &EmulatedConversation{
+		generator: generator,
+		opts:      opts,
+		history:   make([]ConversationTurn, 0),
+	}
+}
+
+// Send sends a message and receives a response. Like ManagedConversation, a
+// turn may omit the prompt if it carries images.
+func (c *EmulatedConversation) Send(ctx context.Context, prompt string, images []InputImage, config *GenerateConfig) (*GenerateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	instruction := buildEmulatedInstruction(c.history, prompt, c.opts)
+	if instruction == "" {
+		if len(images) == 0 {
+			return nil, ErrEmptyPrompt
+		}
+		instruction = imageOnlyInstruction
+	}
+
+	// User images take precedence over references when trimming to the limit
+	refs := latestModelImages(c.history, min(c.opts.MaxReferenceImages, MaxInputImages-len(images)))
+	inputs := append(refs, images...)
+
+	var result *GenerateResult
+	var err error
+	if len(inputs) > 0 {
+		result, err = c.generator.EditMultiple(ctx, inputs, instruction, config)
+	} else {
+		result, err = c.generator.Generate(ctx, instruction, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userTurn := ConversationTurn{Role: "user", Text: prompt}
+	for _, img := range images {
+		userTurn.Images = append(userTurn.Images, GeneratedImage{
+			Data:     img.Data,
+			MIMEType: img.MIMEType,
+		})
+	}
+	c.history = append(c.history, userTurn)
+
+	modelTurn := ConversationTurn{
+		Role:   "model",
+		Text:   result.Text,
+		Images: result.Images,
+	}
+	c.history = append(c.history, modelTurn)
+
+	return result, nil
+}
+
+// History returns the conversation history.
+// User turns contain the original prompts, not the emulated instructions.
+func (c *EmulatedConversation) History() []ConversationTurn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	historyCopy := make([]ConversationTurn, len(c.history))
+	copy(historyCopy, c.history)
+	return historyCopy
+}
+
+// Clear resets the conversation history.
+func (c *EmulatedConversation) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.history = make([]ConversationTurn, 0)
+}
+
+// latestModelImages returns up to limit images from the most recent model
+// turn that produced images, as inputs for the next request.
+func latestModelImages(history []ConversationTurn, limit int) []InputImage {
+	if limit <= 0 {
+		return nil
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		turn := history[i]
+		if turn.Role != "model" || len(turn.Images) == 0 {
+			continue
+		}
+
+		images := turn.Images
+		if len(images) > limit {
+			images = images[len(images)-limit:]
+		}
+
+		refs := make([]InputImage, 0, len(images))
+		for _, img := range images {
+			refs = append(refs, InputImage{Data: img.Data, MIMEType: img.MIMEType})
+		}
+		return refs
+	}
+
+	return nil
+}
+
+// buildEmulatedInstruction prefixes prompt with a compacted transcript of history.
+// With no history the prompt is returned unchanged.
+func buildEmulatedInstruction(history []ConversationTurn, prompt string, opts EmulationOptions) string {
+	transcript := compactTranscript(history, opts.MaxTranscriptTurns, opts.MaxTranscriptChars)
+	if transcript == "" {
+		return prompt
+	}
+
+	var sb strings.Builder
+	sb.WriteString("This is a continuation of an image editing conversation.\n\n")
+	sb.WriteString("Conversation so far:\n")
+	sb.WriteString(transcript)
+
+	if latestModelImages(history, 1) != nil && opts.MaxReferenceImages > 0 {
+		sb.WriteString("\nThe first attached image(s) are your most recent output; apply the request to them.\n")
+	}
+
+	sb.WriteString("\nCurrent request: ")
+	if prompt != "" {
+		sb.WriteString(prompt)
+	} else {
+		sb.WriteString("(see attached images)")
+	}
+
+	return sb.String()
+}
+
+// compactTranscript renders the most recent turns of history as text, keeping
+// at most maxTurns turns and maxChars characters (0 = unlimited chars).
+func compactTranscript(history []ConversationTurn, maxTurns, maxChars int) string {
+	if maxTurns <= 0 || len(history) == 0 {
+		return ""
+	}
+
+	start := max(0, len(history)-maxTurns)
+	lines := make([]string, 0, len(history)-start)
+	for _, turn := range history[start:] {
+		lines = append(lines, transcriptLine(turn))
+	}
+
+	// Drop the oldest lines until the transcript fits
+	total := 0
+	for _, line := range lines {
+		total += len(line) + 1
+	}
+	for maxChars > 0 && total > maxChars && len(lines) > 1 {
+		total -= len(lines[0]) + 1
+		lines = lines[1:]
+	}
+
+	transcript := strings.Join(lines, "\n") + "\n"
+	if maxChars > 3 && len(transcript) > maxChars {
+		cut := len(transcript) - maxChars + 3
+		for cut < len(transcript) && !utf8.RuneStart(transcript[cut]) {
+			cut++
+		}
+		transcript = "..." + transcript[cut:]
+	}
+
+	return transcript
+}
+
+// transcriptLine renders a single turn, noting any attached or generated images.
+func transcriptLine(turn ConversationTurn) string {
+	speaker := "User"
+	verb := "attached"
+	if turn.Role == "model" {
+		speaker = "Assistant"
+		verb = "generated"
+	}
+
+	text := strings.Join(strings.Fields(turn.Text), " ")
+	if n := len(turn.Images); n > 0 {
+		note := fmt.Sprintf("[%s %d image(s)]", verb, n)
+		if text == "" {
+			text = note
+		} else {
+			text = note + " " + text
+		}
+	}
+
+	return speaker + ": " + text
+}
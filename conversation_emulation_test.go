@@ -0,0 +1,167 @@
+package imagegen_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+)
+
+func TestEmulatedConversation_FeedsBackPreviousImage(t *testing.T) {
+	var gotImages []imagegen.InputImage
+	var gotInstruction string
+
+	mockGen := &imagegentest.MockGenerator{
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			return &imagegen.GenerateResult{
+				Text:   "Here is a cat.",
+				Images: []imagegen.GeneratedImage{{Data: []byte("cat-image"), MIMEType: "image/png"}},
+			}, nil
+		},
+		EditMultipleFunc: func(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			gotImages = images
+			gotInstruction = instruction
+			return &imagegen.GenerateResult{
+				Images: []imagegen.GeneratedImage{{Data: []byte("dark-cat-image"), MIMEType: "image/png"}},
+			}, nil
+		},
+	}
+
+	conv := imagegen.NewEmulatedConversation(mockGen, imagegen.DefaultEmulationOptions())
+	ctx := context.Background()
+
+	if _, err := conv.Send(ctx, "draw a cat", nil, nil); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+	if _, err := conv.Send(ctx, "make it darker", nil, nil); err != nil {
+		t.Fatalf("second turn failed: %v", err)
+	}
+
+	if len(gotImages) != 1 || string(gotImages[0].Data) != "cat-image" {
+		t.Errorf("expected previous model image as reference, got %d images", len(gotImages))
+	}
+	for _, want := range []string{"User: draw a cat", "Assistant: [generated 1 image(s)] Here is a cat.", "Current request: make it darker"} {
+		if !strings.Contains(gotInstruction, want) {
+			t.Errorf("instruction missing %q:\n%s", want, gotInstruction)
+		}
+	}
+
+	history := conv.History()
+	if len(history) != 4 {
+		t.Fatalf("expected 4 history turns, got %d", len(history))
+	}
+	if history[2].Text != "make it darker" {
+		t.Errorf("history should record the original prompt, got %q", history[2].Text)
+	}
+}
+
+func TestEmulatedConversation_UserImagesFollowReferences(t *testing.T) {
+	var gotImages []imagegen.InputImage
+
+	mockGen := &imagegentest.MockGenerator{
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			return &imagegen.GenerateResult{
+				Images: []imagegen.GeneratedImage{{Data: []byte("first"), MIMEType: "image/png"}},
+			}, nil
+		},
+		EditMultipleFunc: func(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			gotImages = images
+			return &imagegen.GenerateResult{}, nil
+		},
+	}
+
+	conv := imagegen.NewEmulatedConversation(mockGen, imagegen.DefaultEmulationOptions())
+	ctx := context.Background()
+
+	if _, err := conv.Send(ctx, "draw a room", nil, nil); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+
+	userImg := imagegen.InputImage{Data: []byte("sofa"), MIMEType: "image/jpeg"}
+	if _, err := conv.Send(ctx, "add this sofa", []imagegen.InputImage{userImg}, nil); err != nil {
+		t.Fatalf("second turn failed: %v", err)
+	}
+
+	if len(gotImages) != 2 || string(gotImages[0].Data) != "first" || string(gotImages[1].Data) != "sofa" {
+		t.Errorf("expected [reference, user image], got %d images", len(gotImages))
+	}
+}
+
+func TestEmulatedConversation_ImageOnlyTurn(t *testing.T) {
+	var gotImages []imagegen.InputImage
+	var gotInstruction string
+
+	mockGen := &imagegentest.MockGenerator{
+		EditMultipleFunc: func(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			gotImages = images
+			gotInstruction = instruction
+			return imagegentest.DefaultResult(), nil
+		},
+	}
+
+	conv := imagegen.NewEmulatedConversation(mockGen, imagegen.DefaultEmulationOptions())
+	ctx := context.Background()
+
+	if _, err := conv.Send(ctx, "", nil, nil); !errors.Is(err, imagegen.ErrEmptyPrompt) {
+		t.Errorf("expected ErrEmptyPrompt for an empty turn, got %v", err)
+	}
+
+	photo := imagegen.InputImage{Data: []byte("photo"), MIMEType: "image/png"}
+	if _, err := conv.Send(ctx, "", []imagegen.InputImage{photo}, nil); err != nil {
+		t.Fatalf("image-only first turn failed: %v", err)
+	}
+	if len(gotImages) != 1 || string(gotImages[0].Data) != "photo" {
+		t.Errorf("expected the user image, got %d images", len(gotImages))
+	}
+	if gotInstruction == "" {
+		t.Error("expected an instruction for the image-only turn")
+	}
+
+	history := conv.History()
+	if len(history) != 2 || history[0].Text != "" || len(history[0].Images) != 1 {
+		t.Errorf("unexpected history: %+v", history)
+	}
+}
+
+func TestEmulatedConversation_CompactsTranscript(t *testing.T) {
+	var gotInstruction string
+
+	mockGen := &imagegentest.MockGenerator{
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			gotInstruction = prompt
+			return &imagegen.GenerateResult{Text: "reply to " + prompt[len(prompt)-3:]}, nil
+		},
+	}
+
+	conv := imagegen.NewEmulatedConversation(mockGen, imagegen.EmulationOptions{
+		MaxTranscriptTurns: 2,
+		MaxTranscriptChars: 200,
+	})
+	ctx := context.Background()
+
+	for _, prompt := range []string{"one", "two", "six"} {
+		if _, err := conv.Send(ctx, prompt, nil, nil); err != nil {
+			t.Fatalf("turn %q failed: %v", prompt, err)
+		}
+	}
+
+	if strings.Contains(gotInstruction, "User: one") {
+		t.Errorf("expected only the last 2 turns in the transcript:\n%s", gotInstruction)
+	}
+	if !strings.Contains(gotInstruction, "User: two\nAssistant: reply to two") {
+		t.Errorf("expected the previous exchange in the transcript:\n%s", gotInstruction)
+	}
+
+	conv = imagegen.NewEmulatedConversation(mockGen, imagegen.EmulationOptions{})
+	for _, prompt := range []string{"one", "two"} {
+		if _, err := conv.Send(ctx, prompt, nil, nil); err != nil {
+			t.Fatalf("turn %q failed: %v", prompt, err)
+		}
+	}
+	if gotInstruction != "two" {
+		t.Errorf("expected no transcript when disabled, got %q", gotInstruction)
+	}
+}
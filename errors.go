@@ -1,9 +1,12 @@
 package imagegen
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter"
 )
 
 // RateLimitError is returned when a rate limit is hit.
@@ -12,9 +15,40 @@ type RateLimitError struct {
 	LimitType  string
 	Model      string
 	Err        error // Underlying error from the provider
+
+	// Scope is the level of the Manager's limits that refused the request:
+	// ScopeTenant, ScopeModel, ScopeProvider, or the name of a shared limiter (see
+	// Manager.SetSharedRateLimiter). It is empty for errors from providers.
+	Scope string
+
+	// Tenant is the tenant whose limits refused the request when Scope is
+	// ScopeTenant.
+	Tenant string
 }
 
+// Rate limit scopes reported in RateLimitError.Scope.
+const (
+	ScopeModel    = "model"
+	ScopeProvider = "provider"
+)
+
+// Limit types reported in RateLimitError.LimitType, named after the
+// rate limiter bucket that refused the request.
+const (
+	LimitTypeTokens      = ratelimiter.BucketTokens
+	LimitTypeRequests    = ratelimiter.BucketRequests
+	LimitTypeDailyTokens = ratelimiter.BucketDailyTokens
+)
+
 func (e *RateLimitError) Error() string {
+	if e.Tenant != "" {
+		return fmt.Sprintf("rate limit exceeded for %s: tenant %s %s limit, retry after %v",
+			e.Model, e.Tenant, e.LimitType, e.RetryAfter)
+	}
+	if e.Scope != "" && e.Scope != ScopeModel {
+		return fmt.Sprintf("rate limit exceeded for %s: %s %s limit, retry after %v",
+			e.Model, e.Scope, e.LimitType, e.RetryAfter)
+	}
 	return fmt.Sprintf("rate limit exceeded for %s: %s limit, retry after %v",
 		e.Model, e.LimitType, e.RetryAfter)
 }
@@ -29,6 +63,88 @@ func IsRateLimitError(err error) bool {
 	return errors.As(err, &rlErr)
 }
 
+// ProviderError is returned when a provider responds with an error status
+// other than a rate limit.
+type ProviderError struct {
+	Provider   Provider
+	Model      string
+	StatusCode int   // HTTP status code from the provider
+	Err        error // Underlying error from the provider
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s error for %s: status %d: %v",
+		e.Provider, e.Model, e.StatusCode, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the error is a server-side (5xx) failure that may
+// succeed on retry.
+func (e *ProviderError) Temporary() bool {
+	return e.StatusCode >= 500
+}
+
+// ErrContentBlocked is returned when a prompt or response is blocked by the
+// provider's safety filters.
+var ErrContentBlocked = errors.New("content blocked by safety filters")
+
+// ErrProviderSwitchForbidden is returned when a conversation turn targets a
+// model on a different provider and the HandoffPolicy is HandoffForbid.
+var ErrProviderSwitchForbidden = errors.New("conversation provider switch forbidden")
+
 // ErrStorageNotConfigured is returned when storage operations are attempted
 // without a configured storage backend.
 var ErrStorageNotConfigured = errors.New("storage not configured")
+
+// ErrorKind classifies err for metrics and logs. It returns "" for a nil
+// error and "unknown" for errors it does not recognize.
+func ErrorKind(err error) string {
+	var providerErr *ProviderError
+
+	switch {
+	case err == nil:
+		return ""
+	case IsRateLimitError(err):
+		return "rate_limit"
+	case IsCircuitOpenError(err):
+		return "circuit_open"
+	case errors.Is(err, ErrContentBlocked):
+		return "content_blocked"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrModelNotRegistered), errors.Is(err, ErrProviderNotConfigured):
+		return "not_configured"
+	case errors.Is(err, ErrManagerClosed):
+		return "closed"
+	case isValidationError(err):
+		return "invalid_request"
+	case errors.As(err, &providerErr):
+		return "provider"
+	default:
+		return "unknown"
+	}
+}
+
+// isValidationError reports whether err was caused by an invalid request.
+func isValidationError(err error) bool {
+	for _, target := range []error{
+		ErrEmptyPrompt,
+		ErrEmptyImageData,
+		ErrInvalidMIMEType,
+		ErrImageTooLarge,
+		ErrTooManyImages,
+		ErrUnsupportedAspectRatio,
+		ErrUnsupportedImageSize,
+		ErrProviderSwitchForbidden,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package imagegen_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"rate limit", &imagegen.RateLimitError{LimitType: "tokens"}, "rate_limit"},
+		{"content blocked", fmt.Errorf("gemini: %w", imagegen.ErrContentBlocked), "content_blocked"},
+		{"canceled", context.Canceled, "canceled"},
+		{"timeout", fmt.Errorf("waiting: %w", context.DeadlineExceeded), "timeout"},
+		{"not registered", fmt.Errorf("%w: x", imagegen.ErrModelNotRegistered), "not_configured"},
+		{"validation", imagegen.ErrEmptyPrompt, "invalid_request"},
+		{"provider", &imagegen.ProviderError{StatusCode: 500, Err: errors.New("boom")}, "provider"},
+		{"unknown", errors.New("boom"), "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imagegen.ErrorKind(tt.err); got != tt.want {
+				t.Errorf("ErrorKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
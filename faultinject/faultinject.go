@@ -0,0 +1,297 @@
+// Package faultinject provides an ImageGenerator decorator that injects
+// faults for chaos testing retry, fallback and rate limiting code.
+//
+// The injector wraps any ImageGenerator, including a Manager, and injects
+// latency, rate limit errors, server errors, safety blocks, hangs and
+// truncated or empty results according to a Schedule:
+//
+//	inj := faultinject.New(gen, faultinject.Script(
+//	    faultinject.Fault{Kind: faultinject.KindRateLimit},
+//	    faultinject.Fault{Kind: faultinject.KindServerError, StatusCode: 502},
+//	), faultinject.WithLatency(faultinject.LogNormal(8*time.Second, 0.5)))
+//
+// Every injected fault is recorded and available from Injections.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// ErrInjected is wrapped by every error the injector produces, so tests can
+// tell injected failures from real ones with errors.Is.
+var ErrInjected = errors.New("faultinject: injected fault")
+
+// Injection records a fault applied to a call.
+type Injection struct {
+	Call    Call
+	Fault   Fault
+	Latency time.Duration // total latency added, including the distribution sample
+	Time    time.Time
+}
+
+// Option configures an Injector.
+type Option func(*Injector)
+
+// WithLatency adds a latency sampled from dist to every call.
+func WithLatency(dist Distribution) Option {
+	return func(inj *Injector) {
+		inj.latency = dist
+	}
+}
+
+// WithSeed seeds the random source used for latency sampling.
+func WithSeed(seed int64) Option {
+	return func(inj *Injector) {
+		inj.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithProvider sets the provider reported in injected ProviderErrors.
+func WithProvider(provider imagegen.Provider) Option {
+	return func(inj *Injector) {
+		inj.provider = provider
+	}
+}
+
+// Injector is an ImageGenerator decorator that injects faults.
+type Injector struct {
+	inner    imagegen.ImageGenerator
+	schedule Schedule
+	latency  Distribution
+	provider imagegen.Provider
+
+	rng        *rand.Rand
+	seq        int
+	injections []Injection
+	mu         sync.Mutex
+}
+
+// Ensure Injector implements the interfaces.
+var (
+	_ imagegen.ImageGenerator               = (*Injector)(nil)
+	_ imagegen.ConversationalImageGenerator = (*Injector)(nil)
+)
+
+// New wraps inner with a fault injector following schedule.
+// A nil schedule passes every call through.
+func New(inner imagegen.ImageGenerator, schedule Schedule, opts ...Option) *Injector {
+	if schedule == nil {
+		schedule = Script()
+	}
+
+	inj := &Injector{
+		inner:    inner,
+		schedule: schedule,
+		provider: "faultinject",
+		rng:      rand.New(rand.NewSource(1)),
+	}
+	for _, opt := range opts {
+		opt(inj)
+	}
+
+	if models := inner.Models(); len(models) > 0 && inj.provider == "faultinject" {
+		inj.provider = models[0].Provider
+	}
+
+	return inj
+}
+
+// Generate creates images from a text prompt.
+func (inj *Injector) Generate(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return inj.invoke(ctx, imagegen.OperationGenerate, prompt, config, func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return inj.inner.Generate(ctx, prompt, config)
+	})
+}
+
+// Edit modifies an existing image based on a text instruction.
+func (inj *Injector) Edit(ctx context.Context, image imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return inj.invoke(ctx, imagegen.OperationEdit, instruction, config, func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return inj.inner.Edit(ctx, image, instruction, config)
+	})
+}
+
+// EditMultiple performs editing with multiple reference images.
+func (inj *Injector) EditMultiple(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return inj.invoke(ctx, imagegen.OperationEditMultiple, instruction, config, func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return inj.inner.EditMultiple(ctx, images, instruction, config)
+	})
+}
+
+// Models returns the wrapped generator's models.
+func (inj *Injector) Models() []imagegen.ModelInfo {
+	return inj.inner.Models()
+}
+
+// Close closes the wrapped generator.
+func (inj *Injector) Close() error {
+	return inj.inner.Close()
+}
+
+// StartConversation begins a conversation whose turns are subject to faults.
+// If the wrapped generator has no native conversation support, the
+// conversation is emulated on top of the injector.
+func (inj *Injector) StartConversation() imagegen.Conversation {
+	convGen, ok := inj.inner.(imagegen.ConversationalImageGenerator)
+	if !ok {
+		return imagegen.NewEmulatedConversation(inj, imagegen.DefaultEmulationOptions())
+	}
+	return &conversation{injector: inj, inner: convGen.StartConversation()}
+}
+
+// Injections returns the faults injected so far, in call order.
+// Calls that passed through without fault or latency are not recorded.
+func (inj *Injector) Injections() []Injection {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+
+	injections := make([]Injection, len(inj.injections))
+	copy(injections, inj.injections)
+	return injections
+}
+
+// Reset clears the recorded injections.
+func (inj *Injector) Reset() {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.injections = nil
+}
+
+// invoke applies the scheduled fault to a call.
+func (inj *Injector) invoke(ctx context.Context, op imagegen.Operation, prompt string, config *imagegen.GenerateConfig, next func(context.Context) (*imagegen.GenerateResult, error)) (*imagegen.GenerateResult, error) {
+	call := Call{Operation: op, Prompt: prompt}
+	if config != nil {
+		call.Model = string(config.Model)
+	}
+
+	inj.mu.Lock()
+	inj.seq++
+	call.Seq = inj.seq
+	latency := time.Duration(0)
+	if inj.latency != nil {
+		latency = max(0, inj.latency.Sample(inj.rng))
+	}
+	inj.mu.Unlock()
+
+	fault := inj.schedule.Next(call)
+	if fault.Kind == "" {
+		fault.Kind = KindNone
+	}
+	latency += fault.Latency
+
+	if fault.Kind != KindNone || latency > 0 {
+		inj.mu.Lock()
+		inj.injections = append(inj.injections, Injection{
+			Call:    call,
+			Fault:   fault,
+			Latency: latency,
+			Time:    time.Now(),
+		})
+		inj.mu.Unlock()
+	}
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	switch fault.Kind {
+	case KindHang:
+		<-ctx.Done()
+		return nil, ctx.Err()
+
+	case KindRateLimit:
+		retryAfter := fault.RetryAfter
+		if retryAfter == 0 {
+			retryAfter = 60 * time.Second
+		}
+		return nil, &imagegen.RateLimitError{
+			RetryAfter: retryAfter,
+			LimitType:  imagegen.LimitTypeRequests,
+			Model:      call.Model,
+			Err:        ErrInjected,
+		}
+
+	case KindServerError:
+		status := fault.StatusCode
+		if status == 0 {
+			status = 503
+		}
+		return nil, &imagegen.ProviderError{
+			Provider:   inj.provider,
+			Model:      call.Model,
+			StatusCode: status,
+			Err:        ErrInjected,
+		}
+
+	case KindSafetyBlock:
+		return nil, fmt.Errorf("%w: %w", imagegen.ErrContentBlocked, ErrInjected)
+	}
+
+	result, err := next(ctx)
+	if err != nil || result == nil {
+		return result, err
+	}
+
+	switch fault.Kind {
+	case KindZeroImages:
+		result = copyResult(result)
+		result.Images = []imagegen.GeneratedImage{}
+		if result.UsageMetadata != nil {
+			result.UsageMetadata.ImageCount = 0
+		}
+
+	case KindTruncated:
+		result = copyResult(result)
+		for i := range result.Images {
+			data := result.Images[i].Data
+			result.Images[i].Data = append([]byte(nil), data[:len(data)/2]...)
+		}
+	}
+
+	return result, nil
+}
+
+// copyResult returns a copy of result that can be modified without
+// affecting the wrapped generator's value.
+func copyResult(result *imagegen.GenerateResult) *imagegen.GenerateResult {
+	resultCopy := *result
+	resultCopy.Images = append([]imagegen.GeneratedImage(nil), result.Images...)
+	if result.UsageMetadata != nil {
+		usage := *result.UsageMetadata
+		resultCopy.UsageMetadata = &usage
+	}
+	return &resultCopy
+}
+
+// conversation applies faults to the turns of a wrapped conversation.
+type conversation struct {
+	injector *Injector
+	inner    imagegen.Conversation
+}
+
+func (c *conversation) Send(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return c.injector.invoke(ctx, imagegen.OperationConversation, prompt, config, func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return c.inner.Send(ctx, prompt, images, config)
+	})
+}
+
+func (c *conversation) History() []imagegen.ConversationTurn {
+	return c.inner.History()
+}
+
+func (c *conversation) Clear() {
+	c.inner.Clear()
+}
@@ -0,0 +1,147 @@
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+)
+
+func TestScriptedFaultsThroughManager(t *testing.T) {
+	mock := &imagegentest.MockGenerator{}
+	inj := New(mock, Script(
+		Fault{Kind: KindRateLimit, RetryAfter: 5 * time.Second},
+		Fault{Kind: KindServerError, StatusCode: 502},
+		Fault{Kind: KindSafetyBlock},
+		Fault{Kind: KindZeroImages},
+		Fault{Kind: KindTruncated},
+	))
+
+	manager := imagegen.NewManager(inj, imagegen.WithDefaultModel(imagegen.Model(imagegentest.MockModelInfo.Name)))
+	defer manager.Close()
+	ctx := context.Background()
+
+	_, err := manager.Generate(ctx, "a", nil)
+	var rlErr *imagegen.RateLimitError
+	if !errors.As(err, &rlErr) || rlErr.RetryAfter != 5*time.Second || !errors.Is(err, ErrInjected) {
+		t.Errorf("call 1: expected injected RateLimitError, got %v", err)
+	}
+
+	_, err = manager.Generate(ctx, "a", nil)
+	var provErr *imagegen.ProviderError
+	if !errors.As(err, &provErr) || provErr.StatusCode != 502 || provErr.Provider != imagegentest.MockProvider {
+		t.Errorf("call 2: expected injected ProviderError 502, got %v", err)
+	}
+
+	if _, err = manager.Generate(ctx, "a", nil); !errors.Is(err, imagegen.ErrContentBlocked) {
+		t.Errorf("call 3: expected ErrContentBlocked, got %v", err)
+	}
+
+	result, err := manager.Generate(ctx, "a", nil)
+	if err != nil || len(result.Images) != 0 {
+		t.Errorf("call 4: expected zero-image result, got %v", err)
+	}
+
+	result, err = manager.Generate(ctx, "a", nil)
+	if err != nil || len(result.Images[0].Data) >= len(imagegentest.TinyPNG) {
+		t.Errorf("call 5: expected truncated image, got %v", err)
+	}
+
+	// The script is exhausted; calls pass through
+	result, err = manager.Generate(ctx, "a", nil)
+	if err != nil || len(result.Images) != 1 {
+		t.Errorf("call 6: expected pass-through, got %v", err)
+	}
+
+	injections := inj.Injections()
+	if len(injections) != 5 {
+		t.Fatalf("expected 5 recorded injections, got %d", len(injections))
+	}
+	if injections[1].Call.Seq != 2 || injections[1].Fault.Kind != KindServerError {
+		t.Errorf("unexpected injection record: %+v", injections[1])
+	}
+	if got := len(mock.Calls()); got != 3 {
+		t.Errorf("expected only pass-through faults to reach the provider, got %d calls", got)
+	}
+}
+
+func TestHangRespectsContext(t *testing.T) {
+	inj := New(&imagegentest.MockConversationalGenerator{}, Loop(Fault{Kind: KindHang}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := inj.Generate(ctx, "a", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+
+	conv := inj.StartConversation()
+	if _, err := conv.Send(ctx, "a", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded from conversation, got %v", err)
+	}
+	if len(conv.History()) != 0 {
+		t.Error("hung turn should not be recorded in history")
+	}
+}
+
+func TestRandomScheduleIsReproducible(t *testing.T) {
+	kinds := func() []Kind {
+		schedule := Random(42,
+			Rule{Probability: 0.2, Fault: Fault{Kind: KindRateLimit}},
+			Rule{Probability: 0.1, Fault: Fault{Kind: KindServerError}},
+		)
+		var kinds []Kind
+		for i := 0; i < 200; i++ {
+			kinds = append(kinds, schedule.Next(Call{Seq: i + 1}).Kind)
+		}
+		return kinds
+	}
+
+	first, second := kinds(), kinds()
+	counts := map[Kind]int{}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("schedules with the same seed diverged at call %d", i+1)
+		}
+		counts[first[i]]++
+	}
+
+	if counts[KindRateLimit] < 20 || counts[KindRateLimit] > 60 {
+		t.Errorf("expected about 40 rate limits in 200 calls, got %d", counts[KindRateLimit])
+	}
+	if counts[KindServerError] == 0 || counts[KindNone] == 0 {
+		t.Errorf("expected a mix of faults, got %v", counts)
+	}
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	if d := Constant(time.Second).Sample(rng); d != time.Second {
+		t.Errorf("Constant: got %v", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := Uniform(time.Second, 2*time.Second).Sample(rng); d < time.Second || d >= 2*time.Second {
+			t.Fatalf("Uniform: %v out of range", d)
+		}
+		if d := LogNormal(time.Second, 0.5).Sample(rng); d <= 0 {
+			t.Fatalf("LogNormal: non-positive sample %v", d)
+		}
+	}
+
+	inj := New(&imagegentest.MockGenerator{}, nil, WithLatency(Constant(15*time.Millisecond)))
+	start := time.Now()
+	if _, err := inj.Generate(context.Background(), "a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("expected at least 15ms latency, got %v", elapsed)
+	}
+	if injections := inj.Injections(); len(injections) != 1 || injections[0].Latency != 15*time.Millisecond {
+		t.Errorf("expected latency to be recorded, got %+v", injections)
+	}
+}
@@ -0,0 +1,181 @@
+package faultinject
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// Kind identifies the type of fault to inject.
+type Kind string
+
+const (
+	// KindNone passes the call through (after any Fault.Latency).
+	KindNone Kind = "none"
+
+	// KindRateLimit fails with an *imagegen.RateLimitError shaped like a provider 429.
+	KindRateLimit Kind = "rate_limit"
+
+	// KindServerError fails with an *imagegen.ProviderError with a 5xx status.
+	KindServerError Kind = "server_error"
+
+	// KindSafetyBlock fails with an error wrapping imagegen.ErrContentBlocked.
+	KindSafetyBlock Kind = "safety_block"
+
+	// KindHang blocks until the context is done and returns its error.
+	KindHang Kind = "hang"
+
+	// KindZeroImages passes the call through and removes all images from the result.
+	KindZeroImages Kind = "zero_images"
+
+	// KindTruncated passes the call through and truncates each image's data,
+	// leaving images that fail to decode.
+	KindTruncated Kind = "truncated"
+)
+
+// Fault describes a single injected fault.
+type Fault struct {
+	Kind Kind
+
+	// Latency is added before the fault is applied, on top of the injector's
+	// latency distribution.
+	Latency time.Duration
+
+	// RetryAfter for KindRateLimit. Defaults to 60s, like the Gemini provider.
+	RetryAfter time.Duration
+
+	// StatusCode for KindServerError. Defaults to 503.
+	StatusCode int
+}
+
+// Call describes a call the injector is about to make.
+type Call struct {
+	// Seq is the 1-based sequence number of the call across the injector.
+	Seq int
+
+	// Operation is the method that was called.
+	Operation imagegen.Operation
+
+	// Model is GenerateConfig.Model, if set.
+	Model string
+
+	Prompt string
+}
+
+// Schedule decides which fault to inject for each call.
+// Implementations must be safe for concurrent use.
+type Schedule interface {
+	Next(call Call) Fault
+}
+
+// ScheduleFunc adapts a function to a Schedule.
+type ScheduleFunc func(call Call) Fault
+
+// Next calls f(call).
+func (f ScheduleFunc) Next(call Call) Fault {
+	return f(call)
+}
+
+// Script returns a Schedule that injects faults in order, one per call,
+// then passes every later call through.
+func Script(faults ...Fault) Schedule {
+	return &script{faults: faults}
+}
+
+// Loop returns a Schedule that injects faults in order, repeating forever.
+func Loop(faults ...Fault) Schedule {
+	return &script{faults: faults, loop: true}
+}
+
+type script struct {
+	faults []Fault
+	loop   bool
+	next   int
+	mu     sync.Mutex
+}
+
+func (s *script) Next(call Call) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.faults) == 0 || (!s.loop && s.next >= len(s.faults)) {
+		return Fault{Kind: KindNone}
+	}
+
+	fault := s.faults[s.next%len(s.faults)]
+	s.next++
+	return fault
+}
+
+// Rule injects Fault with the given Probability (0.0-1.0).
+type Rule struct {
+	Probability float64
+	Fault       Fault
+}
+
+// Random returns a Schedule that evaluates rules in order for each call and
+// injects the first one that fires. Calls pass through if no rule fires.
+// The same seed always yields the same sequence of faults.
+func Random(seed int64, rules ...Rule) Schedule {
+	return &random{rng: rand.New(rand.NewSource(seed)), rules: rules}
+}
+
+type random struct {
+	rng   *rand.Rand
+	rules []Rule
+	mu    sync.Mutex
+}
+
+func (r *random) Next(call Call) Fault {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rule := range r.rules {
+		if r.rng.Float64() < rule.Probability {
+			return rule.Fault
+		}
+	}
+	return Fault{Kind: KindNone}
+}
+
+// Distribution samples latencies added to every call.
+type Distribution interface {
+	Sample(rng *rand.Rand) time.Duration
+}
+
+// DistributionFunc adapts a function to a Distribution.
+type DistributionFunc func(rng *rand.Rand) time.Duration
+
+// Sample calls f(rng).
+func (f DistributionFunc) Sample(rng *rand.Rand) time.Duration {
+	return f(rng)
+}
+
+// Constant always returns d.
+func Constant(d time.Duration) Distribution {
+	return DistributionFunc(func(*rand.Rand) time.Duration {
+		return d
+	})
+}
+
+// Uniform samples uniformly from [lo, hi).
+func Uniform(lo, hi time.Duration) Distribution {
+	return DistributionFunc(func(rng *rand.Rand) time.Duration {
+		if hi <= lo {
+			return lo
+		}
+		return lo + time.Duration(rng.Int63n(int64(hi-lo)))
+	})
+}
+
+// LogNormal samples a long-tailed distribution with the given median, where
+// sigma controls the tail (e.g. 0.5 gives a p99 of about 3.2x the median).
+// This roughly matches the latency profile of image generation APIs.
+func LogNormal(median time.Duration, sigma float64) Distribution {
+	return DistributionFunc(func(rng *rand.Rand) time.Duration {
+		return time.Duration(float64(median) * math.Exp(sigma*rng.NormFloat64()))
+	})
+}
@@ -33,6 +33,15 @@ const (
 	AspectRatioAuto AspectRatio = ""
 )
 
+// Priority is the priority class of a request.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1 // Batch traffic
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1 // Interactive traffic; may use reserved capacity
+)
+
 // GenerateConfig holds configuration options for image generation.
 type GenerateConfig struct {
 	// Model to use for generation (if empty, uses manager's default)
@@ -67,6 +76,18 @@ type GenerateConfig struct {
 	// MaxWaitDuration is the maximum time to wait when WaitOnRateLimit is true.
 	// Zero means no limit.
 	MaxWaitDuration time.Duration
+
+	// Priority is the request's priority class. Higher priorities are served
+	// first when waiting on a queued rate limiter (see QueuedLimiter), and
+	// PriorityHigh and above may use capacity held back by ReservedLimiter.
+	// Default is PriorityNormal.
+	Priority Priority
+
+	// FallbackModels are tried in order when the model cannot serve the
+	// request: its circuit breaker is open, it is rate limited, or its
+	// provider fails with a temporary error. Once the request returns,
+	// Request.Model is the model that served it.
+	FallbackModels []Model
 }
 
 // WithModel returns a copy of the config with the specified model.
@@ -79,6 +100,27 @@ func (c *GenerateConfig) WithModel(model Model) *GenerateConfig {
 	return &cX
 }
 
+// WithPriority returns a copy of the config with the specified priority.
+func (c *GenerateConfig) WithPriority(priority Priority) *GenerateConfig {
+	if c == nil {
+		return &GenerateConfig{Priority: priority}
+	}
+	cX := *c
+	cX.Priority = priority
+	return &cX
+}
+
+// WithFallbackModels returns a copy of the config with the specified
+// fallback models.
+func (c *GenerateConfig) WithFallbackModels(models ...Model) *GenerateConfig {
+	if c == nil {
+		return &GenerateConfig{FallbackModels: models}
+	}
+	cX := *c
+	cX.FallbackModels = models
+	return &cX
+}
+
 // DefaultConfig returns a GenerateConfig with sensible defaults.
 func DefaultConfig() *GenerateConfig {
 	temp := float32(1.0)
@@ -0,0 +1,237 @@
+package imagegen
+
+import (
+	"context"
+	"time"
+)
+
+// HedgePolicy configures hedged requests: when a request has not returned
+// after a delay, a second attempt is sent and whichever succeeds first is
+// used. The other attempt is canceled. Zero fields use the defaults from
+// DefaultHedgePolicy.
+//
+// Both attempts pass through tenant quotas, rate limits, circuit breakers and
+// concurrency limits like any other request. If the losing attempt also
+// succeeds before it is canceled, its result is counted in Stats and against
+// its tenant's usage.
+type HedgePolicy struct {
+	// Percentile of the model's recent provider latencies, from 0 to 1,
+	// after which the hedge is sent. Only successful provider calls are
+	// measured, excluding time spent waiting on rate and concurrency limits.
+	Percentile float64
+
+	// MinSamples is how many provider latencies the model must have recorded
+	// before Percentile is used. Until then the hedge is sent after Delay.
+	MinSamples int
+
+	// Delay is the hedge delay for models without enough recorded latencies.
+	Delay time.Duration
+
+	// MinDelay is the shortest hedge delay, so fast models are not hedged on
+	// every request.
+	MinDelay time.Duration
+
+	// Model serves the hedge. Empty sends it to the same model as the
+	// original request.
+	Model Model
+}
+
+// DefaultHedgePolicy returns the default hedging settings.
+func DefaultHedgePolicy() HedgePolicy {
+	return HedgePolicy{
+		Percentile: 0.95,
+		MinSamples: 20,
+		Delay:      30 * time.Second,
+		MinDelay:   time.Second,
+	}
+}
+
+// withDefaults returns p with zero fields set to their defaults.
+func (p HedgePolicy) withDefaults() HedgePolicy {
+	d := DefaultHedgePolicy()
+	if p.Percentile <= 0 || p.Percentile > 1 {
+		p.Percentile = d.Percentile
+	}
+	if p.MinSamples <= 0 {
+		p.MinSamples = d.MinSamples
+	}
+	if p.Delay <= 0 {
+		p.Delay = d.Delay
+	}
+	if p.MinDelay <= 0 {
+		p.MinDelay = d.MinDelay
+	}
+	return p
+}
+
+// SetHedging enables hedged requests for Generate, Edit and EditMultiple.
+// Conversation turns are never hedged. A nil policy disables hedging.
+//
+// Example:
+//
+//	manager.SetHedging(&imagegen.HedgePolicy{Percentile: 0.9, Model: "gemini-flash-image"})
+func (m *Manager) SetHedging(policy *HedgePolicy) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hedging = nil
+	if policy != nil {
+		withDefaults := policy.withDefaults()
+		m.hedging = &withDefaults
+	}
+	return m
+}
+
+// hedgeDelay returns how long to wait for a request to model before hedging it.
+func (m *Manager) hedgeDelay(policy *HedgePolicy, model Model) time.Duration {
+	delay, samples := m.stats.dispatchPercentile(model, policy.Percentile)
+	if samples < policy.MinSamples {
+		delay = policy.Delay
+	}
+	return max(delay, policy.MinDelay)
+}
+
+// hedgeAttempt is the outcome of one attempt of a hedged request.
+type hedgeAttempt struct {
+	req     *Request
+	result  *GenerateResult
+	err     error
+	latency time.Duration
+	hedge   bool
+}
+
+// handleHedged runs req on req.Model like handle, sending a hedge under the
+// Manager's HedgePolicy if it is slow.
+func (m *Manager) handleHedged(ctx context.Context, req *Request, p pipeline) (*GenerateResult, error) {
+	m.mu.RLock()
+	policy := m.hedging
+	m.mu.RUnlock()
+
+	if policy == nil || req.Operation == OperationConversation {
+		req.Attempts++
+		return m.handle(ctx, req, p)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each attempt gets its own copy of req, as handle updates it
+	attempts := make(chan hedgeAttempt, 2)
+	prevAttempts, sent := req.Attempts, 0
+	run := func(model Model, hedge bool) {
+		sent++
+		attempt := *req
+		attempt.Model = model
+		go func() {
+			start := time.Now()
+			result, err := m.handle(ctx, &attempt, p)
+			attempts <- hedgeAttempt{
+				req:     &attempt,
+				result:  result,
+				err:     err,
+				latency: time.Since(start),
+				hedge:   hedge,
+			}
+		}()
+	}
+
+	delay := m.hedgeDelay(policy, req.Model)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	run(req.Model, false)
+	var failed *hedgeAttempt
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			hedgeModel := policy.Model
+			if hedgeModel == "" {
+				hedgeModel = req.Model
+			}
+			if err := m.checkHedge(req, hedgeModel, p); err != nil {
+				m.logger.Debug("not hedging slow request",
+					"model", string(req.Model),
+					"hedge_model", string(hedgeModel),
+					"error", err.Error(),
+				)
+				continue
+			}
+			m.logger.Info("hedging slow request",
+				"model", string(req.Model),
+				"hedge_model", string(hedgeModel),
+				"delay_ms", delay.Milliseconds(),
+			)
+			m.stats.hedged(req.Model)
+			run(hedgeModel, true)
+			pending++
+
+		case a := <-attempts:
+			pending--
+			if a.err != nil {
+				// Report the original attempt's error if both fail
+				if failed == nil || !a.hedge {
+					failed = &a
+				}
+				continue
+			}
+
+			cancel()
+			if a.hedge {
+				m.stats.hedgeWon(req.Model)
+			}
+			if pending > 0 {
+				// Keep the loser counted so Shutdown drains it. If the
+				// Manager is already shutting down, wait for it here.
+				if m.requests.begin() {
+					go func() {
+						defer m.requests.end()
+						m.collectHedgeLoser(attempts)
+					}()
+				} else {
+					m.collectHedgeLoser(attempts)
+				}
+			}
+			// Stats record the winning attempt's own latency under its model
+			*req = *a.req
+			req.latency = a.latency
+			req.Attempts = prevAttempts + sent
+			return a.result, nil
+		}
+	}
+
+	*req = *failed.req
+	req.Attempts = prevAttempts + sent
+	return nil, failed.err
+}
+
+// checkHedge reports why req cannot be hedged on model, if it cannot: the
+// model must be registered and able to serve the request.
+func (m *Manager) checkHedge(req *Request, model Model, p pipeline) error {
+	if _, _, err := m.route(model); err != nil {
+		return err
+	}
+
+	hedge := *req
+	hedge.Model = model
+	if p.check != nil {
+		if err := p.check(&hedge); err != nil {
+			return err
+		}
+	}
+	return m.validateForModel(model, req.Config, len(req.Images))
+}
+
+// collectHedgeLoser waits for the canceled attempt of a hedged request and
+// accounts for its result if it succeeded anyway.
+func (m *Manager) collectHedgeLoser(attempts <-chan hedgeAttempt) {
+	a := <-attempts
+	if a.err != nil {
+		return
+	}
+
+	m.logger.Debug("hedge attempt succeeded after losing",
+		"model", string(a.req.Model),
+		"hedge", a.hedge,
+	)
+	m.recordStats(a.req, a.latency, a.result, nil)
+}
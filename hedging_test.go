@@ -0,0 +1,204 @@
+package imagegen_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+)
+
+func TestManager_Hedging(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	fastFailing := false
+	slow := blockingGenerator(release, "slow")
+	fast := flakyGenerator("fast", &fastFailing, errUnavailable)
+
+	var served imagegen.Model
+	var attempts int
+	manager := imagegen.NewManager(slow,
+		imagegen.WithDefaultModel("slow"),
+		imagegen.WithHedging(imagegen.HedgePolicy{Delay: 50 * time.Millisecond, MinDelay: time.Millisecond, Model: "fast"}),
+		imagegen.WithInterceptor(func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+			result, err := next(ctx, req)
+			served, attempts = req.Model, req.Attempts
+			return result, err
+		}),
+	)
+	manager.AddProvider(fast)
+	defer manager.Close()
+
+	if _, err := manager.Generate(context.Background(), "a cat", nil); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if served != "fast" || attempts != 2 {
+		t.Errorf("request reported model %s after %d attempts, want fast after 2", served, attempts)
+	}
+
+	// Both attempts reached their providers; the slow one was canceled
+	if got := len(slow.Calls()); got != 1 {
+		t.Errorf("slow provider received %d calls, want 1", got)
+	}
+	if got := len(fast.Calls()); got != 1 {
+		t.Errorf("fast provider received %d calls, want 1", got)
+	}
+	waitFor(t, "the slow attempt to be canceled", func() bool { return manager.Stats()["slow"].InFlight == 0 })
+
+	s := manager.Stats()["slow"]
+	if s.Hedges != 1 || s.HedgeWins != 1 {
+		t.Errorf("hedges %d, hedge wins %d, want 1 and 1", s.Hedges, s.HedgeWins)
+	}
+	// The hedge's latency is measured from when it was sent
+	fastStats := manager.Stats()["fast"]
+	if fastStats.Successes != 1 || fastStats.AverageLatency >= 50*time.Millisecond {
+		t.Errorf("fast model recorded %d successes with latency %v, want 1 under the hedge delay",
+			fastStats.Successes, fastStats.AverageLatency)
+	}
+}
+
+func TestManager_Hedging_NotNeeded(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing bool
+	}{
+		{"fast success", false},
+		{"fast failure", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := tt.failing
+			gen := flakyGenerator("primary", &failing, errUnavailable)
+			manager := imagegen.NewManager(gen,
+				imagegen.WithDefaultModel("primary"),
+				imagegen.WithHedging(imagegen.HedgePolicy{Delay: time.Hour}),
+			)
+			defer manager.Close()
+
+			_, err := manager.Generate(context.Background(), "a cat", nil)
+			if tt.failing != errors.Is(err, errUnavailable) {
+				t.Errorf("Generate() error = %v", err)
+			}
+			if got := len(gen.Calls()); got != 1 {
+				t.Errorf("provider received %d calls, want 1", got)
+			}
+			if got := manager.Stats()["primary"].Hedges; got != 0 {
+				t.Errorf("sent %d hedges, want 0", got)
+			}
+		})
+	}
+}
+
+func TestManager_Hedging_BothFail(t *testing.T) {
+	failing := true
+	errHedge := errors.New("hedge failed")
+	gen := flakyGenerator("primary", &failing, errUnavailable)
+	gen.GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		if len(gen.Calls()) == 1 {
+			// The original attempt outlasts the hedge delay
+			time.Sleep(20 * time.Millisecond)
+			return nil, errUnavailable
+		}
+		return nil, errHedge
+	}
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("primary"),
+		imagegen.WithHedging(imagegen.HedgePolicy{Delay: time.Millisecond, MinDelay: time.Millisecond}),
+	)
+	defer manager.Close()
+
+	// The original attempt's error is reported
+	if _, err := manager.Generate(context.Background(), "a cat", nil); !errors.Is(err, errUnavailable) {
+		t.Errorf("Generate() error = %v, want the original attempt's error", err)
+	}
+	if got := len(gen.Calls()); got != 2 {
+		t.Errorf("provider received %d calls, want 2", got)
+	}
+}
+
+func TestManager_Hedging_ShutdownDrainsLoser(t *testing.T) {
+	release := make(chan struct{})
+	fastFailing := false
+	slow := blockingGenerator(release, "slow")
+	slow.GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		// Ignores cancellation, like a provider call already on the wire
+		<-release
+		return nil, ctx.Err()
+	}
+	fast := flakyGenerator("fast", &fastFailing, errUnavailable)
+
+	manager := imagegen.NewManager(slow,
+		imagegen.WithDefaultModel("slow"),
+		imagegen.WithHedging(imagegen.HedgePolicy{Delay: 10 * time.Millisecond, MinDelay: time.Millisecond, Model: "fast"}),
+	)
+	manager.AddProvider(fast)
+
+	if _, err := manager.Generate(context.Background(), "a cat", nil); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	// The losing attempt is still running, so Shutdown cannot drain
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := manager.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Shutdown to wait for the losing attempt, got %v", err)
+	}
+	close(release)
+}
+
+func TestManager_Hedging_DelayIgnoresFailures(t *testing.T) {
+	failing := true
+	gen := flakyGenerator("primary", &failing, errUnavailable)
+	generate := gen.GenerateFunc
+	gen.GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		if !failing {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return generate(ctx, prompt, config)
+	}
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("primary"),
+		imagegen.WithHedging(imagegen.HedgePolicy{MinSamples: 5, Delay: time.Hour, MinDelay: time.Millisecond}),
+	)
+	defer manager.Close()
+	ctx := context.Background()
+
+	// Instant failures do not count as latency samples, so the hedge delay
+	// is still Delay rather than MinDelay
+	for range 10 {
+		manager.Generate(ctx, "a cat", nil)
+	}
+	failing = false
+	if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if got := manager.Stats()["primary"].Hedges; got != 0 {
+		t.Errorf("sent %d hedges, want 0", got)
+	}
+}
+
+func TestManager_Hedging_UnservableModel(t *testing.T) {
+	failing := false
+	gen := flakyGenerator("primary", &failing, errUnavailable)
+	generate := gen.GenerateFunc
+	gen.GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		time.Sleep(20 * time.Millisecond)
+		return generate(ctx, prompt, config)
+	}
+	manager := imagegen.NewManager(gen,
+		imagegen.WithDefaultModel("primary"),
+		imagegen.WithHedging(imagegen.HedgePolicy{Delay: time.Millisecond, MinDelay: time.Millisecond, Model: "missing"}),
+	)
+	defer manager.Close()
+
+	// The hedge model is not registered, so the original attempt is left to finish
+	if _, err := manager.Generate(context.Background(), "a cat", nil); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if got := manager.Stats()["primary"].Hedges; got != 0 {
+		t.Errorf("sent %d hedges, want 0", got)
+	}
+}
@@ -0,0 +1,239 @@
+package imagegentest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// Factory creates a fresh generator for each conformance subtest.
+// Use t.Cleanup to release anything the generator depends on.
+type Factory func(t *testing.T) imagegen.ImageGenerator
+
+// RunConformance runs the ImageGenerator conformance suite against the
+// generators returned by factory. Generators that implement
+// ConversationalImageGenerator are also checked for conversation semantics.
+//
+// The contracts checked are:
+//   - Models returns at least one model with a name, provider and API name.
+//   - Generate, Edit and EditMultiple accept a nil config and use the default model.
+//   - Every model accepts its APIModelName as GenerateConfig.Model.
+//   - Results contain at least one image with data, a MIME type and sequential indexes.
+//   - Empty prompts fail with ErrEmptyPrompt, and invalid or missing images
+//     fail with the matching validation error, before any request is made.
+//   - A cancelled context fails with an error matching context.Canceled.
+//   - Close returns nil and may be called more than once.
+//   - Conversations record a user and a model turn per successful Send,
+//     return history copies, leave history unchanged on failure, are
+//     independent of each other, and are emptied by Clear.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Helper()
+
+	t.Run("Models", func(t *testing.T) {
+		models := factory(t).Models()
+		if len(models) == 0 {
+			t.Fatal("Models() returned no models")
+		}
+		for i, info := range models {
+			if info.Name == "" || info.Provider == "" || info.APIModelName == "" {
+				t.Errorf("model %d must have Name, Provider and APIModelName: %+v", i, info)
+			}
+		}
+	})
+
+	t.Run("NilConfig", func(t *testing.T) {
+		gen := factory(t)
+		ctx := context.Background()
+
+		result, err := gen.Generate(ctx, "A red circle on a white background", nil)
+		checkResult(t, "Generate", result, err)
+
+		result, err = gen.Edit(ctx, TestImage(), "Make the circle blue", nil)
+		checkResult(t, "Edit", result, err)
+
+		result, err = gen.EditMultiple(ctx, []imagegen.InputImage{TestImage(), TestImage()}, "Combine these", nil)
+		checkResult(t, "EditMultiple", result, err)
+	})
+
+	t.Run("EachModel", func(t *testing.T) {
+		gen := factory(t)
+		for _, info := range gen.Models() {
+			config := &imagegen.GenerateConfig{Model: imagegen.Model(info.APIModelName)}
+			result, err := gen.Generate(context.Background(), "A red circle", config)
+			checkResult(t, "Generate with "+info.APIModelName, result, err)
+		}
+	})
+
+	t.Run("EmptyPrompt", func(t *testing.T) {
+		gen := factory(t)
+		ctx := context.Background()
+
+		_, err := gen.Generate(ctx, "", nil)
+		expectError(t, "Generate", err, imagegen.ErrEmptyPrompt)
+
+		_, err = gen.Edit(ctx, TestImage(), "", nil)
+		expectError(t, "Edit", err, imagegen.ErrEmptyPrompt)
+
+		_, err = gen.EditMultiple(ctx, []imagegen.InputImage{TestImage()}, "", nil)
+		expectError(t, "EditMultiple", err, imagegen.ErrEmptyPrompt)
+	})
+
+	t.Run("InvalidImages", func(t *testing.T) {
+		gen := factory(t)
+		ctx := context.Background()
+
+		_, err := gen.Edit(ctx, imagegen.InputImage{}, "Edit", nil)
+		expectError(t, "Edit with empty image", err, imagegen.ErrEmptyImageData)
+
+		_, err = gen.Edit(ctx, imagegen.InputImage{Data: TinyPNG, MIMEType: "text/plain"}, "Edit", nil)
+		expectError(t, "Edit with invalid MIME type", err, imagegen.ErrInvalidMIMEType)
+
+		_, err = gen.EditMultiple(ctx, nil, "Edit", nil)
+		expectError(t, "EditMultiple with no images", err, imagegen.ErrEmptyImageData)
+
+		tooMany := make([]imagegen.InputImage, imagegen.MaxInputImages+1)
+		for i := range tooMany {
+			tooMany[i] = TestImage()
+		}
+		_, err = gen.EditMultiple(ctx, tooMany, "Edit", nil)
+		expectError(t, "EditMultiple with too many images", err, imagegen.ErrTooManyImages)
+	})
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		gen := factory(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := gen.Generate(ctx, "A red circle", nil)
+		expectError(t, "Generate", err, context.Canceled)
+
+		_, err = gen.Edit(ctx, TestImage(), "Make it blue", nil)
+		expectError(t, "Edit", err, context.Canceled)
+
+		_, err = gen.EditMultiple(ctx, []imagegen.InputImage{TestImage()}, "Make it blue", nil)
+		expectError(t, "EditMultiple", err, context.Canceled)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		gen := factory(t)
+		if err := gen.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+		if err := gen.Close(); err != nil {
+			t.Errorf("second Close() error = %v", err)
+		}
+	})
+
+	if _, ok := factory(t).(imagegen.ConversationalImageGenerator); !ok {
+		return
+	}
+
+	t.Run("Conversation", func(t *testing.T) {
+		gen := factory(t).(imagegen.ConversationalImageGenerator)
+		ctx := context.Background()
+		conv := gen.StartConversation()
+
+		result, err := conv.Send(ctx, "A red circle", nil, nil)
+		checkResult(t, "first Send", result, err)
+		checkHistory(t, conv.History(), "A red circle")
+
+		result, err = conv.Send(ctx, "Make it blue", []imagegen.InputImage{TestImage()}, nil)
+		checkResult(t, "second Send", result, err)
+		history := checkHistory(t, conv.History(), "A red circle", "Make it blue")
+		if len(history) == 4 && len(history[2].Images) != 1 {
+			t.Errorf("user turn should record 1 input image, got %d", len(history[2].Images))
+		}
+
+		// History returns a copy
+		history[0].Text = "modified"
+		if conv.History()[0].Text != "A red circle" {
+			t.Error("modifying the slice returned by History() must not change the conversation")
+		}
+
+		// Other conversations are independent
+		if other := gen.StartConversation().History(); len(other) != 0 {
+			t.Errorf("new conversation should have empty history, got %d turns", len(other))
+		}
+
+		conv.Clear()
+		if got := len(conv.History()); got != 0 {
+			t.Errorf("history after Clear() has %d turns, want 0", got)
+		}
+
+		result, err = conv.Send(ctx, "A green square", nil, nil)
+		checkResult(t, "Send after Clear", result, err)
+		checkHistory(t, conv.History(), "A green square")
+	})
+
+	t.Run("ConversationFailures", func(t *testing.T) {
+		gen := factory(t).(imagegen.ConversationalImageGenerator)
+		conv := gen.StartConversation()
+
+		result, err := conv.Send(context.Background(), "A red circle", nil, nil)
+		checkResult(t, "Send", result, err)
+
+		_, err = conv.Send(context.Background(), "", nil, nil)
+		expectError(t, "Send with no prompt or images", err, imagegen.ErrEmptyPrompt)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = conv.Send(ctx, "Make it blue", nil, nil)
+		expectError(t, "Send with cancelled context", err, context.Canceled)
+
+		checkHistory(t, conv.History(), "A red circle")
+	})
+}
+
+func checkResult(t *testing.T, op string, result *imagegen.GenerateResult, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Errorf("%s: unexpected error: %v", op, err)
+		return
+	}
+	if result == nil {
+		t.Errorf("%s: nil result without error", op)
+		return
+	}
+	if len(result.Images) == 0 {
+		t.Errorf("%s: result has no images", op)
+	}
+	for i, img := range result.Images {
+		if len(img.Data) == 0 || img.MIMEType == "" {
+			t.Errorf("%s: image %d must have Data and MIMEType", op, i)
+		}
+		if img.Index != i {
+			t.Errorf("%s: image %d has Index %d", op, i, img.Index)
+		}
+	}
+}
+
+func expectError(t *testing.T, op string, err, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Errorf("%s: error = %v, want %v", op, err, want)
+	}
+}
+
+// checkHistory verifies that history holds a user and a model turn for each prompt.
+func checkHistory(t *testing.T, history []imagegen.ConversationTurn, prompts ...string) []imagegen.ConversationTurn {
+	t.Helper()
+
+	if len(history) != 2*len(prompts) {
+		t.Errorf("history has %d turns, want %d", len(history), 2*len(prompts))
+		return history
+	}
+	for i, prompt := range prompts {
+		user, model := history[2*i], history[2*i+1]
+		if user.Role != "user" || user.Text != prompt {
+			t.Errorf("turn %d: got %s %q, want user %q", 2*i, user.Role, user.Text, prompt)
+		}
+		if model.Role != "model" {
+			t.Errorf("turn %d: got role %s, want model", 2*i+1, model.Role)
+		}
+	}
+	return history
+}
@@ -0,0 +1,261 @@
+// Package imagegentest provides utilities for testing ImageGenerator
+// implementations and code that uses them: a configurable mock generator and
+// a conformance suite for providers.
+package imagegentest
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// MockProvider identifies the mock provider.
+const MockProvider imagegen.Provider = "mock"
+
+// MockModelInfo is the model reported by a MockGenerator without ModelsFunc.
+var MockModelInfo = imagegen.ModelInfo{
+	Name:         "mock-model",
+	Provider:     MockProvider,
+	APIModelName: "mock-model-api",
+	Capabilities: imagegen.ModelCapabilities{
+		SupportsTextToImage:  true,
+		SupportsImageEditing: true,
+		SupportsMultiImage:   true,
+		SupportsConversation: true,
+		MaxInputImages:       imagegen.MaxInputImages,
+		MaxOutputImages:      1,
+	},
+}
+
+// Call records a single call made to a mock.
+type Call struct {
+	// Operation is the method that was called
+	Operation imagegen.Operation
+	Prompt    string
+	Images    []imagegen.InputImage
+	Config    *imagegen.GenerateConfig
+}
+
+// MockGenerator is a configurable mock implementation of ImageGenerator.
+//
+// Inputs are validated like a real provider before any func is called.
+// Without a func, each call returns DefaultResult, or the context's error if
+// it is already done. Every call is recorded and available from Calls.
+type MockGenerator struct {
+	GenerateFunc     func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error)
+	EditFunc         func(ctx context.Context, image imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error)
+	EditMultipleFunc func(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error)
+	ModelsFunc       func() []imagegen.ModelInfo
+	CloseFunc        func() error
+
+	calls []Call
+	mu    sync.Mutex
+}
+
+// Ensure MockGenerator implements ImageGenerator.
+var _ imagegen.ImageGenerator = (*MockGenerator)(nil)
+
+func (m *MockGenerator) Generate(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	m.record(Call{Operation: imagegen.OperationGenerate, Prompt: prompt, Config: config})
+
+	if err := imagegen.ValidatePrompt(prompt); err != nil {
+		return nil, err
+	}
+	if m.GenerateFunc != nil {
+		return m.GenerateFunc(ctx, prompt, config)
+	}
+	return defaultResponse(ctx)
+}
+
+func (m *MockGenerator) Edit(ctx context.Context, image imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	m.record(Call{Operation: imagegen.OperationEdit, Prompt: instruction, Images: []imagegen.InputImage{image}, Config: config})
+
+	if err := imagegen.ValidatePrompt(instruction); err != nil {
+		return nil, err
+	}
+	if err := imagegen.ValidateInputImage(image); err != nil {
+		return nil, err
+	}
+	if m.EditFunc != nil {
+		return m.EditFunc(ctx, image, instruction, config)
+	}
+	return defaultResponse(ctx)
+}
+
+func (m *MockGenerator) EditMultiple(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	m.record(Call{Operation: imagegen.OperationEditMultiple, Prompt: instruction, Images: images, Config: config})
+
+	if err := imagegen.ValidatePrompt(instruction); err != nil {
+		return nil, err
+	}
+	if err := imagegen.ValidateInputImages(images); err != nil {
+		return nil, err
+	}
+	if m.EditMultipleFunc != nil {
+		return m.EditMultipleFunc(ctx, images, instruction, config)
+	}
+	return defaultResponse(ctx)
+}
+
+func (m *MockGenerator) Models() []imagegen.ModelInfo {
+	if m.ModelsFunc != nil {
+		return m.ModelsFunc()
+	}
+	return []imagegen.ModelInfo{MockModelInfo}
+}
+
+func (m *MockGenerator) Close() error {
+	if m.CloseFunc != nil {
+		return m.CloseFunc()
+	}
+	return nil
+}
+
+// Calls returns the calls made so far, in order.
+func (m *MockGenerator) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calls := make([]Call, len(m.calls))
+	copy(calls, m.calls)
+	return calls
+}
+
+// Reset clears the recorded calls.
+func (m *MockGenerator) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+}
+
+func (m *MockGenerator) record(call Call) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, call)
+}
+
+// MockConversationalGenerator is a MockGenerator that also implements
+// ConversationalImageGenerator.
+//
+// Conversations track history themselves and delegate responses to SendFunc,
+// or return DefaultResult. Sends are recorded in the generator's Calls.
+type MockConversationalGenerator struct {
+	MockGenerator
+
+	SendFunc func(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error)
+}
+
+// Ensure MockConversationalGenerator implements ConversationalImageGenerator.
+var _ imagegen.ConversationalImageGenerator = (*MockConversationalGenerator)(nil)
+
+func (m *MockConversationalGenerator) StartConversation() imagegen.Conversation {
+	return &MockConversation{generator: m}
+}
+
+// MockConversation is the Conversation returned by MockConversationalGenerator.
+// Failed turns leave the history unchanged.
+type MockConversation struct {
+	generator *MockConversationalGenerator
+	history   []imagegen.ConversationTurn
+
+	mu sync.Mutex
+}
+
+func (c *MockConversation) Send(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generator.record(Call{Operation: imagegen.OperationConversation, Prompt: prompt, Images: images, Config: config})
+
+	if len(images) == 0 {
+		if err := imagegen.ValidatePrompt(prompt); err != nil {
+			return nil, err
+		}
+	} else if err := imagegen.ValidateInputImages(images); err != nil {
+		return nil, err
+	}
+
+	var result *imagegen.GenerateResult
+	var err error
+	if c.generator.SendFunc != nil {
+		result, err = c.generator.SendFunc(ctx, prompt, images, config)
+	} else {
+		result, err = defaultResponse(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userTurn := imagegen.ConversationTurn{Role: "user", Text: prompt}
+	for _, img := range images {
+		userTurn.Images = append(userTurn.Images, imagegen.GeneratedImage{
+			Data:     img.Data,
+			MIMEType: img.MIMEType,
+		})
+	}
+	c.history = append(c.history, userTurn, imagegen.ConversationTurn{
+		Role:   "model",
+		Text:   result.Text,
+		Images: result.Images,
+	})
+
+	return result, nil
+}
+
+func (c *MockConversation) History() []imagegen.ConversationTurn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	historyCopy := make([]imagegen.ConversationTurn, len(c.history))
+	copy(historyCopy, c.history)
+	return historyCopy
+}
+
+func (c *MockConversation) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.history = nil
+}
+
+// TinyPNG is a valid 1x1 PNG image.
+var TinyPNG = func() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}()
+
+// TestImage returns a valid InputImage for use in tests.
+func TestImage() imagegen.InputImage {
+	return imagegen.InputImage{Data: TinyPNG, MIMEType: "image/png"}
+}
+
+// DefaultResult returns the result mocks produce when no func is set:
+// a single TinyPNG image with usage metadata.
+func DefaultResult() *imagegen.GenerateResult {
+	return &imagegen.GenerateResult{
+		Images: []imagegen.GeneratedImage{{Data: TinyPNG, MIMEType: "image/png"}},
+		UsageMetadata: &imagegen.UsageMetadata{
+			PromptTokens:     10,
+			CandidatesTokens: 1120,
+			TotalTokens:      1130,
+			ImageCount:       1,
+		},
+	}
+}
+
+func defaultResponse(ctx context.Context) (*imagegen.GenerateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return DefaultResult(), nil
+}
@@ -0,0 +1,49 @@
+package imagegentest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+)
+
+func TestConformance_MockGenerator(t *testing.T) {
+	RunConformance(t, func(t *testing.T) imagegen.ImageGenerator {
+		return &MockGenerator{}
+	})
+}
+
+func TestConformance_MockConversationalGenerator(t *testing.T) {
+	RunConformance(t, func(t *testing.T) imagegen.ImageGenerator {
+		return &MockConversationalGenerator{}
+	})
+}
+
+func TestMockGenerator_RecordsCalls(t *testing.T) {
+	mock := &MockConversationalGenerator{}
+	ctx := context.Background()
+	config := &imagegen.GenerateConfig{Model: "mock-model-api"}
+
+	mock.Generate(ctx, "a", config)
+	mock.Edit(ctx, TestImage(), "b", nil)
+	mock.StartConversation().Send(ctx, "c", nil, nil)
+
+	calls := mock.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 calls, got %d", len(calls))
+	}
+	want := []imagegen.Operation{imagegen.OperationGenerate, imagegen.OperationEdit, imagegen.OperationConversation}
+	for i, call := range calls {
+		if call.Operation != want[i] {
+			t.Errorf("call %d: operation %s, want %s", i, call.Operation, want[i])
+		}
+	}
+	if calls[0].Config != config || calls[1].Prompt != "b" || len(calls[1].Images) != 1 {
+		t.Errorf("calls not recorded faithfully: %+v", calls)
+	}
+
+	mock.Reset()
+	if len(mock.Calls()) != 0 {
+		t.Error("Reset should clear recorded calls")
+	}
+}
@@ -0,0 +1,109 @@
+package imagegen
+
+import (
+	"context"
+	"time"
+)
+
+// Operation identifies the kind of request handled by an ImageGenerator.
+type Operation string
+
+const (
+	OperationGenerate     Operation = "generate"
+	OperationEdit         Operation = "edit"
+	OperationEditMultiple Operation = "edit_multiple"
+	OperationConversation Operation = "conversation"
+)
+
+// label returns the name used for the operation in log messages.
+func (op Operation) label() string {
+	switch op {
+	case OperationGenerate:
+		return "generation"
+	case OperationEdit:
+		return "edit"
+	case OperationEditMultiple:
+		return "multi-edit"
+	case OperationConversation:
+		return "conversation turn"
+	default:
+		return string(op)
+	}
+}
+
+// Request is the normalized form of a Manager operation, as seen by interceptors.
+type Request struct {
+	// Operation is the Manager method that produced the request.
+	Operation Operation
+
+	// Model is the resolved model. An interceptor may change it to reroute
+	// the request.
+	Model Model
+
+	// Provider serving Model, or empty if the model is not registered.
+	// It is updated when the request is routed.
+	Provider Provider
+
+	// Tenant the request is made for, from the context (see WithTenant) or
+	// Config.Metadata[MetadataTenant]. Empty if unknown.
+	Tenant string
+
+	// Prompt is the prompt or edit instruction.
+	Prompt string
+
+	// Images are the input images, if any.
+	Images []InputImage
+
+	// Config is a copy of the caller's config and is never nil.
+	// Config.Model is ignored in favor of Model.
+	Config *GenerateConfig
+
+	// History holds the turns before this one for OperationConversation.
+	// It must not be modified.
+	History []ConversationTurn
+
+	// RateLimitWait is the time spent checking tenant quotas and rate limits,
+	// including any wait (see GenerateConfig.WaitOnRateLimit). It is set by
+	// the Manager before the provider is called, including for requests the
+	// limits refuse; requests that do not wait spend close to zero.
+	RateLimitWait time.Duration
+
+	// Attempts is how many times the Manager tried to serve the request,
+	// counting each fallback model and each hedge. It is set once the
+	// Manager's handler returns.
+	Attempts int
+
+	// latency, if set, is recorded in Stats instead of the time spent in the
+	// handler, for a request answered by one attempt of a hedged request.
+	latency time.Duration
+}
+
+// Handler executes a Request.
+type Handler func(ctx context.Context, req *Request) (*GenerateResult, error)
+
+// Interceptor wraps the handling of every Manager operation.
+//
+// An interceptor may inspect or modify req before calling next, inspect or
+// replace the result and error afterwards, or return without calling next to
+// short-circuit the request (e.g. a cache hit or an auth failure).
+// Interceptors run before validation, rate limiting and routing.
+type Interceptor func(ctx context.Context, req *Request, next Handler) (*GenerateResult, error)
+
+// ChainInterceptors composes interceptors into one. The first interceptor is
+// the outermost.
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(ctx context.Context, req *Request, next Handler) (*GenerateResult, error) {
+		return wrapHandler(next, interceptors)(ctx, req)
+	}
+}
+
+// wrapHandler wraps h with interceptors, the first being the outermost.
+func wrapHandler(h Handler, interceptors []Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx context.Context, req *Request) (*GenerateResult, error) {
+			return interceptor(ctx, req, next)
+		}
+	}
+	return h
+}
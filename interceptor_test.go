@@ -0,0 +1,210 @@
+package imagegen_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+func TestInterceptor_SeesEveryOperation(t *testing.T) {
+	mockGen := &imagegentest.MockConversationalGenerator{}
+
+	var seen []imagegen.Request
+	manager := imagegen.NewManager(mockGen,
+		imagegen.WithDefaultModel(imagegen.Model(imagegentest.MockModelInfo.Name)),
+		imagegen.WithInterceptor(func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+			seen = append(seen, *req)
+			return next(ctx, req)
+		}),
+	)
+	defer manager.Close()
+
+	ctx := context.Background()
+	img := imagegentest.TestImage()
+
+	if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := manager.Edit(ctx, img, "make it blue", nil); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	if _, err := manager.EditMultiple(ctx, []imagegen.InputImage{img, img}, "combine", nil); err != nil {
+		t.Fatalf("EditMultiple: %v", err)
+	}
+
+	conv := manager.StartConversation()
+	if _, err := conv.Send(ctx, "first", nil, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, err := conv.Send(ctx, "second", nil, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	tests := []struct {
+		operation imagegen.Operation
+		prompt    string
+		images    int
+		history   int
+	}{
+		{imagegen.OperationGenerate, "a cat", 0, 0},
+		{imagegen.OperationEdit, "make it blue", 1, 0},
+		{imagegen.OperationEditMultiple, "combine", 2, 0},
+		{imagegen.OperationConversation, "first", 0, 0},
+		{imagegen.OperationConversation, "second", 0, 2},
+	}
+
+	if len(seen) != len(tests) {
+		t.Fatalf("interceptor saw %d requests, want %d", len(seen), len(tests))
+	}
+	for i, tt := range tests {
+		req := seen[i]
+		if req.Operation != tt.operation {
+			t.Errorf("request %d: operation %s, want %s", i, req.Operation, tt.operation)
+		}
+		if req.Prompt != tt.prompt {
+			t.Errorf("request %d: prompt %q, want %q", i, req.Prompt, tt.prompt)
+		}
+		if len(req.Images) != tt.images {
+			t.Errorf("request %d: %d images, want %d", i, len(req.Images), tt.images)
+		}
+		if len(req.History) != tt.history {
+			t.Errorf("request %d: %d history turns, want %d", i, len(req.History), tt.history)
+		}
+		if req.Model != imagegen.Model(imagegentest.MockModelInfo.Name) {
+			t.Errorf("request %d: model %q not resolved", i, req.Model)
+		}
+		if req.Provider != imagegentest.MockProvider {
+			t.Errorf("request %d: provider %q, want %q", i, req.Provider, imagegentest.MockProvider)
+		}
+		if req.Config == nil {
+			t.Errorf("request %d: nil config", i)
+		}
+	}
+}
+
+func TestInterceptor_Order(t *testing.T) {
+	var order []string
+	trace := func(name string) imagegen.Interceptor {
+		return func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+			order = append(order, name+" before")
+			result, err := next(ctx, req)
+			order = append(order, name+" after")
+			return result, err
+		}
+	}
+
+	manager := imagegen.NewManager(&imagegentest.MockGenerator{},
+		imagegen.WithDefaultModel(imagegen.Model(imagegentest.MockModelInfo.Name)),
+		imagegen.WithInterceptor(trace("a")),
+	)
+	defer manager.Close()
+	manager.Use(imagegen.ChainInterceptors(trace("b"), trace("c")))
+
+	if _, err := manager.Generate(context.Background(), "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	want := []string{"a before", "b before", "c before", "c after", "b after", "a after"}
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestInterceptor_ShortCircuit(t *testing.T) {
+	mockGen := &imagegentest.MockGenerator{}
+	cached := imagegentest.DefaultResult()
+
+	manager := imagegen.NewManager(mockGen,
+		imagegen.WithDefaultModel(imagegen.Model(imagegentest.MockModelInfo.Name)),
+		imagegen.WithInterceptor(func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+			return cached, nil
+		}),
+	)
+	defer manager.Close()
+
+	// A limiter with no capacity shows the rate limit step was skipped
+	manager.SetRateLimiter(imagegen.Model(imagegentest.MockModelInfo.Name), ratelimiter.New(1, 1))
+
+	result, err := manager.Generate(context.Background(), "a cat", nil)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if result != cached {
+		t.Error("expected the interceptor's result")
+	}
+	if calls := mockGen.Calls(); len(calls) != 0 {
+		t.Errorf("provider called %d times, want 0", len(calls))
+	}
+}
+
+func TestInterceptor_ModifiesRequest(t *testing.T) {
+	first := &imagegentest.MockGenerator{}
+	second := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo {
+			return []imagegen.ModelInfo{{Name: "other-model", Provider: "other", APIModelName: "other-model-api"}}
+		},
+	}
+
+	manager := imagegen.NewManager(first,
+		imagegen.WithDefaultModel(imagegen.Model(imagegentest.MockModelInfo.Name)),
+		imagegen.WithInterceptor(func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+			req.Prompt = "[safe] " + req.Prompt
+			req.Model = "other-model"
+			return next(ctx, req)
+		}),
+	)
+	defer manager.Close()
+	manager.AddProvider(second)
+
+	config := &imagegen.GenerateConfig{Model: imagegen.Model(imagegentest.MockModelInfo.Name)}
+	if _, err := manager.Generate(context.Background(), "a cat", config); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	if calls := first.Calls(); len(calls) != 0 {
+		t.Errorf("original provider called %d times, want 0", len(calls))
+	}
+	calls := second.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("rerouted provider called %d times, want 1", len(calls))
+	}
+	if calls[0].Prompt != "[safe] a cat" {
+		t.Errorf("prompt = %q, want rewritten prompt", calls[0].Prompt)
+	}
+	if calls[0].Config.Model != "other-model-api" {
+		t.Errorf("config model = %q, want other-model-api", calls[0].Config.Model)
+	}
+	if config.Model != imagegen.Model(imagegentest.MockModelInfo.Name) {
+		t.Error("caller's config was modified")
+	}
+}
+
+func TestInterceptor_SeesErrors(t *testing.T) {
+	var seen error
+	manager := imagegen.NewManager(&imagegentest.MockGenerator{},
+		imagegen.WithDefaultModel(imagegen.Model(imagegentest.MockModelInfo.Name)),
+		imagegen.WithInterceptor(func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+			result, err := next(ctx, req)
+			seen = err
+			return result, err
+		}),
+	)
+	defer manager.Close()
+
+	_, err := manager.Generate(context.Background(), "a cat", &imagegen.GenerateConfig{Model: "unknown-model"})
+	if !errors.Is(err, imagegen.ErrModelNotRegistered) {
+		t.Fatalf("expected ErrModelNotRegistered, got %v", err)
+	}
+	if !errors.Is(seen, imagegen.ErrModelNotRegistered) {
+		t.Errorf("interceptor saw %v", seen)
+	}
+}
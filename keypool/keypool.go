@@ -0,0 +1,488 @@
+// Package keypool provides an ImageGenerator that spreads requests across
+// several API keys.
+//
+// Each key is an ordinary ImageGenerator created with its own API key, with
+// an optional limiter enforcing that key's quota. The pool picks a key per
+// request, benches keys that are rate limited or refused, and fails over to
+// the next key:
+//
+//	pool, err := keypool.New([]keypool.Key{
+//	    {Name: "project-a", Generator: genA},
+//	    {Name: "project-b", Generator: genB},
+//	}, keypool.WithStrategy(keypool.LeastLoaded))
+//
+// For Gemini, gemini.NewPool creates the generators from a list of keys.
+package keypool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+// ErrNoKeyAvailable is wrapped by the RateLimitError returned when every key
+// is benched or out of quota.
+var ErrNoKeyAvailable = errors.New("keypool: no key available")
+
+// LimitTypeKeys is the RateLimitError.LimitType when no key is available.
+const LimitTypeKeys = "keys"
+
+// Strategy selects which key serves a request.
+type Strategy int
+
+const (
+	// RoundRobin uses the keys in turn.
+	RoundRobin Strategy = iota
+
+	// LeastLoaded uses the key with the fewest requests in flight, taking
+	// the keys in turn when there is a tie.
+	LeastLoaded
+)
+
+// Key is one API key in the pool.
+type Key struct {
+	// Name identifies the key in Usage. It should not be the key itself.
+	Name string
+
+	// Generator sends requests with the key.
+	Generator imagegen.ImageGenerator
+
+	// Limiter enforces the key's quota (optional). Keys without one use the
+	// limits set with WithKeyLimits, if any.
+	Limiter ratelimiter.Limiter
+}
+
+// KeyUsage is a key's usage since the pool was created.
+type KeyUsage struct {
+	Name string
+
+	Requests    int // requests sent with the key
+	Successes   int
+	RateLimited int // requests refused with a RateLimitError
+	Forbidden   int // requests refused with status 401 or 403
+	Failures    int // requests that failed for other reasons
+	Tokens      int // estimated input tokens sent
+	InFlight    int
+
+	// BenchedUntil is when the key will be used again, or zero if it is
+	// not benched.
+	BenchedUntil time.Time
+
+	// Limits is the state of the key's limiter, or empty if it has none.
+	Limits ratelimiter.Snapshot
+}
+
+// Option configures a Pool.
+type Option func(*Pool)
+
+// WithStrategy sets how keys are selected. The default is RoundRobin.
+func WithStrategy(strategy Strategy) Option {
+	return func(p *Pool) {
+		p.strategy = strategy
+	}
+}
+
+// WithKeyLimits gives every key without a Limiter its own limiter enforcing
+// limits, created with factory (imagegen.LocalLimiter if nil).
+func WithKeyLimits(limits imagegen.RateLimits, factory imagegen.LimiterFactory) Option {
+	return func(p *Pool) {
+		if factory == nil {
+			factory = imagegen.LocalLimiter
+		}
+		p.limiterFactory = func() ratelimiter.Limiter { return factory(limits) }
+	}
+}
+
+// WithBenchDuration sets how long a key is benched after a rate limit error
+// without a RetryAfter, and after a 401 or 403 response. The defaults are a
+// minute and ten minutes.
+func WithBenchDuration(rateLimited, forbidden time.Duration) Option {
+	return func(p *Pool) {
+		p.rateLimitedBench = rateLimited
+		p.forbiddenBench = forbidden
+	}
+}
+
+// WithTokenEstimator sets how request tokens are estimated for the key
+// limiters. The default is imagegen.NewSimpleTokenEstimator.
+func WithTokenEstimator(estimator imagegen.TokenEstimator) Option {
+	return func(p *Pool) {
+		p.estimator = estimator
+	}
+}
+
+// WithClock sets the clock used for benching. The default is
+// ratelimiter.SystemClock.
+func WithClock(clock ratelimiter.Clock) Option {
+	return func(p *Pool) {
+		p.clock = clock
+	}
+}
+
+// Pool is an ImageGenerator that sends each request with one of several keys.
+//
+// Models reports the first key's models with their RateLimits multiplied by
+// the number of keys, so a Manager's model limits match the pool's combined
+// quota while the key limiters enforce each key's share.
+type Pool struct {
+	keys []*key
+
+	strategy         Strategy
+	limiterFactory   func() ratelimiter.Limiter
+	rateLimitedBench time.Duration
+	forbiddenBench   time.Duration
+	estimator        imagegen.TokenEstimator
+	clock            ratelimiter.Clock
+
+	next int // index of the next key in turn
+	mu   sync.Mutex
+}
+
+// key is a Key and its usage.
+type key struct {
+	Key
+	usage KeyUsage
+}
+
+// Ensure Pool implements the interfaces.
+var (
+	_ imagegen.ImageGenerator               = (*Pool)(nil)
+	_ imagegen.ConversationalImageGenerator = (*Pool)(nil)
+)
+
+// New creates a Pool of keys. Keys without a Name are named "key-1",
+// "key-2" and so on, by position.
+func New(keys []Key, opts ...Option) (*Pool, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keypool: no keys")
+	}
+
+	p := &Pool{
+		rateLimitedBench: time.Minute,
+		forbiddenBench:   10 * time.Minute,
+		estimator:        imagegen.NewSimpleTokenEstimator(),
+		clock:            ratelimiter.SystemClock,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	names := make(map[string]bool, len(keys))
+	for i, k := range keys {
+		if k.Generator == nil {
+			return nil, fmt.Errorf("keypool: key %d has no generator", i+1)
+		}
+		if k.Name == "" {
+			k.Name = fmt.Sprintf("key-%d", i+1)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("keypool: duplicate key name %q", k.Name)
+		}
+		names[k.Name] = true
+
+		if k.Limiter == nil && p.limiterFactory != nil {
+			k.Limiter = p.limiterFactory()
+		}
+		p.keys = append(p.keys, &key{Key: k, usage: KeyUsage{Name: k.Name}})
+	}
+
+	return p, nil
+}
+
+// Generate creates images from a text prompt.
+func (p *Pool) Generate(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	tokens := imagegen.EstimateInputTokens(p.estimator, prompt, nil)
+	return p.invoke(ctx, config, tokens, func(gen imagegen.ImageGenerator) (*imagegen.GenerateResult, error) {
+		return gen.Generate(ctx, prompt, config)
+	})
+}
+
+// Edit modifies an existing image based on a text instruction.
+func (p *Pool) Edit(ctx context.Context, image imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	tokens := imagegen.EstimateInputTokens(p.estimator, instruction, []imagegen.InputImage{image})
+	return p.invoke(ctx, config, tokens, func(gen imagegen.ImageGenerator) (*imagegen.GenerateResult, error) {
+		return gen.Edit(ctx, image, instruction, config)
+	})
+}
+
+// EditMultiple performs editing with multiple reference images.
+func (p *Pool) EditMultiple(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	tokens := imagegen.EstimateInputTokens(p.estimator, instruction, images)
+	return p.invoke(ctx, config, tokens, func(gen imagegen.ImageGenerator) (*imagegen.GenerateResult, error) {
+		return gen.EditMultiple(ctx, images, instruction, config)
+	})
+}
+
+// Models returns the first key's models, with RateLimits multiplied by the
+// number of keys.
+func (p *Pool) Models() []imagegen.ModelInfo {
+	models := p.keys[0].Generator.Models()
+	n := len(p.keys)
+
+	pooled := make([]imagegen.ModelInfo, len(models))
+	for i, info := range models {
+		info.RateLimits.TokensPerMinute *= n
+		info.RateLimits.RequestsPerMinute *= n
+		info.RateLimits.TokensPerDay *= n
+		pooled[i] = info
+	}
+	return pooled
+}
+
+// Close closes every key's generator.
+func (p *Pool) Close() error {
+	var errs []error
+	for _, k := range p.keys {
+		if err := k.Generator.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing key %s: %w", k.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StartConversation begins a conversation. If the keys' generators support
+// conversations natively, the conversation stays on the key that is next in
+// turn, since its history belongs to that key's generator; otherwise it is
+// emulated on top of the pool and each turn may use a different key.
+func (p *Pool) StartConversation() imagegen.Conversation {
+	p.mu.Lock()
+	k := p.orderLocked()[0]
+	p.mu.Unlock()
+
+	convGen, ok := k.Generator.(imagegen.ConversationalImageGenerator)
+	if !ok {
+		return imagegen.NewEmulatedConversation(p, imagegen.DefaultEmulationOptions())
+	}
+	return &conversation{pool: p, key: k, inner: convGen.StartConversation()}
+}
+
+// Usage returns each key's usage, in the order the keys were given.
+func (p *Pool) Usage() []KeyUsage {
+	p.mu.Lock()
+	now := p.clock.Now()
+	usage := make([]KeyUsage, len(p.keys))
+	for i, k := range p.keys {
+		usage[i] = k.usage
+		if !now.Before(k.usage.BenchedUntil) {
+			usage[i].BenchedUntil = time.Time{}
+		}
+	}
+	p.mu.Unlock()
+
+	// Limiters are read without holding p.mu, as they may be slow or remote
+	for i, k := range p.keys {
+		if k.Limiter != nil {
+			usage[i].Limits = k.Limiter.Snapshot()
+		}
+	}
+	return usage
+}
+
+// invoke sends a request with the first available key, failing over to the
+// next available key while keys are rate limited or refused.
+func (p *Pool) invoke(ctx context.Context, config *imagegen.GenerateConfig, tokens int, call func(imagegen.ImageGenerator) (*imagegen.GenerateResult, error)) (*imagegen.GenerateResult, error) {
+	var model string
+	if config != nil {
+		model = string(config.Model)
+	}
+
+	tried := make(map[*key]bool, len(p.keys))
+	var lastErr error
+	for {
+		k, err := p.acquire(model, tokens, tried)
+		if err != nil {
+			// Report why the last key failed rather than that none are left
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+
+		result, err := call(k.Generator)
+		if !p.release(k, err) || ctx.Err() != nil {
+			return result, err
+		}
+		tried[k] = true
+		lastErr = err
+	}
+}
+
+// acquire reserves the first available key not in tried, in the strategy's
+// order, and consumes tokens from its limiter. If none is available it
+// returns a RateLimitError with the shortest wait.
+func (p *Pool) acquire(model string, tokens int, tried map[*key]bool) (*key, error) {
+	p.mu.Lock()
+	order := p.orderLocked()
+	p.mu.Unlock()
+
+	retryAfter := time.Duration(-1)
+	for _, k := range order {
+		if tried[k] {
+			continue
+		}
+		if p.tryKey(k, tokens) {
+			return k, nil
+		}
+		if wait := p.keyWait(k, tokens); retryAfter < 0 || wait < retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	return nil, &imagegen.RateLimitError{
+		RetryAfter: max(retryAfter, 0),
+		LimitType:  LimitTypeKeys,
+		Model:      model,
+		Err:        ErrNoKeyAvailable,
+	}
+}
+
+// acquireKey reserves k, for requests that must use a particular key.
+func (p *Pool) acquireKey(k *key, model string, tokens int) error {
+	if p.tryKey(k, tokens) {
+		return nil
+	}
+	return &imagegen.RateLimitError{
+		RetryAfter: p.keyWait(k, tokens),
+		LimitType:  LimitTypeKeys,
+		Model:      model,
+		Err:        fmt.Errorf("%w: key %s", ErrNoKeyAvailable, k.Name),
+	}
+}
+
+// tryKey reserves k if it is not benched and its limiter has capacity. The
+// limiter is called without holding p.mu, as it may be slow or remote.
+func (p *Pool) tryKey(k *key, tokens int) bool {
+	p.mu.Lock()
+	benched := p.clock.Now().Before(k.usage.BenchedUntil)
+	p.mu.Unlock()
+
+	if benched {
+		return false
+	}
+	if k.Limiter != nil && !k.Limiter.TryConsume(tokens) {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k.usage.Requests++
+	k.usage.Tokens += tokens
+	k.usage.InFlight++
+	if i := slices.Index(p.keys, k); i >= 0 {
+		p.next = (i + 1) % len(p.keys)
+	}
+	return true
+}
+
+// keyWait returns how long until k could serve a request of tokens.
+func (p *Pool) keyWait(k *key, tokens int) time.Duration {
+	p.mu.Lock()
+	wait := max(k.usage.BenchedUntil.Sub(p.clock.Now()), 0)
+	p.mu.Unlock()
+
+	if k.Limiter != nil {
+		wait = max(wait, k.Limiter.TimeUntilAvailable(tokens))
+	}
+	return wait
+}
+
+// orderLocked returns the keys in the order the strategy would try them.
+// Must be called while holding p.mu.
+func (p *Pool) orderLocked() []*key {
+	order := make([]*key, 0, len(p.keys))
+	order = append(order, p.keys[p.next:]...)
+	order = append(order, p.keys[:p.next]...)
+
+	if p.strategy == LeastLoaded {
+		slices.SortStableFunc(order, func(a, b *key) int {
+			return a.usage.InFlight - b.usage.InFlight
+		})
+	}
+	return order
+}
+
+// release records the outcome of a request sent with k, benching k if it was
+// rate limited or refused. It reports whether another key should be tried.
+func (p *Pool) release(k *key, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k.usage.InFlight--
+
+	var rateLimitErr *imagegen.RateLimitError
+	var providerErr *imagegen.ProviderError
+	switch {
+	case err == nil:
+		k.usage.Successes++
+		return false
+
+	case errors.As(err, &rateLimitErr):
+		k.usage.RateLimited++
+		bench := rateLimitErr.RetryAfter
+		if bench <= 0 {
+			bench = p.rateLimitedBench
+		}
+		p.benchLocked(k, bench)
+		return true
+
+	case errors.As(err, &providerErr) &&
+		(providerErr.StatusCode == http.StatusUnauthorized || providerErr.StatusCode == http.StatusForbidden):
+		k.usage.Forbidden++
+		p.benchLocked(k, p.forbiddenBench)
+		return true
+
+	default:
+		k.usage.Failures++
+		return false
+	}
+}
+
+// benchLocked stops k being used for d, unless it is already benched for
+// longer. Must be called while holding p.mu.
+func (p *Pool) benchLocked(k *key, d time.Duration) {
+	if until := p.clock.Now().Add(d); until.After(k.usage.BenchedUntil) {
+		k.usage.BenchedUntil = until
+	}
+}
+
+// conversation is a native conversation pinned to one key.
+type conversation struct {
+	pool  *Pool
+	key   *key
+	inner imagegen.Conversation
+}
+
+// Send sends a turn with the conversation's key. It returns a RateLimitError
+// wrapping ErrNoKeyAvailable while the key is benched or out of quota.
+func (c *conversation) Send(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	var model string
+	if config != nil {
+		model = string(config.Model)
+	}
+
+	tokens := imagegen.EstimateInputTokens(c.pool.estimator, prompt, images)
+	if err := c.pool.acquireKey(c.key, model, tokens); err != nil {
+		return nil, err
+	}
+	result, err := c.inner.Send(ctx, prompt, images, config)
+	c.pool.release(c.key, err)
+	return result, err
+}
+
+// History returns the conversation history.
+func (c *conversation) History() []imagegen.ConversationTurn {
+	return c.inner.History()
+}
+
+// Clear resets the conversation history.
+func (c *conversation) Clear() {
+	c.inner.Clear()
+}
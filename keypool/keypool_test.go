@@ -0,0 +1,375 @@
+package keypool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newMocks returns n mock generators.
+func newMocks(n int) []*imagegentest.MockGenerator {
+	mocks := make([]*imagegentest.MockGenerator, n)
+	for i := range mocks {
+		mocks[i] = &imagegentest.MockGenerator{}
+	}
+	return mocks
+}
+
+// newPool creates a pool of mocks with a fake clock.
+func newPool(t *testing.T, clock ratelimiter.Clock, mocks []*imagegentest.MockGenerator, opts ...Option) *Pool {
+	t.Helper()
+
+	keys := make([]Key, len(mocks))
+	for i, mock := range mocks {
+		keys[i] = Key{Generator: mock}
+	}
+	pool, err := New(keys, append([]Option{WithClock(clock)}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return pool
+}
+
+// callCounts returns how many calls each mock has received.
+func callCounts(mocks []*imagegentest.MockGenerator) []int {
+	counts := make([]int, len(mocks))
+	for i, mock := range mocks {
+		counts[i] = len(mock.Calls())
+	}
+	return counts
+}
+
+func TestConformance(t *testing.T) {
+	imagegentest.RunConformance(t, func(t *testing.T) imagegen.ImageGenerator {
+		pool, err := New([]Key{
+			{Generator: &imagegentest.MockConversationalGenerator{}},
+			{Generator: &imagegentest.MockConversationalGenerator{}},
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return pool
+	})
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []Key
+	}{
+		{"no keys", nil},
+		{"no generator", []Key{{Name: "a"}}},
+		{"duplicate names", []Key{
+			{Name: "a", Generator: &imagegentest.MockGenerator{}},
+			{Name: "a", Generator: &imagegentest.MockGenerator{}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.keys); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestPool_RoundRobin(t *testing.T) {
+	mocks := newMocks(3)
+	pool := newPool(t, ratelimiter.NewFakeClock(epoch), mocks)
+
+	for range 6 {
+		if _, err := pool.Generate(context.Background(), "a cat", nil); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+	}
+
+	for i, n := range callCounts(mocks) {
+		if n != 2 {
+			t.Errorf("key %d served %d requests, want 2", i+1, n)
+		}
+	}
+	for _, u := range pool.Usage() {
+		if u.Requests != 2 || u.Successes != 2 || u.Tokens == 0 || u.InFlight != 0 {
+			t.Errorf("unexpected usage: %+v", u)
+		}
+	}
+}
+
+func TestPool_LeastLoaded(t *testing.T) {
+	mocks := newMocks(2)
+	started, release := make(chan struct{}), make(chan struct{})
+	mocks[0].GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		close(started)
+		<-release
+		return imagegentest.DefaultResult(), nil
+	}
+	pool := newPool(t, ratelimiter.NewFakeClock(epoch), mocks, WithStrategy(LeastLoaded))
+
+	done := make(chan error)
+	go func() {
+		_, err := pool.Generate(context.Background(), "slow", nil)
+		done <- err
+	}()
+	<-started
+
+	// The first key is busy, so both requests go to the second
+	for range 2 {
+		if _, err := pool.Generate(context.Background(), "a cat", nil); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+	}
+	if got := len(mocks[1].Calls()); got != 2 {
+		t.Errorf("second key served %d requests, want 2", got)
+	}
+	if got := pool.Usage()[0].InFlight; got != 1 {
+		t.Errorf("first key in flight = %d, want 1", got)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("slow request: %v", err)
+	}
+}
+
+func TestPool_BenchesRateLimitedKey(t *testing.T) {
+	clock := ratelimiter.NewFakeClock(epoch)
+	mocks := newMocks(2)
+	rateLimited := true
+	mocks[0].GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		if rateLimited {
+			return nil, &imagegen.RateLimitError{RetryAfter: 30 * time.Second, LimitType: "requests"}
+		}
+		return imagegentest.DefaultResult(), nil
+	}
+	pool := newPool(t, clock, mocks)
+	ctx := context.Background()
+
+	// The rate limited request fails over to the second key
+	if _, err := pool.Generate(ctx, "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	usage := pool.Usage()
+	if usage[0].RateLimited != 1 || !usage[0].BenchedUntil.Equal(epoch.Add(30*time.Second)) {
+		t.Errorf("unexpected usage of benched key: %+v", usage[0])
+	}
+
+	// Benched keys are skipped
+	pool.Generate(ctx, "a cat", nil)
+	if got := callCounts(mocks); got[0] != 1 || got[1] != 2 {
+		t.Errorf("calls = %v, want [1 2]", got)
+	}
+
+	// The key returns once its bench expires
+	rateLimited = false
+	clock.Advance(30 * time.Second)
+	pool.Generate(ctx, "a cat", nil)
+	pool.Generate(ctx, "a cat", nil)
+	if got := callCounts(mocks); got[0] != 2 || got[1] != 3 {
+		t.Errorf("calls = %v, want [2 3]", got)
+	}
+	if until := pool.Usage()[0].BenchedUntil; !until.IsZero() {
+		t.Errorf("expected the key not to be benched, benched until %v", until)
+	}
+}
+
+func TestPool_BenchesForbiddenKeys(t *testing.T) {
+	clock := ratelimiter.NewFakeClock(epoch)
+	mocks := newMocks(2)
+	for _, mock := range mocks {
+		mock.GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			return nil, &imagegen.ProviderError{Provider: "mock", StatusCode: http.StatusForbidden}
+		}
+	}
+	pool := newPool(t, clock, mocks, WithBenchDuration(time.Minute, time.Hour))
+	ctx := context.Background()
+
+	// Every key is tried, and the last key's error is returned
+	_, err := pool.Generate(ctx, "a cat", nil)
+	var providerErr *imagegen.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a 403 ProviderError, got %v", err)
+	}
+	for _, u := range pool.Usage() {
+		if u.Forbidden != 1 || !u.BenchedUntil.Equal(epoch.Add(time.Hour)) {
+			t.Errorf("unexpected usage: %+v", u)
+		}
+	}
+
+	// With every key benched the pool is rate limited
+	_, err = pool.Generate(ctx, "a cat", nil)
+	var rateLimitErr *imagegen.RateLimitError
+	if !errors.As(err, &rateLimitErr) || !errors.Is(err, ErrNoKeyAvailable) {
+		t.Fatalf("expected RateLimitError wrapping ErrNoKeyAvailable, got %v", err)
+	}
+	if rateLimitErr.RetryAfter != time.Hour || rateLimitErr.LimitType != LimitTypeKeys {
+		t.Errorf("unexpected error: %+v", rateLimitErr)
+	}
+}
+
+func TestPool_OtherErrorsDoNotFailOver(t *testing.T) {
+	mocks := newMocks(2)
+	mocks[0].GenerateFunc = func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+		return nil, &imagegen.ProviderError{Provider: "mock", StatusCode: http.StatusInternalServerError}
+	}
+	pool := newPool(t, ratelimiter.NewFakeClock(epoch), mocks)
+
+	if _, err := pool.Generate(context.Background(), "a cat", nil); err == nil {
+		t.Fatal("expected the server error to be returned")
+	}
+	if got := callCounts(mocks); got[1] != 0 {
+		t.Errorf("calls = %v, want the second key unused", got)
+	}
+	if u := pool.Usage()[0]; u.Failures != 1 || !u.BenchedUntil.IsZero() {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+}
+
+func TestPool_KeyLimiters(t *testing.T) {
+	clock := ratelimiter.NewFakeClock(epoch)
+	pool, err := New([]Key{
+		{Name: "a", Generator: &imagegentest.MockGenerator{}, Limiter: ratelimiter.NewGCRA(0, 1, ratelimiter.WithClock(clock))},
+		{Name: "b", Generator: &imagegentest.MockGenerator{}, Limiter: ratelimiter.NewGCRA(0, 2, ratelimiter.WithClock(clock))},
+	}, WithClock(clock))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	for i := range 3 {
+		if _, err := pool.Generate(ctx, "a cat", nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+
+	_, err = pool.Generate(ctx, "a cat", nil)
+	var rateLimitErr *imagegen.RateLimitError
+	if !errors.As(err, &rateLimitErr) || !errors.Is(err, ErrNoKeyAvailable) {
+		t.Fatalf("expected RateLimitError wrapping ErrNoKeyAvailable, got %v", err)
+	}
+	// Key b regains a request first
+	if rateLimitErr.RetryAfter != 30*time.Second {
+		t.Errorf("retry after = %v, want 30s", rateLimitErr.RetryAfter)
+	}
+
+	usage := pool.Usage()
+	if usage[0].Requests != 1 || usage[1].Requests != 2 {
+		t.Errorf("requests = %d, %d; want 1, 2", usage[0].Requests, usage[1].Requests)
+	}
+	if b, ok := usage[1].Limits.Bucket(ratelimiter.BucketRequests); !ok || b.Remaining != 0 {
+		t.Errorf("unexpected key b requests bucket: %+v", b)
+	}
+}
+
+// slowLimiter is a Limiter whose TryConsume blocks until release is closed.
+type slowLimiter struct {
+	ratelimiter.Limiter
+	started, release chan struct{}
+}
+
+func (l *slowLimiter) TryConsume(numTokens int) bool {
+	close(l.started)
+	<-l.release
+	return l.Limiter.TryConsume(numTokens)
+}
+
+func TestPool_SlowKeyLimiterDoesNotBlockPool(t *testing.T) {
+	clock := ratelimiter.NewFakeClock(epoch)
+	slow := &slowLimiter{
+		Limiter: ratelimiter.NewGCRA(0, 10, ratelimiter.WithClock(clock)),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	pool, err := New([]Key{
+		{Name: "a", Generator: &imagegentest.MockGenerator{}, Limiter: slow},
+	}, WithClock(clock))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := pool.Generate(context.Background(), "a cat", nil)
+		done <- err
+	}()
+	<-slow.started
+
+	usage := make(chan []KeyUsage)
+	go func() { usage <- pool.Usage() }()
+	select {
+	case <-usage:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Usage blocked on a key limiter")
+	}
+
+	close(slow.release)
+	if err := <-done; err != nil {
+		t.Errorf("Generate: %v", err)
+	}
+	if got := pool.Usage()[0].Requests; got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+func TestPool_WithKeyLimits(t *testing.T) {
+	pool := newPool(t, ratelimiter.NewFakeClock(epoch), newMocks(2),
+		WithKeyLimits(imagegen.RateLimits{TokensPerMinute: 100_000, RequestsPerMinute: 1}, nil))
+	ctx := context.Background()
+
+	for i := range 2 {
+		if _, err := pool.Generate(ctx, "a cat", nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if _, err := pool.Generate(ctx, "a cat", nil); !errors.Is(err, ErrNoKeyAvailable) {
+		t.Errorf("expected ErrNoKeyAvailable, got %v", err)
+	}
+}
+
+func TestPool_ModelsScaleRateLimits(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 1000, RequestsPerMinute: 10}
+	mock := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+	}
+	pool := newPool(t, ratelimiter.NewFakeClock(epoch), []*imagegentest.MockGenerator{mock, mock, mock})
+
+	want := imagegen.RateLimits{TokensPerMinute: 3000, RequestsPerMinute: 30}
+	if got := pool.Models()[0].RateLimits; got != want {
+		t.Errorf("rate limits = %+v, want %+v", got, want)
+	}
+}
+
+func TestPool_ConversationStaysOnKey(t *testing.T) {
+	clock := ratelimiter.NewFakeClock(epoch)
+	gens := []*imagegentest.MockConversationalGenerator{{}, {}}
+	pool, err := New([]Key{{Generator: gens[0]}, {Generator: gens[1]}}, WithClock(clock))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	conv := pool.StartConversation()
+	for _, prompt := range []string{"a cat", "make it blue", "add a hat"} {
+		if _, err := conv.Send(ctx, prompt, nil, nil); err != nil {
+			t.Fatalf("Send(%q): %v", prompt, err)
+		}
+	}
+	if got := len(conv.History()); got != 6 {
+		t.Errorf("history has %d turns, want 6", got)
+	}
+	if a, b := len(gens[0].Calls()), len(gens[1].Calls()); a != 3 || b != 0 {
+		t.Errorf("calls = %d, %d; want 3, 0", a, b)
+	}
+	if got := pool.Usage()[0].Successes; got != 3 {
+		t.Errorf("successes = %d, want 3", got)
+	}
+}
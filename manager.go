@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +24,9 @@ var (
 
 	// ErrProviderNotConfigured is returned when a provider lacks required config.
 	ErrProviderNotConfigured = errors.New("provider not configured")
+
+	// ErrManagerClosed is returned for requests made after Close or Shutdown.
+	ErrManagerClosed = errors.New("manager closed")
 )
 
 // Provider represents a model provider/backend.
@@ -50,6 +54,62 @@ type ModelMapping struct {
 	ActualModelName string
 }
 
+// LimiterFactory creates the rate limiter for a model from its RateLimits.
+type LimiterFactory func(limits RateLimits) ratelimiter.Limiter
+
+// LocalLimiter is the default LimiterFactory. It creates a
+// ratelimiter.RateLimiter, which refills its buckets once a minute (once a
+// day for TokensPerDay).
+func LocalLimiter(limits RateLimits) ratelimiter.Limiter {
+	return ratelimiter.New(limits.TokensPerMinute, limits.RequestsPerMinute,
+		ratelimiter.WithDailyTokens(limits.TokensPerDay))
+}
+
+// GCRALimiter is a LimiterFactory that creates a ratelimiter.GCRA, which
+// refills its buckets continuously.
+func GCRALimiter(limits RateLimits) ratelimiter.Limiter {
+	return ratelimiter.NewGCRA(limits.TokensPerMinute, limits.RequestsPerMinute,
+		ratelimiter.WithDailyTokens(limits.TokensPerDay))
+}
+
+// QueuedLimiter wraps a LimiterFactory so that callers waiting on each
+// limiter are served in order of GenerateConfig.Priority, then first come,
+// first served. See ratelimiter.Queued.
+func QueuedLimiter(factory LimiterFactory) LimiterFactory {
+	return func(limits RateLimits) ratelimiter.Limiter {
+		return ratelimiter.NewQueued(factory(limits))
+	}
+}
+
+// ReservedLimiter wraps a LimiterFactory so that fraction of each model's
+// RateLimits is reserved for requests with GenerateConfig.Priority of
+// PriorityHigh or above. Other requests share the remaining capacity and
+// always keep at least one unit of each limit. fraction is clamped to [0, 1].
+// See ratelimiter.Reserved.
+//
+// To also queue waiters by priority, wrap the result with QueuedLimiter.
+func ReservedLimiter(factory LimiterFactory, fraction float64) LimiterFactory {
+	fraction = min(max(fraction, 0), 1)
+	return func(limits RateLimits) ratelimiter.Limiter {
+		return ratelimiter.NewReserved(factory(limits), factory(limits.scale(1-fraction)))
+	}
+}
+
+// AdaptiveLimiter returns a LimiterFactory for limiters that start at each
+// model's RateLimits and adapt to the provider's rate limit errors, so the
+// effective limits converge on the project's actual quota. The Manager
+// reports each outcome and logs every adjustment. See ratelimiter.Adaptive.
+// TokensPerDay is enforced as a fixed cap; only the per-minute limits adapt.
+//
+// Wrapping the result with QueuedLimiter or ReservedLimiter hides the
+// feedback methods, so adaptive limiters should be used unwrapped.
+func AdaptiveLimiter(config ratelimiter.AdaptiveConfig) LimiterFactory {
+	return func(limits RateLimits) ratelimiter.Limiter {
+		return ratelimiter.NewAdaptive(limits.TokensPerMinute, limits.RequestsPerMinute, config,
+			ratelimiter.WithDailyTokens(limits.TokensPerDay))
+	}
+}
+
 // Manager implements ImageGenerator and ConversationalImageGenerator,
 // routing requests to the appropriate provider based on the Model in GenerateConfig.
 type Manager struct {
@@ -65,6 +125,19 @@ type Manager struct {
 	// Rate limiting (per model)
 	rateLimiters map[Model]ratelimiter.Limiter
 
+	// Rate limiting shared by every model of a provider
+	providerLimiters map[Provider]ratelimiter.Limiter
+
+	// Rate limiting shared by groups of models, in the order they were set
+	sharedLimiters []sharedLimiter
+
+	// Creates rate limiters for registered models
+	limiterFactory LimiterFactory
+
+	// Saves and restores rate limiter state across restarts (optional)
+	limiterStore       ratelimiter.Store
+	limiterStateMaxAge time.Duration
+
 	// Model info (per model)
 	modelInfo map[Model]*ModelInfo
 
@@ -76,6 +149,38 @@ type Manager struct {
 
 	tokenEstimator TokenEstimator
 
+	// Conversation emulation for providers without native conversation support
+	emulationOptions EmulationOptions
+
+	// Default HandoffPolicy for new conversations
+	handoffPolicy HandoffPolicy
+
+	// Interceptors wrapping every operation, outermost first
+	interceptors []Interceptor
+
+	// Per-model statistics reported by Stats
+	stats *statsRecorder
+
+	// Per-tenant limits and usage
+	tenants *tenantRegistry
+
+	// Circuit breakers per provider and model (disabled by default)
+	circuits *circuitRegistry
+
+	// Caps on in-flight requests per model and per provider (optional)
+	modelConcurrency    map[Model]*semaphore
+	providerConcurrency map[Provider]*semaphore
+
+	// Hedged request policy (disabled by default)
+	hedging *HedgePolicy
+
+	// Requests in flight, drained by Shutdown
+	requests *requestTracker
+
+	// Run by Shutdown and Close before providers are closed
+	shutdownHooks []func(context.Context) error
+	shutdownDone  bool
+
 	mu sync.RWMutex
 }
 
@@ -87,19 +192,31 @@ var (
 
 // New creates a new Manager.
 func New() *Manager {
-	return &Manager{
-		logger:         slog.Default(),
-		modelMappings:  make(map[Model]ModelMapping),
-		providers:      make(map[Provider]ImageGenerator),
-		rateLimiters:   make(map[Model]ratelimiter.Limiter),
-		modelInfo:      make(map[Model]*ModelInfo),
-		tokenEstimator: NewSimpleTokenEstimator(),
-		defaultModel:   ModelDefault,
-	}
+	m := &Manager{
+		logger:           slog.Default(),
+		modelMappings:    make(map[Model]ModelMapping),
+		providers:        make(map[Provider]ImageGenerator),
+		rateLimiters:     make(map[Model]ratelimiter.Limiter),
+		providerLimiters: make(map[Provider]ratelimiter.Limiter),
+		limiterFactory:   LocalLimiter,
+		modelInfo:        make(map[Model]*ModelInfo),
+		tokenEstimator:   NewSimpleTokenEstimator(),
+		defaultModel:     ModelDefault,
+		emulationOptions: DefaultEmulationOptions(),
+		stats:            newStatsRecorder(),
+		tenants:          newTenantRegistry(),
+
+		modelConcurrency:    make(map[Model]*semaphore),
+		providerConcurrency: make(map[Provider]*semaphore),
+		requests:            newRequestTracker(),
+	}
+	m.circuits = newCircuitRegistry(m.logCircuitChange)
+	return m
 }
 
 // RegisterModel registers a model with full info (including rate limits).
-// Uses the default in-memory rate limiter. Use SetRateLimiter to override with a custom implementation.
+// The rate limiter is created by the LimiterFactory, LocalLimiter by default.
+// Use SetRateLimiter to override it with a custom implementation.
 func (m *Manager) RegisterModel(model Model, mapping ModelMapping, info *ModelInfo) *Manager {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -107,19 +224,40 @@ func (m *Manager) RegisterModel(model Model, mapping ModelMapping, info *ModelIn
 	m.modelMappings[model] = mapping
 	m.modelInfo[model] = info
 
-	// Create default in-memory rate limiter from model's rate limits
+	// Create rate limiter from model's rate limits
 	if info.RateLimits.TokensPerMinute > 0 || info.RateLimits.RequestsPerMinute > 0 {
-		m.rateLimiters[model] = ratelimiter.New(
-			info.RateLimits.TokensPerMinute,
-			info.RateLimits.RequestsPerMinute,
-		)
+		limiter := m.limiterFactory(info.RateLimits)
+		m.restoreLimiterLocked(model, limiter)
+		m.rateLimiters[model] = limiter
+	}
+
+	return m
+}
+
+// AddProvider registers a provider and all models it reports via Models().
+// Use this to serve models from several providers with one Manager.
+func (m *Manager) AddProvider(gen ImageGenerator) *Manager {
+	models := gen.Models()
+	for i := range models {
+		info := &models[i]
+
+		m.mu.Lock()
+		m.providers[info.Provider] = gen
+		m.mu.Unlock()
+
+		m.RegisterModel(Model(info.Name),
+			ModelMapping{
+				Provider:        info.Provider,
+				ActualModelName: info.APIModelName,
+			},
+			info)
 	}
 
 	return m
 }
 
 // SetRateLimiter sets a custom rate limiter for a model.
-// Use this to swap in a distributed rate limiter (e.g., Redis-based) for production.
+// Use this to swap in a distributed rate limiter (e.g., ratelimiter/redis) for production.
 func (m *Manager) SetRateLimiter(model Model, limiter ratelimiter.Limiter) *Manager {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -128,6 +266,177 @@ func (m *Manager) SetRateLimiter(model Model, limiter ratelimiter.Limiter) *Mana
 	return m
 }
 
+// SetProviderRateLimiter sets a rate limiter shared by every model of a
+// provider, such as a project-wide requests per minute quota. Requests must
+// pass both their model's limiter and this one; refusals are reported with
+// RateLimitError.Scope set to ScopeProvider.
+func (m *Manager) SetProviderRateLimiter(provider Provider, limiter ratelimiter.Limiter) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.providerLimiters[provider] = limiter
+	return m
+}
+
+// sharedLimiter is a rate limiter set with SetSharedRateLimiter.
+type sharedLimiter struct {
+	name    string
+	limiter ratelimiter.Limiter
+	models  map[Model]bool // nil means every model
+}
+
+// SetSharedRateLimiter sets a named rate limiter that requests for the given
+// models (or every model, if none are given) must also pass, such as the
+// quota of an API key used across providers. Refusals are reported with
+// RateLimitError.Scope set to name. Setting a name again replaces it.
+func (m *Manager) SetSharedRateLimiter(name string, limiter ratelimiter.Limiter, models ...Model) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	shared := sharedLimiter{name: name, limiter: limiter}
+	if len(models) > 0 {
+		shared.models = make(map[Model]bool, len(models))
+		for _, model := range models {
+			shared.models[model] = true
+		}
+	}
+
+	for i, existing := range m.sharedLimiters {
+		if existing.name == name {
+			m.sharedLimiters[i] = shared
+			return m
+		}
+	}
+	m.sharedLimiters = append(m.sharedLimiters, shared)
+	return m
+}
+
+// rateLimitLevels returns the limiters a request for model must pass: its
+// tenant's (if not nil), model, provider and shared limiters, in that order.
+// Must be called while holding m.mu.
+func (m *Manager) rateLimitLevels(model Model, tenantLimiter ratelimiter.Limiter) []ratelimiter.Level {
+	var levels []ratelimiter.Level
+	if tenantLimiter != nil {
+		levels = append(levels, ratelimiter.Level{Scope: ScopeTenant, Limiter: tenantLimiter})
+	}
+	if limiter := m.rateLimiters[model]; limiter != nil {
+		levels = append(levels, ratelimiter.Level{Scope: ScopeModel, Limiter: limiter})
+	}
+	if mapping, ok := m.modelMappings[model]; ok {
+		if limiter := m.providerLimiters[mapping.Provider]; limiter != nil {
+			levels = append(levels, ratelimiter.Level{Scope: ScopeProvider, Limiter: limiter})
+		}
+	}
+	for _, shared := range m.sharedLimiters {
+		if shared.models == nil || shared.models[model] {
+			levels = append(levels, ratelimiter.Level{Scope: shared.name, Limiter: shared.limiter})
+		}
+	}
+	return levels
+}
+
+// RateLimiters returns the rate limiter for each model that has one.
+func (m *Manager) RateLimiters() map[Model]ratelimiter.Limiter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limiters := make(map[Model]ratelimiter.Limiter, len(m.rateLimiters))
+	for model, limiter := range m.rateLimiters {
+		limiters[model] = limiter
+	}
+	return limiters
+}
+
+// SetLimiterFactory sets how rate limiters are created for models registered
+// after the call.
+func (m *Manager) SetLimiterFactory(factory LimiterFactory) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.limiterFactory = factory
+	return m
+}
+
+// SetLimiterStore sets where rate limiter state is saved on Close (or
+// SaveLimiterState) and restored from when models are registered after the
+// call, so restarts do not reset the limits. Saved state older than maxAge
+// is discarded; zero means no limit. Only limiters implementing
+// ratelimiter.Persistent, such as those from LocalLimiter, GCRALimiter and
+// AdaptiveLimiter, are saved; others are skipped with a debug log.
+func (m *Manager) SetLimiterStore(store ratelimiter.Store, maxAge time.Duration) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.limiterStore = store
+	m.limiterStateMaxAge = maxAge
+	return m
+}
+
+// restoreLimiterLocked restores a model's limiter from the limiter store.
+// Failures are logged; the limiter then starts with full buckets.
+// Must be called while holding m.mu.
+func (m *Manager) restoreLimiterLocked(model Model, limiter ratelimiter.Limiter) {
+	persistent, ok := limiter.(ratelimiter.Persistent)
+	if m.limiterStore == nil || !ok {
+		return
+	}
+
+	state, found, err := m.limiterStore.Load(string(model))
+	if err == nil && found {
+		err = persistent.RestoreState(state, m.limiterStateMaxAge)
+	}
+
+	switch {
+	case errors.Is(err, ratelimiter.ErrStaleState):
+		m.logger.Info("discarding stale rate limiter state",
+			"model", string(model),
+			"saved_at", state.SavedAt,
+		)
+	case err != nil:
+		m.logger.Warn("failed to restore rate limiter state",
+			"model", string(model),
+			"error", err.Error(),
+		)
+	case found:
+		m.logger.Debug("restored rate limiter state",
+			"model", string(model),
+			"saved_at", state.SavedAt,
+		)
+	}
+}
+
+// SaveLimiterState saves the state of every persistent model rate limiter to
+// the limiter store. Close calls it; call it directly to save periodically.
+func (m *Manager) SaveLimiterState() error {
+	// Read the states under the lock, but write them to the store outside it
+	m.mu.RLock()
+	store := m.limiterStore
+	states := make(map[Model]ratelimiter.State)
+	var skipped []string
+	if store != nil {
+		for model, limiter := range m.rateLimiters {
+			if persistent, ok := limiter.(ratelimiter.Persistent); ok {
+				states[model] = persistent.SaveState()
+			} else {
+				skipped = append(skipped, string(model))
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	if len(skipped) > 0 {
+		m.logger.Debug("rate limiters do not support saving state", "models", skipped)
+	}
+
+	var errs []error
+	for model, state := range states {
+		if err := store.Save(string(model), state); err != nil {
+			errs = append(errs, fmt.Errorf("saving rate limiter state for %s: %w", model, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // SetDefaultModel sets the default model used when config.Model is empty.
 func (m *Manager) SetDefaultModel(model Model) *Manager {
 	m.mu.Lock()
@@ -157,6 +466,38 @@ func (m *Manager) SetStorage(storage Storage) *Manager {
 	return m
 }
 
+// SetConversationEmulation configures how conversations are emulated for
+// providers that do not implement ConversationalImageGenerator.
+// It applies to conversations started after the call.
+func (m *Manager) SetConversationEmulation(opts EmulationOptions) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.emulationOptions = opts
+	return m
+}
+
+// SetHandoffPolicy sets the default HandoffPolicy for conversations started
+// after the call. The default is HandoffReplay.
+func (m *Manager) SetHandoffPolicy(policy HandoffPolicy) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handoffPolicy = policy
+	return m
+}
+
+// Use appends interceptors that wrap every operation, including conversation
+// turns. Interceptors run in the order they were added, the first being the
+// outermost.
+func (m *Manager) Use(interceptors ...Interceptor) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.interceptors = append(m.interceptors[:len(m.interceptors):len(m.interceptors)], interceptors...)
+	return m
+}
+
 // Storage returns the configured storage backend, or nil if not set.
 func (m *Manager) Storage() Storage {
 	m.mu.RLock()
@@ -177,179 +518,52 @@ func (m *Manager) SaveResult(ctx context.Context, result *GenerateResult, basePa
 
 // Generate creates images from a text prompt.
 func (m *Manager) Generate(ctx context.Context, prompt string, config *GenerateConfig) (*GenerateResult, error) {
-	if config == nil {
-		config = DefaultConfig()
+	req := &Request{
+		Operation: OperationGenerate,
+		Prompt:    prompt,
+		Config:    config,
 	}
 
-	model := m.resolveModel(config)
-	start := time.Now()
-
-	m.logger.Debug("starting image generation",
-		"model", string(model),
-		"prompt_length", len(prompt),
-	)
-
-	// Check rate limit
-	if err := m.checkRateLimit(ctx, model, config, prompt); err != nil {
-		m.logger.Warn("rate limit hit",
-			"model", string(model),
-			"error", err.Error(),
-		)
-		return nil, err
-	}
-
-	gen, actualConfig, err := m.getGeneratorForConfig(config)
-	if err != nil {
-		m.logger.Error("failed to get generator",
-			"model", string(model),
-			"error", err.Error(),
-		)
-
-		return nil, err
-	}
-
-	result, err := gen.Generate(ctx, prompt, actualConfig)
-	duration := time.Since(start)
-
-	if err != nil {
-		m.logger.Error("generation failed",
-			"model", string(model),
-			"duration_ms", duration.Milliseconds(),
-			"error", err.Error(),
-		)
-
-		return nil, err
-	}
-
-	// Log success with usage metadata
-	logAttrs := []any{
-		"model", string(model),
-		"duration_ms", duration.Milliseconds(),
-		"image_count", len(result.Images),
-	}
-	if result.UsageMetadata != nil {
-		logAttrs = append(logAttrs,
-			"prompt_tokens", result.UsageMetadata.PromptTokens,
-			"response_tokens", result.UsageMetadata.CandidatesTokens,
-			"total_tokens", result.UsageMetadata.TotalTokens,
-		)
-	}
-	m.logger.Info("generation completed", logAttrs...)
-
-	return result, nil
+	return m.execute(ctx, req, pipeline{
+		dispatch: func(ctx context.Context, req *Request, gen ImageGenerator, config *GenerateConfig) (*GenerateResult, error) {
+			return gen.Generate(ctx, req.Prompt, config)
+		},
+	})
 }
 
 // Edit modifies an existing image based on a text instruction.
 func (m *Manager) Edit(ctx context.Context, image InputImage, instruction string, config *GenerateConfig) (*GenerateResult, error) {
-	if config == nil {
-		config = DefaultConfig()
-	}
-
-	model := m.resolveModel(config)
-	start := time.Now()
-
-	m.logger.Debug("starting image edit",
-		"model", string(model),
-		"instruction_length", len(instruction),
-		"image_size", len(image.Data),
-	)
-
-	// Check rate limit
-	if err := m.checkRateLimit(ctx, model, config, instruction); err != nil {
-		m.logger.Warn("rate limit hit for edit",
-			"model", string(model),
-			"error", err.Error(),
-		)
-		return nil, err
-	}
-
-	gen, actualConfig, err := m.getGeneratorForConfig(config)
-	if err != nil {
-		m.logger.Error("failed to get generator for edit",
-			"model", string(model),
-			"error", err.Error(),
-		)
-
-		return nil, err
-	}
-
-	result, err := gen.Edit(ctx, image, instruction, actualConfig)
-	duration := time.Since(start)
-
-	if err != nil {
-		m.logger.Error("edit failed",
-			"model", string(model),
-			"duration_ms", duration.Milliseconds(),
-			"error", err.Error(),
-		)
-
-		return nil, err
-	}
-
-	m.logger.Info("edit completed",
-		"model", string(model),
-		"duration_ms", duration.Milliseconds(),
-		"image_count", len(result.Images),
-	)
-
-	return result, nil
+	req := &Request{
+		Operation: OperationEdit,
+		Prompt:    instruction,
+		Images:    []InputImage{image},
+		Config:    config,
+	}
+
+	return m.execute(ctx, req, pipeline{
+		dispatch: func(ctx context.Context, req *Request, gen ImageGenerator, config *GenerateConfig) (*GenerateResult, error) {
+			if len(req.Images) != 1 {
+				return gen.EditMultiple(ctx, req.Images, req.Prompt, config)
+			}
+			return gen.Edit(ctx, req.Images[0], req.Prompt, config)
+		},
+	})
 }
 
 // EditMultiple performs editing with multiple reference images.
 func (m *Manager) EditMultiple(ctx context.Context, images []InputImage, instruction string, config *GenerateConfig) (*GenerateResult, error) {
-	if config == nil {
-		config = DefaultConfig()
-	}
-
-	model := m.resolveModel(config)
-	start := time.Now()
-
-	m.logger.Debug("starting multi-image edit",
-		"model", string(model),
-		"instruction_length", len(instruction),
-		"image_count", len(images),
-	)
-
-	// Check rate limit
-	if err := m.checkRateLimit(ctx, model, config, instruction); err != nil {
-		m.logger.Warn("rate limit hit for multi-edit",
-			"model", string(model),
-			"error", err.Error(),
-		)
-		return nil, err
-	}
-
-	gen, actualConfig, err := m.getGeneratorForConfig(config)
-	if err != nil {
-		m.logger.Error("failed to get generator for multi-edit",
-			"model", string(model),
-			"error", err.Error(),
-		)
-
-		return nil, err
-	}
-
-	result, err := gen.EditMultiple(ctx, images, instruction, actualConfig)
-	duration := time.Since(start)
-
-	if err != nil {
-		m.logger.Error("multi-edit failed",
-			"model", string(model),
-			"duration_ms", duration.Milliseconds(),
-			"error", err.Error(),
-		)
-
-		return nil, err
-	}
-
-	m.logger.Info("multi-edit completed",
-		"model", string(model),
-		"duration_ms", duration.Milliseconds(),
-		"input_images", len(images),
-		"output_images", len(result.Images),
-	)
-
-	return result, nil
+	req := &Request{
+		Operation: OperationEditMultiple,
+		Prompt:    instruction,
+		Images:    images,
+		Config:    config,
+	}
+
+	return m.execute(ctx, req, pipeline{
+		dispatch: func(ctx context.Context, req *Request, gen ImageGenerator, config *GenerateConfig) (*GenerateResult, error) {
+			return gen.EditMultiple(ctx, req.Images, req.Prompt, config)
+		},
+	})
 }
 
 // Models returns all registered model definitions.
@@ -364,40 +578,37 @@ func (m *Manager) Models() []ModelInfo {
 	return models
 }
 
-// Close releases all provider resources.
+// Close stops accepting requests and releases all provider resources
+// immediately, without waiting for requests in flight. Use Shutdown to let
+// them finish first.
 func (m *Manager) Close() error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	var errs []error
-	for provider, gen := range m.providers {
-		if err := gen.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("closing %s: %w", provider, err))
-		}
-	}
-	m.providers = make(map[Provider]ImageGenerator)
-
-	if len(errs) > 0 {
-		return errors.Join(errs...)
-	}
-	return nil
+	m.requests.close()
+	return m.finishShutdown(context.Background())
 }
 
 // StartConversation begins a new image generation conversation.
 func (m *Manager) StartConversation() Conversation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return &ManagedConversation{
-		manager: m,
-		history: make([]ConversationTurn, 0),
+		manager:       m,
+		history:       make([]ConversationTurn, 0),
+		handoffPolicy: m.handoffPolicy,
 	}
 }
 
 // StartConversationWithModel begins a conversation with a specific model.
 func (m *Manager) StartConversationWithModel(model Model) Conversation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return &ManagedConversation{
-		manager:     m,
-		history:     make([]ConversationTurn, 0),
-		lockedModel: model,
-		modelLocked: true,
+		manager:       m,
+		history:       make([]ConversationTurn, 0),
+		lockedModel:   model,
+		modelLocked:   true,
+		handoffPolicy: m.handoffPolicy,
 	}
 }
 
@@ -449,81 +660,181 @@ func (m *Manager) ListModelsInfo() []ModelInfo {
 }
 
 // checkRateLimit checks rate limits for a model and optionally waits.
-func (m *Manager) checkRateLimit(ctx context.Context, model Model, config *GenerateConfig, prompt string) error {
+// The tenant's, model's, provider's and shared limiters are consumed together, all or nothing.
+// estimatedTokens is the request's input estimate; a fixed buffer is added for the response.
+func (m *Manager) checkRateLimit(ctx context.Context, model Model, tenant string, config *GenerateConfig, estimatedTokens int) error {
 
 	const (
 		tokenBuffer = 100
 	)
 
+	tenantLimiter := m.tenants.limiter(tenant)
+
 	m.mu.RLock()
-	limiter := m.rateLimiters[model]
+	levels := m.rateLimitLevels(model, tenantLimiter)
 	m.mu.RUnlock()
 
-	if limiter == nil {
+	if len(levels) == 0 {
 		return nil
 	}
 
-	estimatedTokens := m.tokenEstimator.EstimateTokens(prompt)
-
 	estimatedTokens += tokenBuffer
 
-	if config.WaitOnRateLimit {
-		return limiter.WaitAndConsume(ctx, estimatedTokens, config.MaxWaitDuration)
+	priority := int(config.Priority)
+
+	// Several levels are consumed together, all or nothing
+	limiter := levels[0].Limiter
+	var hierarchy *ratelimiter.Hierarchy
+	if len(levels) > 1 {
+		hierarchy = ratelimiter.NewHierarchy(levels)
+		limiter = hierarchy
 	}
 
-	if !limiter.TryConsume(estimatedTokens) {
-		return &RateLimitError{
-			RetryAfter: limiter.TimeUntilAvailable(estimatedTokens),
-			LimitType:  "tokens",
+	// refused describes a refusal by the level named scope
+	refused := func(scope string, err error) error {
+		rateLimitErr := &RateLimitError{
+			RetryAfter: ratelimiter.TimeUntilAvailableWithPriority(limiter, estimatedTokens, priority),
+			LimitType:  LimitTypeTokens,
 			Model:      string(model),
+			Err:        err,
+			Scope:      scope,
+		}
+		for _, level := range levels {
+			if level.Scope == scope {
+				rateLimitErr.LimitType = refusedLimitType(level.Limiter, estimatedTokens)
+				break
+			}
 		}
+		if scope == ScopeTenant {
+			rateLimitErr.Tenant = tenant
+		}
+		return rateLimitErr
+	}
+
+	if config.WaitOnRateLimit {
+		if priority != 0 {
+			ctx = ratelimiter.WithPriority(ctx, priority)
+		}
+		err := limiter.WaitAndConsume(ctx, estimatedTokens, config.MaxWaitDuration)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		scope := levels[0].Scope
+		var levelErr *ratelimiter.LevelError
+		if errors.As(err, &levelErr) {
+			scope = levelErr.Scope
+		}
+		return refused(scope, err)
+	}
+
+	refusedBy, ok := levels[0].Scope, false
+	if hierarchy != nil {
+		refusedBy, ok = hierarchy.Consume(estimatedTokens, priority)
+	} else {
+		ok = ratelimiter.TryConsumeWithPriority(limiter, estimatedTokens, priority)
+	}
+	if !ok {
+		return refused(refusedBy, nil)
 	}
 
 	return nil
 }
 
-// resolveModel determines the actual model to use.
-func (m *Manager) resolveModel(config *GenerateConfig) Model {
-	model := ModelDefault
-	if config != nil && config.Model != "" {
-		model = config.Model
+// refusedLimitType returns the LimitType of the first bucket in limiter too
+// empty for tokens and one request. If every bucket has refilled since the
+// refusal, it names the limiter's first bucket.
+func refusedLimitType(limiter ratelimiter.Limiter, tokens int) string {
+	buckets := limiter.Snapshot().Buckets
+	if len(buckets) == 0 {
+		return LimitTypeTokens
+	}
+
+	// Bucket names may be prefixed, as in a Reserved limiter's snapshot
+	limitType := func(name string) string {
+		for _, t := range []string{LimitTypeDailyTokens, LimitTypeRequests, LimitTypeTokens} {
+			if strings.HasSuffix(name, t) {
+				return t
+			}
+		}
+		return LimitTypeTokens
 	}
+	for _, b := range buckets {
+		t := limitType(b.Name)
+		need := tokens
+		if t == LimitTypeRequests {
+			need = 1
+		}
+		if b.Remaining < need {
+			return t
+		}
+	}
+	return limitType(buckets[0].Name)
+}
 
+// reportOutcome tells a model's limiter, if it adapts to feedback, whether
+// the provider accepted a request, and logs any resulting adjustment.
+func (m *Manager) reportOutcome(model Model, err error) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	limiter, ok := m.rateLimiters[model].(ratelimiter.FeedbackLimiter)
+	m.mu.RUnlock()
 
-	if model == ModelDefault {
-		model = m.defaultModel
+	if !ok {
+		return
 	}
 
-	return model
-}
+	var (
+		adj      ratelimiter.Adjustment
+		adjusted bool
+	)
+	switch {
+	case err == nil:
+		adj, adjusted = limiter.ReportSuccess()
+	case IsRateLimitError(err):
+		adj, adjusted = limiter.ReportRateLimited()
+	}
+	if !adjusted {
+		return
+	}
 
-// getGeneratorForConfig returns the appropriate generator and adjusted config.
-func (m *Manager) getGeneratorForConfig(config *GenerateConfig) (ImageGenerator, *GenerateConfig, error) {
-	model := m.resolveModel(config)
+	attrs := []any{
+		"model", string(model),
+		"reason", adj.Reason,
+		"scale", adj.Scale,
+		"tokens_per_minute", adj.TokensPerMinute,
+		"requests_per_minute", adj.RequestsPerMinute,
+	}
+	if adj.Reason == ratelimiter.ReasonRateLimited {
+		m.logger.Warn("rate limit decreased", attrs...)
+	} else {
+		m.logger.Info("rate limit increased", attrs...)
+	}
+}
 
+// validateForModel validates a request against the registered ModelInfo, if any.
+func (m *Manager) validateForModel(model Model, config *GenerateConfig, numImages int) error {
 	m.mu.RLock()
-	mapping, ok := m.modelMappings[model]
+	info := m.modelInfo[model]
 	m.mu.RUnlock()
 
-	if !ok {
-		return nil, nil, fmt.Errorf("%w: %s", ErrModelNotRegistered, model)
-	}
+	return ValidateForModel(info, config, numImages)
+}
 
-	gen, err := m.getProvider(mapping.Provider)
-	if err != nil {
-		return nil, nil, err
+// resolveModel determines the actual model to use.
+func (m *Manager) resolveModel(config *GenerateConfig) Model {
+	model := ModelDefault
+	if config != nil && config.Model != "" {
+		model = config.Model
 	}
 
-	actualConfig := config
-	if actualConfig == nil {
-		actualConfig = DefaultConfig()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if model == ModelDefault {
+		model = m.defaultModel
 	}
-	configCopy := *actualConfig
-	configCopy.Model = Model(mapping.ActualModelName)
 
-	return gen, &configCopy, nil
+	return model
 }
 
 // getProvider returns the provider instance for the given provider type.
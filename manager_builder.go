@@ -2,6 +2,9 @@ package imagegen
 
 import (
 	"log/slog"
+	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter"
 )
 
 // ManagerOption configures the Manager.
@@ -28,6 +31,87 @@ func WithDefaultModel(model Model) ManagerOption {
 	}
 }
 
+// WithConversationEmulation configures conversation emulation for providers
+// that do not implement ConversationalImageGenerator.
+func WithConversationEmulation(opts EmulationOptions) ManagerOption {
+	return func(m *Manager) {
+		m.emulationOptions = opts
+	}
+}
+
+// WithHandoffPolicy sets the default HandoffPolicy for new conversations.
+func WithHandoffPolicy(policy HandoffPolicy) ManagerOption {
+	return func(m *Manager) {
+		m.handoffPolicy = policy
+	}
+}
+
+// WithInterceptor adds an interceptor that wraps every operation.
+// Interceptors run in the order they are given, the first being the outermost.
+func WithInterceptor(interceptor Interceptor) ManagerOption {
+	return func(m *Manager) {
+		m.interceptors = append(m.interceptors, interceptor)
+	}
+}
+
+// WithLimiterFactory sets how rate limiters are created for registered models.
+//
+// Example:
+//
+//	manager := imagegen.NewManager(gen, imagegen.WithLimiterFactory(imagegen.GCRALimiter))
+func WithLimiterFactory(factory LimiterFactory) ManagerOption {
+	return func(m *Manager) {
+		m.limiterFactory = factory
+	}
+}
+
+// WithLimiterStore saves rate limiter state to store on Close and restores it
+// when models are registered, discarding state older than maxAge (zero means
+// no limit). See Manager.SetLimiterStore.
+//
+// Example:
+//
+//	store := ratelimiter.NewFileStore("/var/lib/myapp/ratelimits.json")
+//	manager := imagegen.NewManager(gen, imagegen.WithLimiterStore(store, 24*time.Hour))
+func WithLimiterStore(store ratelimiter.Store, maxAge time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.limiterStore = store
+		m.limiterStateMaxAge = maxAge
+	}
+}
+
+// WithCircuitBreaker enables circuit breakers per provider and model.
+// See Manager.SetCircuitBreaker.
+func WithCircuitBreaker(config CircuitBreakerConfig) ManagerOption {
+	return func(m *Manager) {
+		m.circuits.setConfig(config)
+	}
+}
+
+// WithHedging enables hedged requests. See Manager.SetHedging.
+func WithHedging(policy HedgePolicy) ManagerOption {
+	return func(m *Manager) {
+		withDefaults := policy.withDefaults()
+		m.hedging = &withDefaults
+	}
+}
+
+// WithConcurrencyLimit caps how many requests to model may be in flight at
+// once. See Manager.SetConcurrencyLimit.
+func WithConcurrencyLimit(model Model, limit int) ManagerOption {
+	return func(m *Manager) {
+		setConcurrencyLimit(m.modelConcurrency, model, limit)
+	}
+}
+
+// WithProviderConcurrencyLimit caps how many requests to a provider may be in
+// flight at once. See Manager.SetProviderConcurrencyLimit.
+func WithProviderConcurrencyLimit(provider Provider, limit int) ManagerOption {
+	return func(m *Manager) {
+		setConcurrencyLimit(m.providerConcurrency, provider, limit)
+	}
+}
+
 // NewManager creates a Manager with the given providers and options.
 //
 // Example:
@@ -47,23 +131,12 @@ func WithDefaultModel(model Model) ManagerOption {
 func NewManager(defaultProvider ImageGenerator, opts ...ManagerOption) *Manager {
 	m := New()
 
-	models := defaultProvider.Models()
-	for i := range models {
-		info := &models[i]
-
-		m.providers[info.Provider] = defaultProvider
-
-		m.RegisterModel(Model(info.Name),
-			ModelMapping{
-				Provider:        info.Provider,
-				ActualModelName: info.APIModelName,
-			},
-			info)
-	}
-
+	// Options apply first so they affect how the provider's models are registered
 	for _, opt := range opts {
 		opt(m)
 	}
 
+	m.AddProvider(defaultProvider)
+
 	return m
 }
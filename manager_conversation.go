@@ -3,9 +3,41 @@ package imagegen
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 )
 
+// HandoffPolicy controls what a ManagedConversation does when a turn targets a
+// model served by a different provider than the one holding the conversation.
+type HandoffPolicy int
+
+const (
+	// HandoffReplay starts a conversation with the new provider and replays the
+	// latest model images and a compacted transcript into its first turn.
+	HandoffReplay HandoffPolicy = iota
+
+	// HandoffForbid rejects the turn with ErrProviderSwitchForbidden.
+	HandoffForbid
+
+	// HandoffReset starts a fresh conversation with the new provider, dropping
+	// prior context. History is still kept by the ManagedConversation.
+	HandoffReset
+)
+
+// String returns the policy name.
+func (p HandoffPolicy) String() string {
+	switch p {
+	case HandoffReplay:
+		return "replay"
+	case HandoffForbid:
+		return "forbid"
+	case HandoffReset:
+		return "reset"
+	default:
+		return "unknown"
+	}
+}
+
 // ManagedConversation implements Conversation with model routing.
 type ManagedConversation struct {
 	manager *Manager
@@ -17,115 +49,182 @@ type ManagedConversation struct {
 	providerConv Conversation
 	convProvider Provider
 
+	handoffPolicy HandoffPolicy
+
+	// baseHistory holds the turns from before the current provider
+	// conversation; handoffTurn is the original user turn that was replayed
+	// into its first turn, if any.
+	baseHistory []ConversationTurn
+	handoffTurn *ConversationTurn
+
 	mu sync.Mutex
 }
 
+// SetHandoffPolicy sets the policy for switching providers mid-conversation.
+func (c *ManagedConversation) SetHandoffPolicy(policy HandoffPolicy) *ManagedConversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handoffPolicy = policy
+	return c
+}
+
 // Send sends a message and receives a response.
+// Each turn goes through the same interceptors, validation, rate limiting and
+// logging as Manager.Generate. The rate limit estimate includes the
+// conversation history, since providers resend it as context on every turn.
 func (c *ManagedConversation) Send(ctx context.Context, prompt string, images []InputImage, config *GenerateConfig) (*GenerateResult, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Determine model
-	var model Model
-	if c.modelLocked {
-		model = c.lockedModel
-	} else if config != nil && config.Model != "" {
-		model = config.Model
-	} else {
-		model = c.manager.defaultModel
+	if config == nil {
+		config = DefaultConfig()
 	}
 
-	// Get mapping
-	c.manager.mu.RLock()
-	mapping, ok := c.manager.modelMappings[model]
-	c.manager.mu.RUnlock()
-	if !ok {
-		return nil, fmt.Errorf("%w: %s", ErrModelNotRegistered, model)
+	req := &Request{
+		Operation: OperationConversation,
+		Model:     c.resolveModel(config),
+		Prompt:    prompt,
+		Images:    images,
+		Config:    config,
+		History:   slices.Clone(c.history),
 	}
 
-	// Check if we can continue with existing provider conversation
-	if c.providerConv != nil && c.convProvider == mapping.Provider {
-		// Continue existing conversation
-		actualConfig := config
-		if actualConfig == nil {
-			actualConfig = DefaultConfig()
-		}
-		configCopy := *actualConfig
-		configCopy.Model = Model(mapping.ActualModelName)
+	return c.manager.execute(ctx, req, pipeline{
+		check:    c.checkTurn,
+		dispatch: c.send,
+	})
+}
 
-		result, err := c.providerConv.Send(ctx, prompt, images, &configCopy)
-		if err != nil {
-			return nil, err
-		}
+// send dispatches a turn to the provider conversation, starting one if there
+// is none or the provider changed. Providers without native conversation
+// support get an EmulatedConversation. Must be called while holding c.mu.
+func (c *ManagedConversation) send(ctx context.Context, req *Request, gen ImageGenerator, config *GenerateConfig) (*GenerateResult, error) {
+	provider, prompt, images := req.Provider, req.Prompt, req.Images
+	if c.providerConv != nil && c.convProvider == provider {
+		return c.sendToProvider(ctx, prompt, images, config)
+	}
 
-		// Update our history
-		c.history = c.providerConv.History()
-		return result, nil
+	// A failed first turn leaves the conversation as it was
+	prevConv, prevProvider := c.providerConv, c.convProvider
+	prevBase, prevHandoff := c.baseHistory, c.handoffTurn
+
+	c.startProviderConversation(gen, provider)
+
+	sendPrompt, sendImages := prompt, images
+	if len(c.history) > 0 && c.handoffPolicy == HandoffReplay {
+		if emulated, ok := c.providerConv.(*EmulatedConversation); ok {
+			// Emulated conversations replay their history on every turn anyway
+			emulated.history = append(emulated.history, c.history...)
+			c.baseHistory = nil
+		} else {
+			sendPrompt, sendImages = c.handoffInputs(prompt, images)
+
+			c.handoffTurn = &ConversationTurn{Role: "user", Text: prompt}
+			for _, img := range images {
+				c.handoffTurn.Images = append(c.handoffTurn.Images, GeneratedImage{
+					Data:     img.Data,
+					MIMEType: img.MIMEType,
+				})
+			}
+		}
 	}
 
-	// Need to create new provider conversation or provider changed
-	gen, err := c.manager.getProvider(mapping.Provider)
+	result, err := c.sendToProvider(ctx, sendPrompt, sendImages, config)
 	if err != nil {
+		c.providerConv, c.convProvider = prevConv, prevProvider
+		c.baseHistory, c.handoffTurn = prevBase, prevHandoff
 		return nil, err
 	}
 
-	// Check if provider supports conversations
-	if convGen, ok := gen.(ConversationalImageGenerator); ok {
-		c.providerConv = convGen.StartConversation()
-		c.convProvider = mapping.Provider
-
-		actualConfig := config
-		if actualConfig == nil {
-			actualConfig = DefaultConfig()
-		}
-		configCopy := *actualConfig
-		configCopy.Model = Model(mapping.ActualModelName)
+	return result, nil
+}
 
-		result, err := c.providerConv.Send(ctx, prompt, images, &configCopy)
-		if err != nil {
-			return nil, err
-		}
+// sendToProvider sends a turn to the current provider conversation and
+// rebuilds the managed history from it. Must be called while holding c.mu.
+func (c *ManagedConversation) sendToProvider(ctx context.Context, prompt string, images []InputImage, config *GenerateConfig) (*GenerateResult, error) {
+	result, err := c.providerConv.Send(ctx, prompt, images, config)
+	if err != nil {
+		return nil, err
+	}
 
-		c.history = c.providerConv.History()
-		return result, nil
+	// Update our history, showing the original prompt for a replayed turn
+	convHistory := c.providerConv.History()
+	if c.handoffTurn != nil && len(convHistory) > 0 {
+		convHistory[0] = *c.handoffTurn
 	}
+	c.history = make([]ConversationTurn, 0, len(c.baseHistory)+len(convHistory))
+	c.history = append(c.history, c.baseHistory...)
+	c.history = append(c.history, convHistory...)
+
+	return result, nil
+}
 
-	// Provider doesn't support conversations, fall back to single generation
-	actualConfig := config
-	if actualConfig == nil {
-		actualConfig = DefaultConfig()
+// startProviderConversation replaces the provider conversation with a new one
+// for provider. Must be called while holding c.mu.
+func (c *ManagedConversation) startProviderConversation(gen ImageGenerator, provider Provider) {
+	if c.providerConv != nil {
+		c.manager.logger.Info("conversation handed off to new provider",
+			"from_provider", string(c.convProvider),
+			"to_provider", string(provider),
+			"history_turns", len(c.history),
+			"handoff_policy", c.handoffPolicy.String(),
+		)
 	}
-	configCopy := *actualConfig
-	configCopy.Model = Model(mapping.ActualModelName)
 
-	var result *GenerateResult
-	if len(images) > 0 {
-		result, err = gen.EditMultiple(ctx, images, prompt, &configCopy)
+	if convGen, ok := gen.(ConversationalImageGenerator); ok {
+		c.providerConv = convGen.StartConversation()
 	} else {
-		result, err = gen.Generate(ctx, prompt, &configCopy)
+		c.manager.mu.RLock()
+		opts := c.manager.emulationOptions
+		c.manager.mu.RUnlock()
+
+		c.providerConv = NewEmulatedConversation(gen, opts)
 	}
-	if err != nil {
-		return nil, err
+	c.convProvider = provider
+	c.baseHistory = c.history
+	c.handoffTurn = nil
+}
+
+// handoffInputs builds the first turn for a new provider conversation,
+// carrying the latest model images and a compacted transcript of the history.
+// Must be called while holding c.mu.
+func (c *ManagedConversation) handoffInputs(prompt string, images []InputImage) (string, []InputImage) {
+	c.manager.mu.RLock()
+	opts := c.manager.emulationOptions
+	c.manager.mu.RUnlock()
+
+	refs := latestModelImages(c.history, min(opts.MaxReferenceImages, MaxInputImages-len(images)))
+	instruction := buildEmulatedInstruction(c.history, prompt, opts)
+
+	return instruction, append(refs, images...)
+}
+
+// resolveModel returns the locked model, or the model resolved from config.
+func (c *ManagedConversation) resolveModel(config *GenerateConfig) Model {
+	if c.modelLocked {
+		return c.lockedModel
 	}
+	return c.manager.resolveModel(config)
+}
 
-	// Manually track history
-	userTurn := ConversationTurn{Role: "user", Text: prompt}
-	for _, img := range images {
-		userTurn.Images = append(userTurn.Images, GeneratedImage{
-			Data:     img.Data,
-			MIMEType: img.MIMEType,
-		})
+// checkTurn validates a turn's inputs and the handoff policy.
+// Unlike Generate, a turn may omit the prompt if it carries images.
+// Must be called while holding c.mu.
+func (c *ManagedConversation) checkTurn(req *Request) error {
+	if len(req.Images) == 0 {
+		if err := ValidatePrompt(req.Prompt); err != nil {
+			return err
+		}
+	} else if err := ValidateInputImages(req.Images); err != nil {
+		return err
 	}
-	c.history = append(c.history, userTurn)
 
-	modelTurn := ConversationTurn{
-		Role:   "model",
-		Text:   result.Text,
-		Images: result.Images,
+	if c.providerConv != nil && c.convProvider != req.Provider && c.handoffPolicy == HandoffForbid {
+		return fmt.Errorf("%w: %s to %s", ErrProviderSwitchForbidden, c.convProvider, req.Provider)
 	}
-	c.history = append(c.history, modelTurn)
 
-	return result, nil
+	return nil
 }
 
 // History returns the conversation history.
@@ -149,4 +248,6 @@ func (c *ManagedConversation) Clear() {
 	}
 	c.providerConv = nil
 	c.convProvider = ""
+	c.baseHistory = nil
+	c.handoffTurn = nil
 }
@@ -0,0 +1,160 @@
+package imagegen_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+)
+
+// newHandoffManager returns a Manager serving "model-a" from a conversational
+// provider and "model-b" from another, along with the prompts and images each received.
+func newHandoffManager() (*imagegen.Manager, *[]string, *[][]imagegen.InputImage) {
+	var promptsB []string
+	var imagesB [][]imagegen.InputImage
+
+	genA := &imagegentest.MockConversationalGenerator{
+		MockGenerator: imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo {
+				return []imagegen.ModelInfo{{Name: "model-a", Provider: "provider-a"}}
+			},
+		},
+		SendFunc: func(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			return &imagegen.GenerateResult{
+				Text:   "a red bicycle",
+				Images: []imagegen.GeneratedImage{{Data: []byte("bike"), MIMEType: "image/png"}},
+			}, nil
+		},
+	}
+	genB := &imagegentest.MockConversationalGenerator{
+		MockGenerator: imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo {
+				return []imagegen.ModelInfo{{Name: "model-b", Provider: "provider-b"}}
+			},
+		},
+		SendFunc: func(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			promptsB = append(promptsB, prompt)
+			imagesB = append(imagesB, images)
+			return &imagegen.GenerateResult{Text: "done"}, nil
+		},
+	}
+
+	manager := imagegen.NewManager(genA).AddProvider(genB)
+	return manager, &promptsB, &imagesB
+}
+
+func TestManagedConversation_HandoffReplaysHistory(t *testing.T) {
+	manager, promptsB, imagesB := newHandoffManager()
+	defer manager.Close()
+
+	ctx := context.Background()
+	conv := manager.StartConversation()
+
+	if _, err := conv.Send(ctx, "draw a bicycle", nil, &imagegen.GenerateConfig{Model: "model-a"}); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+	if _, err := conv.Send(ctx, "make it blue", nil, &imagegen.GenerateConfig{Model: "model-b"}); err != nil {
+		t.Fatalf("handoff turn failed: %v", err)
+	}
+
+	if len(*promptsB) != 1 {
+		t.Fatalf("expected 1 call to provider-b, got %d", len(*promptsB))
+	}
+	if got := (*promptsB)[0]; !strings.Contains(got, "User: draw a bicycle") || !strings.Contains(got, "Current request: make it blue") {
+		t.Errorf("handoff prompt missing transcript:\n%s", got)
+	}
+	if got := (*imagesB)[0]; len(got) != 1 || string(got[0].Data) != "bike" {
+		t.Errorf("expected latest model image to be replayed, got %d images", len(got))
+	}
+
+	history := conv.History()
+	if len(history) != 4 {
+		t.Fatalf("expected 4 history turns, got %d", len(history))
+	}
+	if history[2].Text != "make it blue" || len(history[2].Images) != 0 {
+		t.Errorf("history should show the original handoff prompt, got %q with %d images", history[2].Text, len(history[2].Images))
+	}
+
+	// Later turns continue the new provider conversation without replaying
+	if _, err := conv.Send(ctx, "add a bell", nil, &imagegen.GenerateConfig{Model: "model-b"}); err != nil {
+		t.Fatalf("third turn failed: %v", err)
+	}
+	if got := (*promptsB)[1]; got != "add a bell" {
+		t.Errorf("expected plain prompt after handoff, got %q", got)
+	}
+	if len(conv.History()) != 6 {
+		t.Errorf("expected 6 history turns, got %d", len(conv.History()))
+	}
+}
+
+func TestManagedConversation_HandoffWithoutReferenceImages(t *testing.T) {
+	manager, promptsB, imagesB := newHandoffManager()
+	manager.SetConversationEmulation(imagegen.EmulationOptions{MaxTranscriptTurns: 8})
+	defer manager.Close()
+
+	ctx := context.Background()
+	conv := manager.StartConversation()
+
+	if _, err := conv.Send(ctx, "draw a bicycle", nil, &imagegen.GenerateConfig{Model: "model-a"}); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+	if _, err := conv.Send(ctx, "make it blue", nil, &imagegen.GenerateConfig{Model: "model-b"}); err != nil {
+		t.Fatalf("handoff turn failed: %v", err)
+	}
+
+	if got := (*promptsB)[0]; !strings.Contains(got, "User: draw a bicycle") {
+		t.Errorf("handoff prompt missing transcript:\n%s", got)
+	}
+	if got := (*imagesB)[0]; len(got) != 0 {
+		t.Errorf("expected no reference images when disabled, got %d", len(got))
+	}
+}
+
+func TestManagedConversation_HandoffForbid(t *testing.T) {
+	manager, promptsB, _ := newHandoffManager()
+	defer manager.Close()
+	manager.SetHandoffPolicy(imagegen.HandoffForbid)
+
+	ctx := context.Background()
+	conv := manager.StartConversation()
+
+	if _, err := conv.Send(ctx, "draw a bicycle", nil, &imagegen.GenerateConfig{Model: "model-a"}); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+
+	_, err := conv.Send(ctx, "make it blue", nil, &imagegen.GenerateConfig{Model: "model-b"})
+	if !errors.Is(err, imagegen.ErrProviderSwitchForbidden) {
+		t.Errorf("expected ErrProviderSwitchForbidden, got %v", err)
+	}
+	if len(*promptsB) != 0 {
+		t.Errorf("provider-b should not be called, got %d calls", len(*promptsB))
+	}
+	if len(conv.History()) != 2 {
+		t.Errorf("expected history to be unchanged, got %d turns", len(conv.History()))
+	}
+}
+
+func TestManagedConversation_HandoffReset(t *testing.T) {
+	manager, promptsB, imagesB := newHandoffManager()
+	defer manager.Close()
+
+	ctx := context.Background()
+	conv := manager.StartConversation().(*imagegen.ManagedConversation).SetHandoffPolicy(imagegen.HandoffReset)
+
+	if _, err := conv.Send(ctx, "draw a bicycle", nil, &imagegen.GenerateConfig{Model: "model-a"}); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+	if _, err := conv.Send(ctx, "make it blue", nil, &imagegen.GenerateConfig{Model: "model-b"}); err != nil {
+		t.Fatalf("second turn failed: %v", err)
+	}
+
+	if (*promptsB)[0] != "make it blue" || len((*imagesB)[0]) != 0 {
+		t.Errorf("expected no replay with HandoffReset, got %q", (*promptsB)[0])
+	}
+	if len(conv.History()) != 4 {
+		t.Errorf("expected history to be kept, got %d turns", len(conv.History()))
+	}
+}
@@ -0,0 +1,292 @@
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// pipeline holds the operation-specific steps of the Manager's core handler.
+type pipeline struct {
+	// check runs after routing, before model validation and rate limiting.
+	// It is optional.
+	check func(req *Request) error
+
+	// dispatch sends the request to gen. config is a copy of req.Config with
+	// the provider's API model name.
+	dispatch func(ctx context.Context, req *Request, gen ImageGenerator, config *GenerateConfig) (*GenerateResult, error)
+}
+
+// execute normalizes req and runs it through the interceptors and the core
+// handler for p.
+func (m *Manager) execute(ctx context.Context, req *Request, p pipeline) (*GenerateResult, error) {
+	if !m.requests.begin() {
+		return nil, ErrManagerClosed
+	}
+	defer m.requests.end()
+
+	if req.Config == nil {
+		req.Config = DefaultConfig()
+	} else {
+		configCopy := *req.Config
+		req.Config = &configCopy
+	}
+	if req.Model == "" {
+		req.Model = m.resolveModel(req.Config)
+	}
+	req.Provider, _ = m.GetModelProvider(req.Model)
+	if req.Tenant == "" {
+		req.Tenant = tenantOf(ctx, req.Config)
+	}
+
+	m.mu.RLock()
+	interceptors := m.interceptors
+	m.mu.RUnlock()
+
+	start := time.Now()
+	result, err := wrapHandler(m.handler(p), interceptors)(ctx, req)
+	latency := time.Since(start)
+	if req.latency > 0 {
+		latency = req.latency
+	}
+	m.recordStats(req, latency, result, err)
+
+	return result, err
+}
+
+// recordStats adds the outcome of a request to the statistics reported by
+// Stats and, if it succeeded, to its tenant's usage. Requests for models that
+// are not registered are not recorded, so callers cannot grow Stats with
+// arbitrary model names.
+func (m *Manager) recordStats(req *Request, latency time.Duration, result *GenerateResult, err error) {
+	if _, ok := m.GetModelProvider(req.Model); !ok {
+		return
+	}
+
+	var cost float64
+	if err == nil {
+		if info, ok := m.GetModelInfo(req.Model); ok && info != nil {
+			cost = info.Pricing.EstimateResultCost(result)
+		}
+		m.tenants.record(req.Tenant, result, cost)
+	}
+
+	m.stats.record(req.Model, latency, result, err, cost)
+}
+
+// handler returns the core Handler for p: routing, validation, circuit
+// breaking, rate limiting, dispatch and logging, hedging slow requests and
+// falling back to req.Config.FallbackModels when the model cannot serve the
+// request.
+func (m *Manager) handler(p pipeline) Handler {
+	return func(ctx context.Context, req *Request) (*GenerateResult, error) {
+		models := []Model{req.Model}
+		for _, model := range req.Config.FallbackModels {
+			if !slices.Contains(models, model) {
+				models = append(models, model)
+			}
+		}
+
+		req.Attempts = 0
+		for i, model := range models[:len(models)-1] {
+			req.Model = model
+			result, err := m.handleHedged(ctx, req, p)
+			if err == nil || !shouldFallback(ctx, err) {
+				return result, err
+			}
+
+			m.logger.Warn("falling back to another model",
+				"model", string(model),
+				"fallback", string(models[i+1]),
+				"error", err.Error(),
+			)
+		}
+
+		req.Model = models[len(models)-1]
+		return m.handleHedged(ctx, req, p)
+	}
+}
+
+// shouldFallback reports whether a request that failed with err should be
+// retried on a fallback model.
+func shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var rateLimitErr *RateLimitError
+	var providerErr *ProviderError
+	switch {
+	case IsCircuitOpenError(err):
+		return true
+	case errors.As(err, &rateLimitErr):
+		// Tenant limits apply to every model
+		return rateLimitErr.Scope != ScopeTenant
+	case errors.As(err, &providerErr):
+		return providerErr.Temporary()
+	default:
+		return false
+	}
+}
+
+// handle runs req on req.Model.
+func (m *Manager) handle(ctx context.Context, req *Request, p pipeline) (*GenerateResult, error) {
+	label := req.Operation.label()
+	start := time.Now()
+
+	startAttrs := []any{
+		"model", string(req.Model),
+		"prompt_length", len(req.Prompt),
+		"image_count", len(req.Images),
+	}
+	if req.Operation == OperationConversation {
+		startAttrs = append(startAttrs, "history_turns", len(req.History))
+	}
+	m.logger.Debug("starting "+label, startAttrs...)
+
+	gen, mapping, err := m.route(req.Model)
+	if err != nil {
+		m.logger.Error("failed to get generator for "+label,
+			"model", string(req.Model),
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+	req.Provider = mapping.Provider
+
+	if p.check != nil {
+		err = p.check(req)
+	}
+	if err == nil {
+		err = m.validateForModel(req.Model, req.Config, len(req.Images))
+	}
+	if err != nil {
+		m.logger.Warn("invalid "+label+" request",
+			"model", string(req.Model),
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
+	// Wait for a slot under the concurrency limits first, so a request that
+	// gives up waiting has not spent rate limit budget or a circuit probe
+	release, err := m.acquireConcurrency(ctx, req.Provider, req.Model)
+	if err != nil {
+		m.logger.Warn("gave up waiting for a concurrency slot for "+label,
+			"model", string(req.Model),
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
+	// Fail fast while the provider or model is failing
+	ticket, err := m.circuits.allow(req.Provider, req.Model)
+	if err != nil {
+		release()
+		m.logger.Warn("circuit open for "+label,
+			"model", string(req.Model),
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
+	// Check tenant quotas, then rate limits
+	waitStart := time.Now()
+	err = m.tenants.checkQuotas(req.Tenant, req.Model)
+	if err == nil {
+		err = m.checkRateLimit(ctx, req.Model, req.Tenant, req.Config, m.estimateRequestTokens(req))
+	}
+	req.RateLimitWait = time.Since(waitStart)
+	if err != nil {
+		release()
+		ticket.cancel()
+		m.logger.Warn("rate limit hit for "+label,
+			"model", string(req.Model),
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
+	config := *req.Config
+	config.Model = Model(mapping.ActualModelName)
+	config.FallbackModels = nil
+
+	m.stats.begin(req.Model)
+	dispatchStart := time.Now()
+	result, err := p.dispatch(ctx, req, gen, &config)
+	if err == nil {
+		m.stats.recordDispatch(req.Model, time.Since(dispatchStart))
+	}
+	m.stats.end(req.Model)
+	release()
+
+	duration := time.Since(start)
+	m.reportOutcome(req.Model, err)
+	ticket.finish(ctx, err)
+
+	if err != nil {
+		m.logger.Error(label+" failed",
+			"model", string(req.Model),
+			"duration_ms", duration.Milliseconds(),
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
+	// Log success with usage metadata
+	logAttrs := []any{
+		"model", string(req.Model),
+		"duration_ms", duration.Milliseconds(),
+		"input_images", len(req.Images),
+		"output_images", len(result.Images),
+	}
+	if req.Operation == OperationConversation {
+		logAttrs = append(logAttrs, "history_turns", len(req.History))
+	}
+	if result.UsageMetadata != nil {
+		logAttrs = append(logAttrs,
+			"prompt_tokens", result.UsageMetadata.PromptTokens,
+			"response_tokens", result.UsageMetadata.CandidatesTokens,
+			"total_tokens", result.UsageMetadata.TotalTokens,
+		)
+	}
+	m.logger.Info(label+" completed", logAttrs...)
+
+	return result, nil
+}
+
+// route returns the generator and mapping for a model.
+func (m *Manager) route(model Model) (ImageGenerator, ModelMapping, error) {
+	m.mu.RLock()
+	mapping, ok := m.modelMappings[model]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ModelMapping{}, fmt.Errorf("%w: %s", ErrModelNotRegistered, model)
+	}
+
+	gen, err := m.getProvider(mapping.Provider)
+	if err != nil {
+		return nil, ModelMapping{}, err
+	}
+
+	return gen, mapping, nil
+}
+
+// estimateRequestTokens estimates the input tokens for a request, including
+// any conversation history that is sent along with it.
+func (m *Manager) estimateRequestTokens(req *Request) int {
+	tokens := EstimateInputTokens(m.tokenEstimator, req.Prompt, req.Images)
+
+	for _, turn := range req.History {
+		turnImages := make([]InputImage, len(turn.Images))
+		for i, img := range turn.Images {
+			turnImages[i] = InputImage{Data: img.Data, MIMEType: img.MIMEType}
+		}
+		tokens += EstimateInputTokens(m.tokenEstimator, turn.Text, turnImages)
+	}
+
+	return tokens
+}
@@ -1,36 +1,41 @@
-package imagegen
+package imagegen_test
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
 	"github.com/mhpenta/imagegen/ratelimiter"
 )
 
 func TestManager_Generate_RateLimit(t *testing.T) {
 	// Setup
-	mockGen := &MockImageGenerator{
-		ModelsFunc: func() []ModelInfo {
-			return []ModelInfo{
+	mockGen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo {
+			return []imagegen.ModelInfo{
 				{
 					Name:         "test-model",
 					Provider:     "test-provider",
 					APIModelName: "test-model-api",
-					RateLimits: RateLimits{
+					RateLimits: imagegen.RateLimits{
 						TokensPerMinute:   100, // Small limit for testing
 						RequestsPerMinute: 10,
 					},
 				},
 			}
 		},
-		GenerateFunc: func(ctx context.Context, prompt string, config *GenerateConfig) (*GenerateResult, error) {
-			return &GenerateResult{
-				Images: []GeneratedImage{{Data: []byte("fake-image")}},
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			return &imagegen.GenerateResult{
+				Images: []imagegen.GeneratedImage{{Data: []byte("fake-image")}},
 			}, nil
 		},
 	}
 
-	manager := NewManager(mockGen)
+	manager := imagegen.NewManager(mockGen)
 	defer manager.Close()
 
 	ctx := context.Background()
@@ -40,20 +45,20 @@ func TestManager_Generate_RateLimit(t *testing.T) {
 	// Wait, 11 chars / 4 = 2.75 -> 3 tokens. + 100 = 103.
 	// Limit is 100. So it should fail immediately.
 
-	_, err := manager.Generate(ctx, prompt, &GenerateConfig{
+	_, err := manager.Generate(ctx, prompt, &imagegen.GenerateConfig{
 		Model: "test-model",
 	})
 
 	if err == nil {
 		t.Error("expected rate limit error, got nil")
-	} else if !IsRateLimitError(err) {
+	} else if !imagegen.IsRateLimitError(err) {
 		t.Errorf("expected RateLimitError, got %T: %v", err, err)
 	}
 
 	// Now increase limit to allow it
 	manager.SetRateLimiter("test-model", ratelimiter.New(200, 10))
 
-	result, err := manager.Generate(ctx, prompt, &GenerateConfig{
+	result, err := manager.Generate(ctx, prompt, &imagegen.GenerateConfig{
 		Model: "test-model",
 	})
 	if err != nil {
@@ -68,21 +73,21 @@ func TestManager_Generate_TokenEstimation(t *testing.T) {
 	// This test verifies that the token estimator is actually being used
 	// We do this by setting a limit that would pass with a small prompt but fail with a large one
 
-	mockGen := &MockImageGenerator{
-		ModelsFunc: func() []ModelInfo {
-			return []ModelInfo{
+	mockGen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo {
+			return []imagegen.ModelInfo{
 				{
 					Name:     "test-model",
 					Provider: "test-provider",
 				},
 			}
 		},
-		GenerateFunc: func(ctx context.Context, prompt string, config *GenerateConfig) (*GenerateResult, error) {
-			return &GenerateResult{}, nil
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			return &imagegen.GenerateResult{}, nil
 		},
 	}
 
-	manager := NewManager(mockGen)
+	manager := imagegen.NewManager(mockGen)
 
 	// Set a specific limiter
 	// Capacity 200.
@@ -95,7 +100,7 @@ func TestManager_Generate_TokenEstimation(t *testing.T) {
 	ctx := context.Background()
 
 	// Small prompt: "hello" -> ~2 tokens + 100 = 102. Should pass (102 <= 200).
-	_, err := manager.Generate(ctx, "hello", &GenerateConfig{Model: "test-model"})
+	_, err := manager.Generate(ctx, "hello", &imagegen.GenerateConfig{Model: "test-model"})
 	if err != nil {
 		t.Errorf("small prompt failed: %v", err)
 	}
@@ -108,14 +113,107 @@ func TestManager_Generate_TokenEstimation(t *testing.T) {
 
 	// Large prompt: 500 chars -> ~125 tokens + 100 = 225. Should fail (225 > 200).
 	largePrompt := makeString(500)
-	_, err = manager.Generate(ctx, largePrompt, &GenerateConfig{Model: "test-model"})
+	_, err = manager.Generate(ctx, largePrompt, &imagegen.GenerateConfig{Model: "test-model"})
 	if err == nil {
 		t.Error("large prompt should have failed rate limit")
-	} else if !IsRateLimitError(err) {
+	} else if !imagegen.IsRateLimitError(err) {
 		t.Errorf("expected RateLimitError, got %v", err)
 	}
 }
 
+func TestManagedConversation_Send_RateLimit(t *testing.T) {
+	calls := 0
+	mockGen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo {
+			return []imagegen.ModelInfo{{Name: "test-model", Provider: "test-provider"}}
+		},
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			calls++
+			return &imagegen.GenerateResult{Text: "ok"}, nil
+		},
+	}
+
+	manager := imagegen.NewManager(mockGen)
+	defer manager.Close()
+
+	// Enough for one turn (106 tokens) and a second turn on its own (107), but
+	// not once the first turn's history is included (117).
+	manager.SetRateLimiter("test-model", ratelimiter.New(220, 100))
+
+	ctx := context.Background()
+	conv := manager.StartConversationWithModel("test-model")
+
+	if _, err := conv.Send(ctx, "first turn", nil, nil); err != nil {
+		t.Fatalf("first turn failed: %v", err)
+	}
+
+	_, err := conv.Send(ctx, "second turn", nil, nil)
+	if !imagegen.IsRateLimitError(err) {
+		t.Errorf("expected RateLimitError on second turn, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected provider to be called once, got %d", calls)
+	}
+}
+
+func TestManagedConversation_Send_ImageAwareEstimate(t *testing.T) {
+	mockGen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo {
+			return []imagegen.ModelInfo{{Name: "test-model", Provider: "test-provider"}}
+		},
+	}
+
+	manager := imagegen.NewManager(mockGen)
+	defer manager.Close()
+
+	// Text alone fits, but an input image adds DefaultImageTokens.
+	manager.SetRateLimiter("test-model", ratelimiter.New(imagegen.DefaultImageTokens, 100))
+
+	img := imagegen.InputImage{Data: []byte("fake image data"), MIMEType: "image/png"}
+	conv := manager.StartConversationWithModel("test-model")
+
+	_, err := conv.Send(context.Background(), "edit this", []imagegen.InputImage{img}, nil)
+	if !imagegen.IsRateLimitError(err) {
+		t.Errorf("expected RateLimitError for image turn, got %v", err)
+	}
+}
+
+func TestManagedConversation_Send_ValidatesAgainstModel(t *testing.T) {
+	called := false
+	mockGen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo {
+			return []imagegen.ModelInfo{{
+				Name:     "test-model",
+				Provider: "test-provider",
+				ImageConstraints: imagegen.ImageConstraints{
+					SupportedSizes: []imagegen.ImageSize{imagegen.ImageSize1K},
+				},
+			}}
+		},
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			called = true
+			return &imagegen.GenerateResult{}, nil
+		},
+	}
+
+	manager := imagegen.NewManager(mockGen)
+	defer manager.Close()
+
+	conv := manager.StartConversationWithModel("test-model")
+	_, err := conv.Send(context.Background(), "a cat", nil, &imagegen.GenerateConfig{Size: imagegen.ImageSize4K})
+	if !errors.Is(err, imagegen.ErrUnsupportedImageSize) {
+		t.Errorf("expected ErrUnsupportedImageSize, got %v", err)
+	}
+	if called {
+		t.Error("provider should not be called for an invalid request")
+	}
+
+	_, err = conv.Send(context.Background(), "", nil, nil)
+	if !errors.Is(err, imagegen.ErrEmptyPrompt) {
+		t.Errorf("expected ErrEmptyPrompt, got %v", err)
+	}
+}
+
 func makeString(n int) string {
 	b := make([]byte, n)
 	for i := range b {
@@ -123,3 +221,274 @@ func makeString(n int) string {
 	}
 	return string(b)
 }
+
+func TestManager_WithLimiterFactory(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 1000, RequestsPerMinute: 10}
+
+	var got imagegen.RateLimits
+	manager := imagegen.NewManager(
+		&imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+		},
+		imagegen.WithLimiterFactory(func(limits imagegen.RateLimits) ratelimiter.Limiter {
+			got = limits
+			return imagegen.GCRALimiter(limits)
+		}),
+	)
+	defer manager.Close()
+
+	if got != info.RateLimits {
+		t.Errorf("factory called with %+v, want %+v", got, info.RateLimits)
+	}
+	if _, ok := manager.RateLimiters()[imagegen.Model(info.Name)].(*ratelimiter.GCRA); !ok {
+		t.Error("expected a GCRA limiter for the registered model")
+	}
+}
+
+func TestManager_QueuedLimiter(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 1000, RequestsPerMinute: 10}
+
+	manager := imagegen.NewManager(
+		&imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+		},
+		imagegen.WithLimiterFactory(imagegen.QueuedLimiter(imagegen.LocalLimiter)),
+	)
+	defer manager.Close()
+
+	if _, ok := manager.RateLimiters()[imagegen.Model(info.Name)].(*ratelimiter.Queued); !ok {
+		t.Fatal("expected a queued limiter for the registered model")
+	}
+
+	_, err := manager.Generate(context.Background(), "a cat", &imagegen.GenerateConfig{
+		Model:           imagegen.Model(info.Name),
+		WaitOnRateLimit: true,
+		Priority:        1,
+	})
+	if err != nil {
+		t.Errorf("Generate: %v", err)
+	}
+}
+
+func TestManager_ReservedLimiter(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 1000}
+
+	manager := imagegen.NewManager(
+		&imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+		},
+		imagegen.WithLimiterFactory(imagegen.ReservedLimiter(imagegen.GCRALimiter, 0.5)),
+	)
+	defer manager.Close()
+
+	ctx := context.Background()
+	batch := &imagegen.GenerateConfig{Model: imagegen.Model(info.Name), Priority: imagegen.PriorityLow}
+	interactive := batch.WithPriority(imagegen.PriorityHigh)
+
+	// Batch traffic runs until it has used the unreserved half
+	var err error
+	successes := 0
+	for ; successes < 20; successes++ {
+		if _, err = manager.Generate(ctx, "a cat", batch); err != nil {
+			break
+		}
+	}
+	var rateLimitErr *imagegen.RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected batch traffic to hit RateLimitError, got %v", err)
+	}
+	if successes == 0 {
+		t.Error("expected some batch requests to succeed")
+	}
+
+	if _, err := manager.Generate(ctx, "a cat", interactive); err != nil {
+		t.Errorf("interactive request should use reserved capacity: %v", err)
+	}
+}
+
+func TestManager_AdaptiveLimiter(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 100_000, RequestsPerMinute: 100, TokensPerDay: 1_000_000}
+
+	rateLimited := true
+	manager := imagegen.NewManager(
+		&imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+			GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+				if rateLimited {
+					return nil, &imagegen.RateLimitError{Model: string(config.Model), LimitType: "requests"}
+				}
+				return &imagegen.GenerateResult{}, nil
+			},
+		},
+		imagegen.WithLimiterFactory(imagegen.AdaptiveLimiter(ratelimiter.AdaptiveConfig{SuccessesPerIncrease: 1})),
+	)
+	defer manager.Close()
+
+	model := imagegen.Model(info.Name)
+	limiter, ok := manager.RateLimiters()[model].(*ratelimiter.Adaptive)
+	if !ok {
+		t.Fatal("expected an adaptive limiter for the registered model")
+	}
+
+	ctx := context.Background()
+	config := &imagegen.GenerateConfig{Model: model}
+	if _, err := manager.Generate(ctx, "a cat", config); !imagegen.IsRateLimitError(err) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if got := limiter.Scale(); got != 0.5 {
+		t.Errorf("scale after provider rate limit = %v, want 0.5", got)
+	}
+	// The daily cap is kept and does not adapt
+	if b, ok := limiter.Snapshot().Bucket(ratelimiter.BucketDailyTokens); !ok || b.Capacity != 1_000_000 {
+		t.Errorf("daily bucket = %+v, %v, want capacity 1000000", b, ok)
+	}
+
+	rateLimited = false
+	if _, err := manager.Generate(ctx, "a cat", config); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if got := limiter.Scale(); got != 0.55 {
+		t.Errorf("scale after success = %v, want 0.55", got)
+	}
+}
+
+func TestManager_HierarchicalRateLimits(t *testing.T) {
+	models := []imagegen.ModelInfo{imagegentest.MockModelInfo, imagegentest.MockModelInfo}
+	models[0].Name, models[1].Name = "model-a", "model-b"
+	for i := range models {
+		models[i].RateLimits = imagegen.RateLimits{TokensPerMinute: 100_000, RequestsPerMinute: 100}
+	}
+
+	manager := imagegen.NewManager(
+		&imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo { return models },
+		},
+		imagegen.WithLimiterFactory(imagegen.GCRALimiter),
+	)
+	defer manager.Close()
+
+	manager.SetProviderRateLimiter(imagegentest.MockProvider, ratelimiter.NewGCRA(0, 3))
+	manager.SetSharedRateLimiter("api-key", ratelimiter.NewGCRA(0, 1), "model-b")
+
+	ctx := context.Background()
+	generate := func(model imagegen.Model) error {
+		_, err := manager.Generate(ctx, "a cat", &imagegen.GenerateConfig{Model: model})
+		return err
+	}
+
+	steps := []struct {
+		model     imagegen.Model
+		wantScope string // empty if the request should succeed
+	}{
+		{"model-b", ""},
+		{"model-b", "api-key"},
+		{"model-a", ""},
+		{"model-a", ""},
+		{"model-a", imagegen.ScopeProvider},
+		{"model-b", imagegen.ScopeProvider},
+	}
+	for i, step := range steps {
+		err := generate(step.model)
+		if step.wantScope == "" {
+			if err != nil {
+				t.Errorf("step %d (%s): %v", i, step.model, err)
+			}
+			continue
+		}
+
+		var rateLimitErr *imagegen.RateLimitError
+		if !errors.As(err, &rateLimitErr) {
+			t.Errorf("step %d (%s): expected RateLimitError, got %v", i, step.model, err)
+			continue
+		}
+		if rateLimitErr.Scope != step.wantScope {
+			t.Errorf("step %d (%s): scope = %q, want %q", i, step.model, rateLimitErr.Scope, step.wantScope)
+		}
+	}
+
+	// Refused requests consumed nothing from the model limiters
+	stats := manager.Stats()
+	for model, want := range map[imagegen.Model]int{"model-a": 98, "model-b": 99} {
+		if got := stats[model].RateLimit.RemainingRequests; got != want {
+			t.Errorf("%s remaining requests = %d, want %d", model, got, want)
+		}
+	}
+}
+
+func TestManager_RateLimitError_LimitType(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter ratelimiter.Limiter
+		config  *imagegen.GenerateConfig
+		want    string
+	}{
+		{"tokens", ratelimiter.NewGCRA(50, 100), nil, imagegen.LimitTypeTokens},
+		{"requests", ratelimiter.NewGCRA(100_000, 1), nil, imagegen.LimitTypeRequests},
+		{"daily tokens", ratelimiter.NewGCRA(100_000, 100, ratelimiter.WithDailyTokens(150)), nil, imagegen.LimitTypeDailyTokens},
+		{"requests while waiting", ratelimiter.NewGCRA(100_000, 1), &imagegen.GenerateConfig{WaitOnRateLimit: true, MaxWaitDuration: time.Second}, imagegen.LimitTypeRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := imagegen.Model(imagegentest.MockModelInfo.Name)
+			manager := imagegen.NewManager(&imagegentest.MockGenerator{}, imagegen.WithDefaultModel(model))
+			defer manager.Close()
+			manager.SetRateLimiter(model, tt.limiter)
+
+			var err error
+			for range 3 {
+				if _, err = manager.Generate(context.Background(), "a cat", tt.config); err != nil {
+					break
+				}
+			}
+			var rateLimitErr *imagegen.RateLimitError
+			if !errors.As(err, &rateLimitErr) {
+				t.Fatalf("expected RateLimitError, got %v", err)
+			}
+			if rateLimitErr.LimitType != tt.want || rateLimitErr.Scope != imagegen.ScopeModel {
+				t.Errorf("LimitType = %q, Scope = %q, want %q, model", rateLimitErr.LimitType, rateLimitErr.Scope, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_LimiterStore(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 100_000, RequestsPerMinute: 2}
+	store := ratelimiter.NewFileStore(filepath.Join(t.TempDir(), "limits.json"))
+
+	newManager := func() *imagegen.Manager {
+		return imagegen.NewManager(
+			&imagegentest.MockGenerator{
+				ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+			},
+			imagegen.WithDefaultModel(imagegen.Model(info.Name)),
+			imagegen.WithLimiterStore(store, time.Hour),
+		)
+	}
+
+	ctx := context.Background()
+	manager := newManager()
+	for i := range 2 {
+		if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if err := manager.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The restarted manager has no requests left this minute
+	restarted := newManager()
+	defer restarted.Close()
+
+	_, err := restarted.Generate(ctx, "a cat", nil)
+	var rateLimitErr *imagegen.RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected RateLimitError after restart, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+// Package metrics exposes Manager and rate limiter metrics in the Prometheus
+// text exposition format, without depending on a Prometheus client library.
+//
+// New registers an interceptor on the Manager to count requests, tokens,
+// images and estimated spend, and reads rate limiter state from the Manager
+// on every scrape:
+//
+//	collector := metrics.New(manager)
+//	http.Handle("/metrics", collector)
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// ContentType is the Content-Type of the exposition format served by Collector.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// OutcomeSuccess is the outcome label of successful requests. Failed requests
+// are labeled with imagegen.ErrorKind.
+const OutcomeSuccess = "success"
+
+// Collector counts Manager operations and serves them with the current rate
+// limiter state as Prometheus metrics.
+type Collector struct {
+	manager *imagegen.Manager
+
+	requests map[requestKey]float64
+	tokens   map[tokenKey]float64
+	images   map[imagegen.Model]float64
+	spend    map[imagegen.Model]float64
+
+	mu sync.Mutex
+}
+
+type requestKey struct {
+	model     imagegen.Model
+	operation imagegen.Operation
+	outcome   string
+}
+
+type tokenKey struct {
+	model     imagegen.Model
+	tokenType string
+}
+
+// Ensure Collector implements http.Handler.
+var _ http.Handler = (*Collector)(nil)
+
+// New creates a Collector and adds its interceptor to manager.
+func New(manager *imagegen.Manager) *Collector {
+	c := &Collector{
+		manager:  manager,
+		requests: make(map[requestKey]float64),
+		tokens:   make(map[tokenKey]float64),
+		images:   make(map[imagegen.Model]float64),
+		spend:    make(map[imagegen.Model]float64),
+	}
+
+	manager.Use(c.intercept)
+	return c
+}
+
+// intercept records the outcome of every Manager operation.
+func (c *Collector) intercept(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+	result, err := next(ctx, req)
+
+	var pricing imagegen.Pricing
+	if info, ok := c.manager.GetModelInfo(req.Model); ok && info != nil {
+		pricing = info.Pricing
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = imagegen.ErrorKind(err)
+	}
+	c.requests[requestKey{req.Model, req.Operation, outcome}]++
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.images[req.Model] += float64(len(result.Images))
+	c.spend[req.Model] += pricing.EstimateResultCost(result)
+	if usage := result.UsageMetadata; usage != nil {
+		c.tokens[tokenKey{req.Model, "input"}] += float64(usage.PromptTokens)
+		c.tokens[tokenKey{req.Model, "output"}] += float64(usage.CandidatesTokens)
+	}
+
+	return result, nil
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_ = c.WriteText(w)
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format.
+func (c *Collector) WriteText(w io.Writer) error {
+	families := c.collect()
+
+	var sb strings.Builder
+	for _, f := range families {
+		f.write(&sb)
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// collect snapshots the counters and the current rate limiter state.
+func (c *Collector) collect() []*family {
+	requests := newFamily("imagegen_requests_total", "counter", "Manager operations by model, operation and outcome.")
+	tokens := newFamily("imagegen_tokens_total", "counter", "Tokens reported by providers.")
+	images := newFamily("imagegen_images_total", "counter", "Images generated.")
+	spend := newFamily("imagegen_estimated_spend_usd_total", "counter", "Estimated spend in USD.")
+
+	c.mu.Lock()
+	for key, v := range c.requests {
+		requests.add(v, "model", string(key.model), "operation", string(key.operation), "outcome", key.outcome)
+	}
+	for key, v := range c.tokens {
+		tokens.add(v, "model", string(key.model), "type", key.tokenType)
+	}
+	for model, v := range c.images {
+		images.add(v, "model", string(model))
+	}
+	for model, v := range c.spend {
+		spend.add(v, "model", string(model))
+	}
+	c.mu.Unlock()
+
+	remaining := newFamily("imagegen_ratelimit_remaining", "gauge", "Tokens or requests currently available in the rate limiter bucket.")
+	capacity := newFamily("imagegen_ratelimit_capacity", "gauge", "Capacity of the rate limiter bucket.")
+	waiters := newFamily("imagegen_ratelimit_waiters", "gauge", "Goroutines blocked in WaitAndConsume.")
+
+	for model, limiter := range c.manager.RateLimiters() {
+		snapshot := limiter.Snapshot()
+		for _, bucket := range snapshot.Buckets {
+			remaining.add(float64(bucket.Remaining), "model", string(model), "bucket", bucket.Name)
+			capacity.add(float64(bucket.Capacity), "model", string(model), "bucket", bucket.Name)
+		}
+		waiters.add(float64(snapshot.Waiters), "model", string(model))
+	}
+
+	return []*family{requests, tokens, images, spend, remaining, capacity, waiters}
+}
+
+// family is a metric family in the exposition format.
+type family struct {
+	name    string
+	kind    string
+	help    string
+	samples []string
+}
+
+func newFamily(name, kind, help string) *family {
+	return &family{name: name, kind: kind, help: help}
+}
+
+// add appends a sample with labels given as alternating names and values.
+func (f *family) add(value float64, labels ...string) {
+	var sb strings.Builder
+	sb.WriteString(f.name)
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(&sb, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+
+	f.samples = append(f.samples, sb.String())
+}
+
+// write writes the family's HELP, TYPE and samples, sorted for stable output.
+func (f *family) write(sb *strings.Builder) {
+	fmt.Fprintf(sb, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(sb, "# TYPE %s %s\n", f.name, f.kind)
+
+	slices.Sort(f.samples)
+	for _, sample := range f.samples {
+		sb.WriteString(sample)
+		sb.WriteByte('\n')
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value for the exposition format.
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,112 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+	"github.com/mhpenta/imagegen/metrics"
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+func TestCollector(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 2000, RequestsPerMinute: 2}
+	info.Pricing = imagegen.Pricing{ImageGenerationCost: 0.25}
+
+	gen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+	}
+	manager := imagegen.NewManager(gen, imagegen.WithDefaultModel(imagegen.Model(info.Name)))
+	defer manager.Close()
+
+	collector := metrics.New(manager)
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+	}
+	// The requests bucket is now empty
+	if _, err := manager.Generate(ctx, "a cat", nil); !imagegen.IsRateLimitError(err) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, metrics.ContentType)
+	}
+
+	usage := imagegentest.DefaultResult().UsageMetadata
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE imagegen_requests_total counter",
+		`imagegen_requests_total{model="mock-model",operation="generate",outcome="success"} 2`,
+		`imagegen_requests_total{model="mock-model",operation="generate",outcome="rate_limit"} 1`,
+		`imagegen_images_total{model="mock-model"} 2`,
+		`imagegen_estimated_spend_usd_total{model="mock-model"} 0.5`,
+		`imagegen_tokens_total{model="mock-model",type="input"} ` + strconv.Itoa(2*usage.PromptTokens),
+		"# TYPE imagegen_ratelimit_remaining gauge",
+		`imagegen_ratelimit_remaining{model="mock-model",bucket="requests"} 0`,
+		`imagegen_ratelimit_capacity{model="mock-model",bucket="tokens"} 2000`,
+		`imagegen_ratelimit_waiters{model="mock-model"} 0`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestCollector_WaitersBehindProviderLimit(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 100_000, RequestsPerMinute: 100}
+
+	gen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+	}
+	manager := imagegen.NewManager(gen, imagegen.WithDefaultModel(imagegen.Model(info.Name)))
+	manager.SetProviderRateLimiter(info.Provider, ratelimiter.NewGCRA(0, 1))
+	defer manager.Close()
+
+	collector := metrics.New(manager)
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+
+	if _, err := manager.Generate(context.Background(), "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	// The next request waits for the provider's limit
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := manager.Generate(ctx, "a cat", &imagegen.GenerateConfig{WaitOnRateLimit: true})
+		done <- err
+	}()
+
+	want := `imagegen_ratelimit_waiters{model="mock-model"} 1` + "\n"
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(scrape(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("missing %q in:\n%s", want, scrape())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("expected the canceled wait to fail")
+	}
+}
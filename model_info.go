@@ -1,5 +1,7 @@
 package imagegen
 
+import "github.com/mhpenta/imagegen/ratelimiter"
+
 // ModelCapabilities describes what features a model supports.
 type ModelCapabilities struct {
 	// Generation modes
@@ -25,6 +27,16 @@ type RateLimits struct {
 	TokensPerDay      int // 0 = unlimited
 }
 
+// scale returns the limits multiplied by f. Limits that are set stay at
+// least 1.
+func (r RateLimits) scale(f float64) RateLimits {
+	return RateLimits{
+		TokensPerMinute:   ratelimiter.ScaleLimit(r.TokensPerMinute, f),
+		RequestsPerMinute: ratelimiter.ScaleLimit(r.RequestsPerMinute, f),
+		TokensPerDay:      ratelimiter.ScaleLimit(r.TokensPerDay, f),
+	}
+}
+
 // Pricing defines cost information for a model.
 type Pricing struct {
 	InputTokensPerMillion  float64
@@ -47,6 +59,15 @@ func (p Pricing) EstimateCostFromUsage(usage *UsageMetadata) float64 {
 	return p.EstimateCost(usage.PromptTokens, usage.CandidatesTokens)
 }
 
+// EstimateResultCost calculates the estimated cost of a result from its usage
+// metadata plus the per-image cost of the generated images.
+func (p Pricing) EstimateResultCost(result *GenerateResult) float64 {
+	if result == nil {
+		return 0
+	}
+	return p.EstimateCostFromUsage(result.UsageMetadata) + float64(len(result.Images))*p.ImageGenerationCost
+}
+
 // ImageConstraints defines supported image configurations for a model.
 type ImageConstraints struct {
 	SupportedAspectRatios []AspectRatio
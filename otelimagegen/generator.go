@@ -0,0 +1,148 @@
+package otelimagegen
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// WrapGenerator returns an ImageGenerator that records a client span and
+// imagegen.provider.duration around every call to gen. If gen implements
+// ConversationalImageGenerator, so does the result.
+func (i *Instrumentation) WrapGenerator(gen imagegen.ImageGenerator) imagegen.ImageGenerator {
+	g := &generator{
+		inner:  gen,
+		inst:   i,
+		models: make(map[string]imagegen.ModelInfo),
+	}
+	for _, info := range gen.Models() {
+		g.models[info.APIModelName] = info
+	}
+
+	if convGen, ok := gen.(imagegen.ConversationalImageGenerator); ok {
+		return &conversationalGenerator{generator: g, convGen: convGen}
+	}
+	return g
+}
+
+// generator wraps an ImageGenerator with provider spans.
+type generator struct {
+	inner imagegen.ImageGenerator
+	inst  *Instrumentation
+
+	// models maps API model names to their info
+	models map[string]imagegen.ModelInfo
+}
+
+// conversationalGenerator wraps a ConversationalImageGenerator.
+type conversationalGenerator struct {
+	*generator
+	convGen imagegen.ConversationalImageGenerator
+}
+
+// Ensure the wrappers implement the interfaces.
+var (
+	_ imagegen.ImageGenerator               = (*generator)(nil)
+	_ imagegen.ConversationalImageGenerator = (*conversationalGenerator)(nil)
+)
+
+func (g *generator) Generate(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return g.call(ctx, imagegen.OperationGenerate, config, 0, func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return g.inner.Generate(ctx, prompt, config)
+	})
+}
+
+func (g *generator) Edit(ctx context.Context, image imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return g.call(ctx, imagegen.OperationEdit, config, 1, func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return g.inner.Edit(ctx, image, instruction, config)
+	})
+}
+
+func (g *generator) EditMultiple(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return g.call(ctx, imagegen.OperationEditMultiple, config, len(images), func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return g.inner.EditMultiple(ctx, images, instruction, config)
+	})
+}
+
+func (g *generator) Models() []imagegen.ModelInfo {
+	return g.inner.Models()
+}
+
+func (g *generator) Close() error {
+	return g.inner.Close()
+}
+
+func (g *conversationalGenerator) StartConversation() imagegen.Conversation {
+	return &conversation{
+		inner:     g.convGen.StartConversation(),
+		generator: g.generator,
+	}
+}
+
+// call runs fn inside a provider span.
+func (g *generator) call(ctx context.Context, op imagegen.Operation, config *imagegen.GenerateConfig, inputImages int, fn func(context.Context) (*imagegen.GenerateResult, error)) (*imagegen.GenerateResult, error) {
+	var apiModel string
+	if config != nil {
+		apiModel = string(config.Model)
+	}
+	info, known := g.models[apiModel]
+
+	attrs := []attribute.KeyValue{
+		AttrOperation.String(string(op)),
+		AttrAPIModel.String(apiModel),
+	}
+	if known {
+		attrs = append(attrs, AttrProvider.String(string(info.Provider)))
+	}
+
+	ctx, span := g.inst.tracer.Start(ctx, "imagegen.provider."+string(op),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(AttrInputImages.Int(inputImages)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	result, err := fn(ctx)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		endWithError(span, err)
+		attrs = append(attrs, AttrErrorType.String(imagegen.ErrorKind(err)))
+		g.inst.providerDuration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(attrs...))
+		return nil, err
+	}
+
+	setResultAttributes(span, result)
+	if known {
+		span.SetAttributes(AttrCost.Float64(info.Pricing.EstimateResultCost(result)))
+	}
+	g.inst.providerDuration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(attrs...))
+
+	return result, nil
+}
+
+// conversation wraps a provider Conversation with provider spans.
+type conversation struct {
+	inner     imagegen.Conversation
+	generator *generator
+}
+
+func (c *conversation) Send(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	return c.generator.call(ctx, imagegen.OperationConversation, config, len(images), func(ctx context.Context) (*imagegen.GenerateResult, error) {
+		return c.inner.Send(ctx, prompt, images, config)
+	})
+}
+
+func (c *conversation) History() []imagegen.ConversationTurn {
+	return c.inner.History()
+}
+
+func (c *conversation) Clear() {
+	c.inner.Clear()
+}
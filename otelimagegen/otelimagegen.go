@@ -0,0 +1,282 @@
+// Package otelimagegen instruments imagegen with OpenTelemetry tracing and
+// metrics.
+//
+// Instrument adds an interceptor to a Manager that records a span and metrics
+// for every operation, including conversation turns:
+//
+//	inst, err := otelimagegen.Instrument(manager)
+//
+// WrapGenerator adds child spans around the provider calls themselves:
+//
+//	inst, err := otelimagegen.New()
+//	manager := imagegen.NewManager(inst.WrapGenerator(gen),
+//	    imagegen.WithInterceptor(inst.Interceptor()),
+//	)
+//
+// The global TracerProvider and MeterProvider are used unless overridden
+// with WithTracerProvider and WithMeterProvider.
+package otelimagegen
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// ScopeName is the instrumentation scope name used for tracers and meters.
+const ScopeName = "github.com/mhpenta/imagegen/otelimagegen"
+
+// Attribute keys set on spans and metrics.
+const (
+	AttrOperation     = attribute.Key("imagegen.operation")
+	AttrModel         = attribute.Key("imagegen.model")
+	AttrAPIModel      = attribute.Key("imagegen.api_model")
+	AttrProvider      = attribute.Key("imagegen.provider")
+	AttrInputImages   = attribute.Key("imagegen.input_images")
+	AttrOutputImages  = attribute.Key("imagegen.output_images")
+	AttrHistoryTurns  = attribute.Key("imagegen.history_turns")
+	AttrPromptTokens  = attribute.Key("imagegen.usage.prompt_tokens")
+	AttrOutputTokens  = attribute.Key("imagegen.usage.output_tokens")
+	AttrTotalTokens   = attribute.Key("imagegen.usage.total_tokens")
+	AttrCost          = attribute.Key("imagegen.cost_usd")
+	AttrRateLimitWait = attribute.Key("imagegen.rate_limit.wait_seconds")
+	AttrAttempts      = attribute.Key("imagegen.attempts")
+	AttrTokenType     = attribute.Key("imagegen.token.type")
+	AttrErrorType     = attribute.Key("error.type")
+)
+
+// PricingFunc returns the pricing for a model, used to estimate cost.
+type PricingFunc func(model imagegen.Model) (imagegen.Pricing, bool)
+
+// Option configures an Instrumentation.
+type Option func(*config)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	pricing        PricingFunc
+}
+
+// WithTracerProvider sets the TracerProvider used to create spans.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider sets the MeterProvider used to create instruments.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+// WithPricing sets how the interceptor looks up pricing to estimate cost.
+// Without it, operation spans and metrics carry no cost.
+func WithPricing(pricing PricingFunc) Option {
+	return func(c *config) {
+		c.pricing = pricing
+	}
+}
+
+// Instrumentation holds the tracer and metric instruments.
+//
+// Operation-level metrics (duration, tokens, images, cost, rate limit wait and
+// errors) are recorded by the interceptor. Provider wrappers only record
+// spans and imagegen.provider.duration, so nothing is counted twice.
+type Instrumentation struct {
+	tracer  trace.Tracer
+	pricing PricingFunc
+
+	duration         metric.Float64Histogram
+	providerDuration metric.Float64Histogram
+	rateLimitWait    metric.Float64Histogram
+	tokens           metric.Int64Counter
+	images           metric.Int64Counter
+	cost             metric.Float64Counter
+	errors           metric.Int64Counter
+}
+
+// New creates an Instrumentation.
+func New(opts ...Option) (*Instrumentation, error) {
+	cfg := config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	meter := cfg.meterProvider.Meter(ScopeName)
+	inst := &Instrumentation{
+		tracer:  cfg.tracerProvider.Tracer(ScopeName),
+		pricing: cfg.pricing,
+	}
+
+	var err error
+	if inst.duration, err = meter.Float64Histogram("imagegen.operation.duration",
+		metric.WithDescription("Duration of Manager operations."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if inst.providerDuration, err = meter.Float64Histogram("imagegen.provider.duration",
+		metric.WithDescription("Duration of provider calls."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if inst.rateLimitWait, err = meter.Float64Histogram("imagegen.rate_limit.wait",
+		metric.WithDescription("Time spent waiting for the rate limiter."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if inst.tokens, err = meter.Int64Counter("imagegen.tokens",
+		metric.WithDescription("Tokens reported by providers."),
+		metric.WithUnit("{token}"),
+	); err != nil {
+		return nil, err
+	}
+	if inst.images, err = meter.Int64Counter("imagegen.images",
+		metric.WithDescription("Images generated."),
+		metric.WithUnit("{image}"),
+	); err != nil {
+		return nil, err
+	}
+	if inst.cost, err = meter.Float64Counter("imagegen.cost",
+		metric.WithDescription("Estimated spend in USD."),
+		metric.WithUnit("{USD}"),
+	); err != nil {
+		return nil, err
+	}
+	if inst.errors, err = meter.Int64Counter("imagegen.errors",
+		metric.WithDescription("Failed Manager operations by error type."),
+		metric.WithUnit("{error}"),
+	); err != nil {
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+// Instrument creates an Instrumentation that looks up pricing from manager
+// and adds its interceptor to manager. Options override the pricing lookup.
+func Instrument(manager *imagegen.Manager, opts ...Option) (*Instrumentation, error) {
+	pricing := func(model imagegen.Model) (imagegen.Pricing, bool) {
+		info, ok := manager.GetModelInfo(model)
+		if !ok || info == nil {
+			return imagegen.Pricing{}, false
+		}
+		return info.Pricing, true
+	}
+
+	inst, err := New(append([]Option{WithPricing(pricing)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	manager.Use(inst.Interceptor())
+	return inst, nil
+}
+
+// Interceptor returns an imagegen.Interceptor that records a span and
+// operation metrics for every Manager operation.
+func (i *Instrumentation) Interceptor() imagegen.Interceptor {
+	return func(ctx context.Context, req *imagegen.Request, next imagegen.Handler) (*imagegen.GenerateResult, error) {
+		ctx, span := i.tracer.Start(ctx, "imagegen."+string(req.Operation),
+			trace.WithAttributes(
+				AttrOperation.String(string(req.Operation)),
+				AttrInputImages.Int(len(req.Images)),
+			),
+		)
+		defer span.End()
+
+		if req.Operation == imagegen.OperationConversation {
+			span.SetAttributes(AttrHistoryTurns.Int(len(req.History)))
+		}
+
+		start := time.Now()
+		result, err := next(ctx, req)
+		elapsed := time.Since(start)
+
+		// Read the model and provider after routing, since interceptors may
+		// have changed them
+		attrs := []attribute.KeyValue{
+			AttrOperation.String(string(req.Operation)),
+			AttrModel.String(string(req.Model)),
+			AttrProvider.String(string(req.Provider)),
+		}
+		span.SetAttributes(attrs[1:]...)
+		span.SetAttributes(
+			AttrAttempts.Int(req.Attempts),
+			AttrRateLimitWait.Float64(req.RateLimitWait.Seconds()),
+		)
+		i.rateLimitWait.Record(ctx, req.RateLimitWait.Seconds(), metric.WithAttributes(attrs...))
+
+		if err != nil {
+			endWithError(span, err)
+			errAttrs := append(attrs, AttrErrorType.String(imagegen.ErrorKind(err)))
+			i.duration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(errAttrs...))
+			i.errors.Add(ctx, 1, metric.WithAttributes(errAttrs...))
+			return nil, err
+		}
+
+		i.duration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(attrs...))
+		i.recordResult(ctx, span, attrs, req.Model, result)
+
+		return result, nil
+	}
+}
+
+// recordResult records the usage, image count and cost of a successful
+// operation on span and the counters.
+func (i *Instrumentation) recordResult(ctx context.Context, span trace.Span, attrs []attribute.KeyValue, model imagegen.Model, result *imagegen.GenerateResult) {
+	setResultAttributes(span, result)
+	set := metric.WithAttributes(attrs...)
+
+	i.images.Add(ctx, int64(len(result.Images)), set)
+
+	if usage := result.UsageMetadata; usage != nil {
+		i.tokens.Add(ctx, int64(usage.PromptTokens),
+			metric.WithAttributes(append(attrs, AttrTokenType.String("input"))...))
+		i.tokens.Add(ctx, int64(usage.CandidatesTokens),
+			metric.WithAttributes(append(attrs, AttrTokenType.String("output"))...))
+	}
+
+	if i.pricing == nil {
+		return
+	}
+	if pricing, ok := i.pricing(model); ok {
+		cost := pricing.EstimateResultCost(result)
+		span.SetAttributes(AttrCost.Float64(cost))
+		i.cost.Add(ctx, cost, set)
+	}
+}
+
+// setResultAttributes sets image count and usage attributes on span.
+func setResultAttributes(span trace.Span, result *imagegen.GenerateResult) {
+	span.SetAttributes(AttrOutputImages.Int(len(result.Images)))
+
+	if usage := result.UsageMetadata; usage != nil {
+		span.SetAttributes(
+			AttrPromptTokens.Int(usage.PromptTokens),
+			AttrOutputTokens.Int(usage.CandidatesTokens),
+			AttrTotalTokens.Int(usage.TotalTokens),
+		)
+	}
+}
+
+// endWithError records err on span and marks it failed.
+func endWithError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetAttributes(AttrErrorType.String(imagegen.ErrorKind(err)))
+	span.SetStatus(codes.Error, err.Error())
+}
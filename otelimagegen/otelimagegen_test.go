@@ -0,0 +1,200 @@
+package otelimagegen_test
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+	"github.com/mhpenta/imagegen/otelimagegen"
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+// newTestManager returns a manager instrumented with in-memory exporters.
+func newTestManager(t *testing.T) (*imagegen.Manager, *tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	info := imagegentest.MockModelInfo
+	info.Pricing = imagegen.Pricing{
+		InputTokensPerMillion:  1_000_000,
+		OutputTokensPerMillion: 1_000_000,
+		ImageGenerationCost:    0.5,
+	}
+
+	inst, err := otelimagegen.New(otelimagegen.WithTracerProvider(tp), otelimagegen.WithMeterProvider(mp))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	gen := &imagegentest.MockConversationalGenerator{
+		MockGenerator: imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+		},
+	}
+	manager := imagegen.NewManager(inst.WrapGenerator(gen),
+		imagegen.WithDefaultModel(imagegen.Model(info.Name)),
+	)
+	t.Cleanup(func() { manager.Close() })
+
+	if _, err := otelimagegen.Instrument(manager, otelimagegen.WithTracerProvider(tp), otelimagegen.WithMeterProvider(mp)); err != nil {
+		t.Fatalf("Instrument: %v", err)
+	}
+
+	return manager, spans, reader
+}
+
+func TestInstrument_Spans(t *testing.T) {
+	manager, spans, _ := newTestManager(t)
+
+	if _, err := manager.Generate(context.Background(), "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	conv := manager.StartConversation()
+	if _, err := conv.Send(context.Background(), "a dog", nil, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := spans.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range got {
+		byName[span.Name] = span
+	}
+
+	for _, name := range []string{"imagegen.generate", "imagegen.provider.generate", "imagegen.conversation", "imagegen.provider.conversation"} {
+		if _, ok := byName[name]; !ok {
+			t.Fatalf("missing span %s, got %d spans", name, len(got))
+		}
+	}
+
+	op, provider := byName["imagegen.generate"], byName["imagegen.provider.generate"]
+	if provider.Parent.SpanID() != op.SpanContext.SpanID() {
+		t.Error("provider span is not a child of the operation span")
+	}
+
+	attrs := attributeMap(op.Attributes)
+	tests := []struct {
+		key  attribute.Key
+		want attribute.Value
+	}{
+		{otelimagegen.AttrModel, attribute.StringValue(imagegentest.MockModelInfo.Name)},
+		{otelimagegen.AttrProvider, attribute.StringValue(string(imagegentest.MockProvider))},
+		{otelimagegen.AttrOutputImages, attribute.IntValue(1)},
+		{otelimagegen.AttrAttempts, attribute.IntValue(1)},
+		{otelimagegen.AttrTotalTokens, attribute.IntValue(imagegentest.DefaultResult().UsageMetadata.TotalTokens)},
+	}
+	for _, tt := range tests {
+		if got, ok := attrs[tt.key]; !ok || got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.key, got.Emit(), tt.want.Emit())
+		}
+	}
+	if _, ok := attrs[otelimagegen.AttrCost]; !ok {
+		t.Error("operation span has no cost")
+	}
+	// The wait is recorded even for requests that do not wait
+	if _, ok := attrs[otelimagegen.AttrRateLimitWait]; !ok {
+		t.Error("operation span has no rate limit wait")
+	}
+
+	providerAttrs := attributeMap(provider.Attributes)
+	if got := providerAttrs[otelimagegen.AttrAPIModel].AsString(); got != imagegentest.MockModelInfo.APIModelName {
+		t.Errorf("api model = %q, want %q", got, imagegentest.MockModelInfo.APIModelName)
+	}
+}
+
+func TestInstrument_Metrics(t *testing.T) {
+	manager, spans, reader := newTestManager(t)
+	ctx := context.Background()
+
+	if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	manager.SetRateLimiter(imagegen.Model(imagegentest.MockModelInfo.Name), ratelimiter.New(1, 1))
+	_, err := manager.Generate(ctx, "a cat", nil)
+	if !imagegen.IsRateLimitError(err) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+
+	usage := imagegentest.DefaultResult().UsageMetadata
+	if got := sumInt(rm, "imagegen.tokens"); got != int64(usage.PromptTokens+usage.CandidatesTokens) {
+		t.Errorf("tokens = %d, want %d", got, usage.PromptTokens+usage.CandidatesTokens)
+	}
+	if got := sumInt(rm, "imagegen.images"); got != 1 {
+		t.Errorf("images = %d, want 1", got)
+	}
+
+	errs := findMetric(rm, "imagegen.errors")
+	if errs == nil {
+		t.Fatal("no imagegen.errors metric")
+	}
+	points := errs.Data.(metricdata.Sum[int64]).DataPoints
+	if len(points) != 1 {
+		t.Fatalf("got %d error data points, want 1", len(points))
+	}
+	if kind, _ := points[0].Attributes.Value(otelimagegen.AttrErrorType); kind.AsString() != "rate_limit" {
+		t.Errorf("error.type = %q, want rate_limit", kind.AsString())
+	}
+
+	if findMetric(rm, "imagegen.operation.duration") == nil || findMetric(rm, "imagegen.provider.duration") == nil {
+		t.Error("missing duration histograms")
+	}
+	if findMetric(rm, "imagegen.cost") == nil {
+		t.Error("missing cost counter")
+	}
+
+	// The rejected request's span is marked failed
+	got := spans.GetSpans()
+	last := got[len(got)-1]
+	if last.Name != "imagegen.generate" || last.Status.Code != codes.Error {
+		t.Errorf("last span %s has status %v, want failed imagegen.generate", last.Name, last.Status.Code)
+	}
+}
+
+func attributeMap(attrs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(attrs))
+	for _, kv := range attrs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func findMetric(rm metricdata.ResourceMetrics, name string) *metricdata.Metrics {
+	for _, sm := range rm.ScopeMetrics {
+		for i := range sm.Metrics {
+			if sm.Metrics[i].Name == name {
+				return &sm.Metrics[i]
+			}
+		}
+	}
+	return nil
+}
+
+func sumInt(rm metricdata.ResourceMetrics, name string) int64 {
+	m := findMetric(rm, name)
+	if m == nil {
+		return 0
+	}
+
+	var total int64
+	for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+		total += point.Value
+	}
+	return total
+}
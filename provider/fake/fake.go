@@ -0,0 +1,312 @@
+// Package fake provides a deterministic, offline ImageGenerator for tests and
+// local development.
+//
+// The generator renders real PNG or JPEG images using only the standard
+// library. Each image is sized according to GenerateConfig.Size and
+// AspectRatio, and shows a hash of the request so different prompts produce
+// visibly different images. Identical requests always produce identical bytes.
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// ProviderFake identifies the fake provider.
+const ProviderFake imagegen.Provider = "fake"
+
+// ModelFake is the public name of the fake model.
+const ModelFake imagegen.Model = "fake-image"
+
+// ErrInjectedFailure is returned for failures injected via Options.FailureRate.
+var ErrInjectedFailure = errors.New("fake: injected failure")
+
+// Options configures a fake Generator.
+type Options struct {
+	// Latency is added to every call. The call returns early if the context is cancelled.
+	Latency time.Duration
+
+	// LatencyJitter adds a random duration in [0, LatencyJitter) to Latency.
+	LatencyJitter time.Duration
+
+	// FailureRate is the probability (0.0-1.0) that a call fails.
+	FailureRate float64
+
+	// FailureErr is returned for injected failures. Defaults to ErrInjectedFailure.
+	FailureErr error
+
+	// Seed seeds the random source used for jitter and failures.
+	Seed int64
+
+	// MIMEType of rendered images: "image/png" (default) or "image/jpeg".
+	MIMEType string
+
+	// ImagesPerRequest is the number of images returned per call (default 1).
+	ImagesPerRequest int
+
+	// MaxDimension caps the longest image edge, e.g. to keep tests fast.
+	// Zero renders at the full size for the configured ImageSize.
+	MaxDimension int
+
+	// Usage, if set, is returned as the usage metadata of every result.
+	// Otherwise usage is estimated from the prompt and image counts.
+	Usage *imagegen.UsageMetadata
+
+	// ThinkingText is returned as ThinkingContent when EnableThinking is set.
+	// Defaults to a short description of the request.
+	ThinkingText string
+
+	// Models overrides the models reported by Models().
+	Models []imagegen.ModelInfo
+}
+
+// Generator implements ConversationalImageGenerator without any network access.
+type Generator struct {
+	opts Options
+
+	rng   *rand.Rand
+	calls int
+	mu    sync.Mutex
+}
+
+// Ensure Generator implements the interfaces.
+var (
+	_ imagegen.ImageGenerator               = (*Generator)(nil)
+	_ imagegen.ConversationalImageGenerator = (*Generator)(nil)
+)
+
+// New creates a fake Generator.
+func New(opts Options) *Generator {
+	if opts.MIMEType == "" {
+		opts.MIMEType = "image/png"
+	}
+	if opts.ImagesPerRequest <= 0 {
+		opts.ImagesPerRequest = 1
+	}
+	if opts.FailureErr == nil {
+		opts.FailureErr = ErrInjectedFailure
+	}
+
+	return &Generator{
+		opts: opts,
+		rng:  rand.New(rand.NewSource(opts.Seed)),
+	}
+}
+
+// Generate creates images from a text prompt.
+func (g *Generator) Generate(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	if err := imagegen.ValidatePrompt(prompt); err != nil {
+		return nil, err
+	}
+
+	return g.render(ctx, prompt, nil, 0, config)
+}
+
+// Edit modifies an existing image based on a text instruction.
+func (g *Generator) Edit(ctx context.Context, image imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	if err := imagegen.ValidatePrompt(instruction); err != nil {
+		return nil, err
+	}
+	if err := imagegen.ValidateInputImage(image); err != nil {
+		return nil, err
+	}
+
+	return g.render(ctx, instruction, []imagegen.InputImage{image}, 0, config)
+}
+
+// EditMultiple performs editing with multiple reference images.
+func (g *Generator) EditMultiple(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	if err := imagegen.ValidatePrompt(instruction); err != nil {
+		return nil, err
+	}
+	if err := imagegen.ValidateInputImages(images); err != nil {
+		return nil, err
+	}
+
+	return g.render(ctx, instruction, images, 0, config)
+}
+
+// Models returns the model definitions supported by this provider.
+func (g *Generator) Models() []imagegen.ModelInfo {
+	if len(g.opts.Models) > 0 {
+		return g.opts.Models
+	}
+	return []imagegen.ModelInfo{ModelInfo}
+}
+
+// Close releases any resources held by the generator.
+func (g *Generator) Close() error {
+	return nil
+}
+
+// StartConversation begins a new image generation conversation.
+func (g *Generator) StartConversation() imagegen.Conversation {
+	return &Conversation{
+		generator: g,
+		history:   make([]imagegen.ConversationTurn, 0),
+	}
+}
+
+// Calls returns the number of provider calls made, including failed ones.
+func (g *Generator) Calls() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+// render simulates latency and failures, then renders the result images.
+// turn distinguishes otherwise identical requests within a conversation.
+func (g *Generator) render(ctx context.Context, prompt string, images []imagegen.InputImage, turn int, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	if config == nil {
+		config = imagegen.DefaultConfig()
+	}
+
+	g.mu.Lock()
+	g.calls++
+	latency := g.opts.Latency
+	if g.opts.LatencyJitter > 0 {
+		latency += time.Duration(g.rng.Int63n(int64(g.opts.LatencyJitter)))
+	}
+	fail := g.opts.FailureRate > 0 && g.rng.Float64() < g.opts.FailureRate
+	g.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	} else if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if fail {
+		return nil, g.opts.FailureErr
+	}
+
+	digest := requestDigest(prompt, images, turn, config)
+	width, height := dimensions(config.Size, config.AspectRatio, g.opts.MaxDimension)
+
+	result := &imagegen.GenerateResult{
+		Images: make([]imagegen.GeneratedImage, 0, g.opts.ImagesPerRequest),
+		Text:   fmt.Sprintf("Fake image %x", digest[:8]),
+	}
+
+	for i := 0; i < g.opts.ImagesPerRequest; i++ {
+		data, err := renderImage(digest, i, width, height, g.opts.MIMEType)
+		if err != nil {
+			return nil, fmt.Errorf("fake: rendering image: %w", err)
+		}
+		result.Images = append(result.Images, imagegen.GeneratedImage{
+			Data:     data,
+			MIMEType: g.opts.MIMEType,
+			Index:    i,
+		})
+	}
+
+	if config.EnableThinking {
+		result.ThinkingContent = g.opts.ThinkingText
+		if result.ThinkingContent == "" {
+			result.ThinkingContent = fmt.Sprintf("Planning a %dx%d composition for: %s", width, height, prompt)
+		}
+	}
+
+	result.UsageMetadata = g.usage(prompt, len(images), len(result.Images))
+
+	return result, nil
+}
+
+// usage returns the configured usage, or an estimate shaped like Gemini's billing.
+func (g *Generator) usage(prompt string, inputImages, outputImages int) *imagegen.UsageMetadata {
+	if g.opts.Usage != nil {
+		usage := *g.opts.Usage
+		return &usage
+	}
+
+	const (
+		tokensPerInputImage  = 560
+		tokensPerOutputImage = 1120
+	)
+
+	promptTokens := int(math.Ceil(float64(len(prompt))/4)) + inputImages*tokensPerInputImage
+	candidatesTokens := outputImages * tokensPerOutputImage
+
+	return &imagegen.UsageMetadata{
+		PromptTokens:     promptTokens,
+		CandidatesTokens: candidatesTokens,
+		TotalTokens:      promptTokens + candidatesTokens,
+		ImageCount:       outputImages,
+	}
+}
+
+// Conversation implements multi-turn generation for the fake provider.
+// Each turn's image depends on the prompt, the input images and the turn number.
+type Conversation struct {
+	generator *Generator
+	history   []imagegen.ConversationTurn
+
+	mu sync.Mutex
+}
+
+// Send sends a message and receives a response.
+func (c *Conversation) Send(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prompt == "" && len(images) == 0 {
+		return nil, imagegen.ErrEmptyPrompt
+	}
+
+	result, err := c.generator.render(ctx, prompt, images, len(c.history)/2+1, config)
+	if err != nil {
+		return nil, err
+	}
+
+	userTurn := imagegen.ConversationTurn{
+		Role: "user",
+		Text: prompt,
+	}
+	for _, img := range images {
+		userTurn.Images = append(userTurn.Images, imagegen.GeneratedImage{
+			Data:     img.Data,
+			MIMEType: img.MIMEType,
+		})
+	}
+	c.history = append(c.history, userTurn)
+
+	c.history = append(c.history, imagegen.ConversationTurn{
+		Role:   "model",
+		Text:   result.Text,
+		Images: result.Images,
+	})
+
+	return result, nil
+}
+
+// History returns the conversation history.
+func (c *Conversation) History() []imagegen.ConversationTurn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	historyCopy := make([]imagegen.ConversationTurn, len(c.history))
+	copy(historyCopy, c.history)
+	return historyCopy
+}
+
+// Clear resets the conversation history.
+func (c *Conversation) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.history = make([]imagegen.ConversationTurn, 0)
+}
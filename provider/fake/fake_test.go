@@ -0,0 +1,167 @@
+package fake
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+)
+
+func TestConformance(t *testing.T) {
+	imagegentest.RunConformance(t, func(t *testing.T) imagegen.ImageGenerator {
+		return New(Options{MaxDimension: 64})
+	})
+}
+
+func decode(t *testing.T, data []byte) (image.Image, string) {
+	t.Helper()
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	return img, format
+}
+
+func TestGenerate_Dimensions(t *testing.T) {
+	gen := New(Options{})
+	ctx := context.Background()
+
+	tests := []struct {
+		size          imagegen.ImageSize
+		aspect        imagegen.AspectRatio
+		width, height int
+	}{
+		{imagegen.ImageSize1K, imagegen.AspectRatio1x1, 1024, 1024},
+		{imagegen.ImageSize1K, imagegen.AspectRatio16x9, 1024, 576},
+		{imagegen.ImageSize1K, imagegen.AspectRatio9x16, 576, 1024},
+		{imagegen.ImageSize1K, imagegen.AspectRatioAuto, 1024, 1024},
+		{imagegen.ImageSize2K, imagegen.AspectRatio4x3, 2048, 1536},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.size)+"_"+string(tt.aspect), func(t *testing.T) {
+			result, err := gen.Generate(ctx, "a lighthouse", &imagegen.GenerateConfig{
+				Size:        tt.size,
+				AspectRatio: tt.aspect,
+			})
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			img, format := decode(t, result.Images[0].Data)
+			if format != "png" {
+				t.Errorf("expected png, got %s", format)
+			}
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("expected %dx%d, got %dx%d", tt.width, tt.height, b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestGenerate_Deterministic(t *testing.T) {
+	gen := New(Options{MaxDimension: 256})
+	ctx := context.Background()
+	config := &imagegen.GenerateConfig{Size: imagegen.ImageSize1K}
+
+	first, err := gen.Generate(ctx, "a red fox", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(Options{MaxDimension: 256}).Generate(ctx, "a red fox", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := gen.Generate(ctx, "a blue fox", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first.Images[0].Data, second.Images[0].Data) {
+		t.Error("identical requests should render identical images")
+	}
+	if bytes.Equal(first.Images[0].Data, other.Images[0].Data) {
+		t.Error("different prompts should render different images")
+	}
+	if first.UsageMetadata == nil || first.UsageMetadata.ImageCount != 1 || first.UsageMetadata.CandidatesTokens == 0 {
+		t.Errorf("unexpected usage metadata: %+v", first.UsageMetadata)
+	}
+}
+
+func TestGenerate_Options(t *testing.T) {
+	usage := &imagegen.UsageMetadata{PromptTokens: 7, CandidatesTokens: 11, TotalTokens: 18, ImageCount: 2}
+	gen := New(Options{
+		MIMEType:         "image/jpeg",
+		ImagesPerRequest: 2,
+		MaxDimension:     128,
+		Usage:            usage,
+		ThinkingText:     "thinking hard",
+	})
+
+	result, err := gen.Generate(context.Background(), "a bridge", &imagegen.GenerateConfig{EnableThinking: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Images) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(result.Images))
+	}
+	if _, format := decode(t, result.Images[1].Data); format != "jpeg" {
+		t.Errorf("expected jpeg, got %s", format)
+	}
+	if result.ThinkingContent != "thinking hard" {
+		t.Errorf("unexpected thinking content %q", result.ThinkingContent)
+	}
+	if *result.UsageMetadata != *usage {
+		t.Errorf("expected configured usage, got %+v", result.UsageMetadata)
+	}
+}
+
+func TestGenerate_FailuresAndLatency(t *testing.T) {
+	failing := New(Options{FailureRate: 1})
+	if _, err := failing.Generate(context.Background(), "x", nil); !errors.Is(err, ErrInjectedFailure) {
+		t.Errorf("expected ErrInjectedFailure, got %v", err)
+	}
+	if failing.Calls() != 1 {
+		t.Errorf("expected 1 call, got %d", failing.Calls())
+	}
+
+	slow := New(Options{Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := slow.Generate(ctx, "x", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestConversation_ThroughManager(t *testing.T) {
+	gen := New(Options{MaxDimension: 64})
+	manager := imagegen.NewManager(gen, imagegen.WithDefaultModel(ModelFake))
+	defer manager.Close()
+
+	ctx := context.Background()
+	conv := manager.StartConversation()
+
+	first, err := conv.Send(ctx, "a house", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := conv.Send(ctx, "a house", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(first.Images[0].Data, second.Images[0].Data) {
+		t.Error("successive turns should render different images")
+	}
+	if got := len(conv.History()); got != 4 {
+		t.Errorf("expected 4 history turns, got %d", got)
+	}
+}
@@ -0,0 +1,53 @@
+package fake
+
+import "github.com/mhpenta/imagegen"
+
+// ModelInfo is the model info for the fake model.
+//
+// It mirrors the capabilities and constraints of the Gemini image models and
+// has no rate limits, so the Manager creates no limiter for it by default.
+var ModelInfo = imagegen.ModelInfo{
+	Name:         string(ModelFake),
+	Provider:     ProviderFake,
+	APIModelName: "fake-image-v1",
+
+	Capabilities: imagegen.ModelCapabilities{
+		SupportsTextToImage:  true,
+		SupportsImageEditing: true,
+		SupportsMultiImage:   true,
+		SupportsConversation: true,
+		SupportsStreaming:    false,
+		SupportsGrounding:    false,
+		SupportsThinking:     true,
+		MaxInputImages:       imagegen.MaxInputImages,
+		MaxOutputImages:      4,
+	},
+
+	ContextLength: 1048576,
+
+	ImageConstraints: imagegen.ImageConstraints{
+		SupportedAspectRatios: []imagegen.AspectRatio{
+			imagegen.AspectRatio1x1,
+			imagegen.AspectRatio16x9,
+			imagegen.AspectRatio9x16,
+			imagegen.AspectRatio4x3,
+			imagegen.AspectRatio3x4,
+			imagegen.AspectRatio2x3,
+			imagegen.AspectRatio3x2,
+			imagegen.AspectRatio4x5,
+			imagegen.AspectRatio5x4,
+			imagegen.AspectRatio21x9,
+		},
+		SupportedSizes: []imagegen.ImageSize{
+			imagegen.ImageSize1K,
+			imagegen.ImageSize2K,
+			imagegen.ImageSize4K,
+		},
+	},
+
+	// Nominal pricing so cost estimates are non-zero in tests
+	Pricing: imagegen.Pricing{
+		InputTokensPerMillion:  1.00,
+		OutputTokensPerMillion: 10.00,
+	},
+}
@@ -0,0 +1,162 @@
+package fake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// requestDigest hashes everything that determines the rendered output.
+func requestDigest(prompt string, images []imagegen.InputImage, turn int, config *imagegen.GenerateConfig) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "prompt:%d:%s\n", len(prompt), prompt)
+	for _, img := range images {
+		imgHash := sha256.Sum256(img.Data)
+		fmt.Fprintf(h, "image:%s:%x:%s\n", img.MIMEType, imgHash, img.URI)
+	}
+	fmt.Fprintf(h, "turn:%d\nsize:%s\naspect:%s\n", turn, config.Size, config.AspectRatio)
+
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// dimensions returns the pixel size for an ImageSize and AspectRatio.
+// The longest edge is 1024, 2048 or 4096 pixels, capped at maxDim if positive.
+func dimensions(size imagegen.ImageSize, aspect imagegen.AspectRatio, maxDim int) (int, int) {
+	long := 1024
+	switch size {
+	case imagegen.ImageSize2K:
+		long = 2048
+	case imagegen.ImageSize4K:
+		long = 4096
+	}
+	if maxDim > 0 && long > maxDim {
+		long = maxDim
+	}
+
+	w, h := parseAspectRatio(aspect)
+	if w >= h {
+		return long, max(1, long*h/w)
+	}
+	return max(1, long*w/h), long
+}
+
+// parseAspectRatio parses "W:H", defaulting to 1:1 for auto or invalid values.
+func parseAspectRatio(aspect imagegen.AspectRatio) (int, int) {
+	ws, hs, ok := strings.Cut(string(aspect), ":")
+	if !ok {
+		return 1, 1
+	}
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 1, 1
+	}
+	return w, h
+}
+
+// renderImage draws a gradient derived from the digest with the digest's
+// leading hex digits printed across the middle, then encodes it.
+func renderImage(digest [sha256.Size]byte, index, width, height int, mimeType string) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	seed := binary.BigEndian.Uint32(digest[:4]) + uint32(index)*0x9e3779b9
+	from := color.RGBA{R: byte(seed), G: byte(seed >> 8), B: byte(seed >> 16), A: 255}
+	to := color.RGBA{R: 255 - from.R, G: 255 - from.G, B: 255 - from.B, A: 255}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := (x + y) * 255 / max(1, width+height-2)
+			off := img.PixOffset(x, y)
+			img.Pix[off+0] = lerp(from.R, to.R, t)
+			img.Pix[off+1] = lerp(from.G, to.G, t)
+			img.Pix[off+2] = lerp(from.B, to.B, t)
+			img.Pix[off+3] = 255
+		}
+	}
+
+	label := fmt.Sprintf("%x", digest[:8])
+	if index > 0 {
+		label = fmt.Sprintf("%x%x", digest[:7], index)
+	}
+	drawText(img, label)
+
+	var buf bytes.Buffer
+	var err error
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func lerp(a, b byte, t int) byte {
+	return byte((int(a)*(255-t) + int(b)*t) / 255)
+}
+
+// glyphs is a 3x5 bitmap font for hex digits; each row is 3 bits wide.
+var glyphs = map[rune][5]byte{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7}, '3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1}, '5': {7, 4, 7, 1, 7}, '6': {7, 4, 7, 5, 7}, '7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7}, '9': {7, 5, 7, 1, 7}, 'a': {7, 5, 7, 5, 5}, 'b': {6, 5, 6, 5, 6},
+	'c': {7, 4, 4, 4, 7}, 'd': {6, 5, 5, 5, 6}, 'e': {7, 4, 7, 4, 7}, 'f': {7, 4, 7, 4, 4},
+}
+
+// drawText renders text centered in white on a black band, scaled to the image width.
+func drawText(img *image.RGBA, text string) {
+	bounds := img.Bounds()
+	cols := len(text)*4 + 1 // 3px glyph + 1px spacing, plus margin
+	scale := bounds.Dx() / (cols + 2)
+	if scale < 1 {
+		return
+	}
+
+	textW, textH := cols*scale, 7*scale
+	x0 := (bounds.Dx() - textW) / 2
+	y0 := (bounds.Dy() - textH) / 2
+	if y0 < 0 {
+		return
+	}
+
+	fill(img, image.Rect(x0, y0, x0+textW, y0+textH), color.RGBA{A: 255})
+
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	for i, r := range text {
+		glyph := glyphs[r]
+		for row := 0; row < 5; row++ {
+			for col := 0; col < 3; col++ {
+				if glyph[row]&(4>>col) == 0 {
+					continue
+				}
+				px := x0 + (1+i*4+col)*scale
+				py := y0 + (1+row)*scale
+				fill(img, image.Rect(px, py, px+scale, py+scale), white)
+			}
+		}
+	}
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
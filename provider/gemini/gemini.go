@@ -12,6 +12,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -57,6 +58,10 @@ func New(ctx context.Context, config *imagegen.ProviderConfig) (*GeminiGenerator
 	}
 	// If APIKey is empty, the SDK will try GOOGLE_API_KEY or GEMINI_API_KEY env vars
 
+	if config.BaseURL != "" {
+		clientCfg.HTTPOptions.BaseURL = config.BaseURL
+	}
+
 	client, err := genai.NewClient(ctx, clientCfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
@@ -116,10 +121,7 @@ func (g *GeminiGenerator) Generate(ctx context.Context, prompt string, config *i
 
 	result, err := g.client.Models.GenerateContent(ctx, modelName, contents, genConfig)
 	if err != nil {
-		if rlErr := checkRateLimitError(err, modelName); rlErr != nil {
-			return nil, rlErr
-		}
-		return nil, fmt.Errorf("generation failed: %w", err)
+		return nil, fmt.Errorf("generation failed: %w", checkAPIError(err, modelName))
 	}
 
 	return g.parseResult(result)
@@ -159,10 +161,7 @@ func (g *GeminiGenerator) Edit(ctx context.Context, image imagegen.InputImage, i
 
 	result, err := g.client.Models.GenerateContent(ctx, modelName, contents, genConfig)
 	if err != nil {
-		if rlErr := checkRateLimitError(err, modelName); rlErr != nil {
-			return nil, rlErr
-		}
-		return nil, fmt.Errorf("edit failed: %w", err)
+		return nil, fmt.Errorf("edit failed: %w", checkAPIError(err, modelName))
 	}
 
 	return g.parseResult(result)
@@ -203,10 +202,7 @@ func (g *GeminiGenerator) EditMultiple(ctx context.Context, images []imagegen.In
 
 	result, err := g.client.Models.GenerateContent(ctx, modelName, contents, genConfig)
 	if err != nil {
-		if rlErr := checkRateLimitError(err, modelName); rlErr != nil {
-			return nil, rlErr
-		}
-		return nil, fmt.Errorf("multi-image edit failed: %w", err)
+		return nil, fmt.Errorf("multi-image edit failed: %w", checkAPIError(err, modelName))
 	}
 
 	return g.parseResult(result)
@@ -236,13 +232,20 @@ func (g *GeminiGenerator) StartConversation() imagegen.Conversation {
 }
 
 // resolveModel determines which API model name to use.
+// Public model names (e.g., "nano-banana-2") are mapped to their API names.
 // Falls back to the first model (default) if none specified.
 func (g *GeminiGenerator) resolveModel(config *imagegen.GenerateConfig) string {
+	models := g.Models()
+
 	if config != nil && config.Model != "" {
+		for _, info := range models {
+			if string(config.Model) == info.Name {
+				return info.APIModelName
+			}
+		}
 		return string(config.Model)
 	}
 	// Default to first model in the list
-	models := g.Models()
 	if len(models) == 0 {
 		return APIModelNanoBanana2
 	}
@@ -306,6 +309,11 @@ func convertSafetySettings(settings []imagegen.SafetySetting) []*genai.SafetySet
 
 // parseResult converts Gemini response to our result type.
 func (g *GeminiGenerator) parseResult(result *genai.GenerateContentResponse) (*imagegen.GenerateResult, error) {
+	if result != nil {
+		if err := checkBlocked(result); err != nil {
+			return nil, err
+		}
+	}
 	if result == nil || len(result.Candidates) == 0 {
 		return nil, errors.New("empty response from model")
 	}
@@ -374,10 +382,19 @@ type GeminiConversation struct {
 }
 
 // Send sends a message and receives a response.
+// A turn needs a prompt, images, or both. Failed turns leave the history unchanged.
 func (c *GeminiConversation) Send(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if len(images) == 0 {
+		if err := imagegen.ValidatePrompt(prompt); err != nil {
+			return nil, err
+		}
+	} else if err := imagegen.ValidateInputImages(images); err != nil {
+		return nil, err
+	}
+
 	if config == nil {
 		config = imagegen.DefaultConfig()
 	}
@@ -398,39 +415,22 @@ func (c *GeminiConversation) Send(ctx context.Context, prompt string, images []i
 		parts = append(parts, &genai.Part{Text: prompt})
 	}
 
-	// Add user message to history
 	userContent := &genai.Content{
 		Role:  "user",
 		Parts: parts,
 	}
-	c.contents = append(c.contents, userContent)
-
-	// Record in our history format
-	userTurn := imagegen.ConversationTurn{
-		Role: "user",
-		Text: prompt,
-	}
-	for _, img := range images {
-		userTurn.Images = append(userTurn.Images, imagegen.GeneratedImage{
-			Data:     img.Data,
-			MIMEType: img.MIMEType,
-		})
-	}
-	c.history = append(c.history, userTurn)
+	contents := append(c.contents[:len(c.contents):len(c.contents)], userContent)
 
 	// Generate response
 	genConfig := c.generator.buildGenerateContentConfig(config, nil)
 	result, err := c.generator.client.Models.GenerateContent(
 		ctx,
 		modelName,
-		c.contents,
+		contents,
 		genConfig,
 	)
 	if err != nil {
-		if rlErr := checkRateLimitError(err, modelName); rlErr != nil {
-			return nil, rlErr
-		}
-		return nil, fmt.Errorf("conversation send failed: %w", err)
+		return nil, fmt.Errorf("conversation send failed: %w", checkAPIError(err, modelName))
 	}
 
 	genResult, err := c.generator.parseResult(result)
@@ -438,11 +438,25 @@ func (c *GeminiConversation) Send(ctx context.Context, prompt string, images []i
 		return nil, err
 	}
 
-	// Add model response to history
+	// Add user message and model response to history
+	c.contents = contents
 	if len(result.Candidates) > 0 && result.Candidates[0].Content != nil {
 		c.contents = append(c.contents, result.Candidates[0].Content)
 	}
 
+	// Record in our history format
+	userTurn := imagegen.ConversationTurn{
+		Role: "user",
+		Text: prompt,
+	}
+	for _, img := range images {
+		userTurn.Images = append(userTurn.Images, imagegen.GeneratedImage{
+			Data:     img.Data,
+			MIMEType: img.MIMEType,
+		})
+	}
+	c.history = append(c.history, userTurn)
+
 	modelTurn := imagegen.ConversationTurn{
 		Role:   "model",
 		Text:   genResult.Text,
@@ -493,9 +507,10 @@ func ImageFromBase64(b64 string, mimeType string) (imagegen.InputImage, error) {
 	}, nil
 }
 
-// checkRateLimitError checks if an error from the Gemini API is a rate limit error.
-// If so, it wraps it in a RateLimitError for standardized handling; otherwise returns the original error.
-func checkRateLimitError(err error, model string) error {
+// checkAPIError converts errors from the Gemini API to standardized types:
+// 429/RESOURCE_EXHAUSTED becomes a RateLimitError and other API errors become a
+// ProviderError. Other errors are returned unchanged.
+func checkAPIError(err error, model string) error {
 	if err == nil {
 		return nil
 	}
@@ -505,14 +520,44 @@ func checkRateLimitError(err error, model string) error {
 		return err
 	}
 
-	if apiErr.Code != 429 && apiErr.Status != "RESOURCE_EXHAUSTED" {
-		return err
+	if apiErr.Code == 429 || apiErr.Status == "RESOURCE_EXHAUSTED" {
+		return &imagegen.RateLimitError{
+			RetryAfter: 60 * time.Second, // Default; API doesn't reliably provide Retry-After
+			LimitType:  imagegen.LimitTypeRequests,
+			Model:      model,
+			Err:        err,
+		}
 	}
 
-	return &imagegen.RateLimitError{
-		RetryAfter: 60 * time.Second, // Default; API doesn't reliably provide Retry-After
-		LimitType:  "requests",
+	return &imagegen.ProviderError{
+		Provider:   imagegen.ProviderGeminiAPI,
 		Model:      model,
+		StatusCode: apiErr.Code,
 		Err:        err,
 	}
 }
+
+// checkBlocked returns ErrContentBlocked if the prompt was blocked, or if no
+// images were returned because of a safety finish reason.
+func checkBlocked(result *genai.GenerateContentResponse) error {
+	if fb := result.PromptFeedback; fb != nil && fb.BlockReason != "" && fb.BlockReason != genai.BlockedReasonUnspecified {
+		return fmt.Errorf("%w: prompt blocked: %s", imagegen.ErrContentBlocked, fb.BlockReason)
+	}
+
+	for _, candidate := range result.Candidates {
+		if candidate.Content != nil && slices.ContainsFunc(candidate.Content.Parts, func(p *genai.Part) bool {
+			return p.InlineData != nil
+		}) {
+			continue
+		}
+
+		switch candidate.FinishReason {
+		case genai.FinishReasonSafety, genai.FinishReasonImageSafety,
+			genai.FinishReasonProhibitedContent, genai.FinishReasonImageProhibitedContent,
+			genai.FinishReasonBlocklist, genai.FinishReasonSPII:
+			return fmt.Errorf("%w: finish reason %s", imagegen.ErrContentBlocked, candidate.FinishReason)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package gemini
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+)
+
+// standIn is an httptest stand-in for the Gemini generateContent endpoint.
+type standIn struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	models   []string
+	contents []int // number of contents per request
+	status   int   // if non-zero, respond with this error status
+	blocked  bool  // if set, respond with a blocked prompt
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *standIn) handle(w http.ResponseWriter, r *http.Request) {
+	model, ok := strings.CutSuffix(r.URL.Path, ":generateContent")
+	if r.Method != http.MethodPost || !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req struct {
+		Contents []json.RawMessage `json:"contents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.models = append(s.models, model[strings.LastIndex(model, "/")+1:])
+	s.contents = append(s.contents, len(req.Contents))
+	status, blocked := s.status, s.blocked
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != 0 {
+		statusText := "INTERNAL"
+		if status == http.StatusTooManyRequests {
+			statusText = "RESOURCE_EXHAUSTED"
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error": map[string]any{"code": status, "message": "stand-in error", "status": statusText},
+		})
+		return
+	}
+
+	if blocked {
+		json.NewEncoder(w).Encode(map[string]any{
+			"promptFeedback": map[string]any{"blockReason": "SAFETY"},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"candidates": []any{map[string]any{
+			"content": map[string]any{
+				"role": "model",
+				"parts": []any{
+					map[string]any{"text": "Here you go."},
+					map[string]any{"inlineData": map[string]any{
+						"mimeType": "image/png",
+						"data":     base64.StdEncoding.EncodeToString(imagegentest.TinyPNG),
+					}},
+				},
+			},
+			"finishReason": "STOP",
+		}},
+		"usageMetadata": map[string]any{
+			"promptTokenCount":     12,
+			"candidatesTokenCount": 1120,
+			"totalTokenCount":      1132,
+		},
+	})
+}
+
+// requests returns the model and number of contents of each request so far.
+func (s *standIn) requests() ([]string, []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.models...), append([]int(nil), s.contents...)
+}
+
+func (s *standIn) generator(t *testing.T) *GeminiGenerator {
+	gen, err := New(context.Background(), &imagegen.ProviderConfig{
+		Provider: imagegen.ProviderGeminiAPI,
+		APIKey:   "test-key",
+		BaseURL:  s.server.URL,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return gen
+}
+
+func TestConformance(t *testing.T) {
+	imagegentest.RunConformance(t, func(t *testing.T) imagegen.ImageGenerator {
+		return newStandIn(t).generator(t)
+	})
+}
+
+func TestGenerate_ParsesResponse(t *testing.T) {
+	s := newStandIn(t)
+	gen := s.generator(t)
+
+	result, err := gen.Generate(context.Background(), "a kite", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Text != "Here you go." || len(result.Images) != 1 {
+		t.Errorf("unexpected result: text %q, %d images", result.Text, len(result.Images))
+	}
+	if u := result.UsageMetadata; u == nil || u.PromptTokens != 12 || u.CandidatesTokens != 1120 || u.ImageCount != 1 {
+		t.Errorf("unexpected usage: %+v", result.UsageMetadata)
+	}
+
+	// A nil config resolves the default public model name to its API name
+	if models, _ := s.requests(); models[0] != APIModelNanoBanana2 {
+		t.Errorf("expected request for %s, got %s", APIModelNanoBanana2, models[0])
+	}
+}
+
+func TestConversation_SendsHistory(t *testing.T) {
+	s := newStandIn(t)
+	conv := s.generator(t).StartConversation()
+	ctx := context.Background()
+
+	for _, prompt := range []string{"a kite", "make it red"} {
+		if _, err := conv.Send(ctx, prompt, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Second request carries user, model and the new user content
+	if _, contents := s.requests(); len(contents) != 2 || contents[0] != 1 || contents[1] != 3 {
+		t.Errorf("unexpected contents per request: %v", contents)
+	}
+}
+
+func TestGenerate_RateLimitError(t *testing.T) {
+	s := newStandIn(t)
+	s.mu.Lock()
+	s.status = http.StatusTooManyRequests
+	s.mu.Unlock()
+	gen := s.generator(t)
+
+	_, err := gen.Generate(context.Background(), "a kite", nil)
+	if !imagegen.IsRateLimitError(err) {
+		t.Errorf("expected RateLimitError, got %v", err)
+	}
+
+	conv := gen.StartConversation()
+	if _, err := conv.Send(context.Background(), "a kite", nil, nil); !imagegen.IsRateLimitError(err) {
+		t.Errorf("expected RateLimitError from conversation, got %v", err)
+	}
+	if len(conv.History()) != 0 {
+		t.Errorf("failed turn should not be recorded, got %d turns", len(conv.History()))
+	}
+}
+
+func TestGenerate_ProviderErrors(t *testing.T) {
+	s := newStandIn(t)
+	gen := s.generator(t)
+
+	s.mu.Lock()
+	s.status = http.StatusServiceUnavailable
+	s.mu.Unlock()
+
+	_, err := gen.Generate(context.Background(), "a kite", nil)
+	var provErr *imagegen.ProviderError
+	if !errors.As(err, &provErr) || provErr.StatusCode != http.StatusServiceUnavailable || !provErr.Temporary() {
+		t.Errorf("expected temporary ProviderError with status 503, got %v", err)
+	}
+
+	s.mu.Lock()
+	s.status = 0
+	s.blocked = true
+	s.mu.Unlock()
+
+	if _, err := gen.Generate(context.Background(), "a kite", nil); !errors.Is(err, imagegen.ErrContentBlocked) {
+		t.Errorf("expected ErrContentBlocked, got %v", err)
+	}
+}
+
+func TestNewPool_BenchesForbiddenKeys(t *testing.T) {
+	s := newStandIn(t)
+	s.status = http.StatusForbidden
+
+	pool, err := NewPool(context.Background(), &imagegen.ProviderConfig{BaseURL: s.server.URL},
+		[]string{"first-key-1234", "second-key-5678"})
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	defer pool.Close()
+
+	var providerErr *imagegen.ProviderError
+	if _, err := pool.Generate(context.Background(), "a cat", nil); !errors.As(err, &providerErr) {
+		t.Fatalf("expected ProviderError, got %v", err)
+	}
+
+	usage := pool.Usage()
+	if usage[0].Name != "key-1-...1234" || usage[1].Name != "key-2-...5678" {
+		t.Errorf("unexpected key names: %q, %q", usage[0].Name, usage[1].Name)
+	}
+	for _, u := range usage {
+		if u.Forbidden != 1 || u.BenchedUntil.IsZero() {
+			t.Errorf("expected key %s to be benched: %+v", u.Name, u)
+		}
+	}
+	if models, _ := s.requests(); len(models) != 2 {
+		t.Errorf("expected a request with each key, got %d", len(models))
+	}
+}
+
+func TestKeyName(t *testing.T) {
+	tests := []struct {
+		apiKey string
+		want   string
+	}{
+		{"AIzaSyExampleKey1234", "key-1-...1234"},
+		{"abc", "key-1"},
+		{"abcd", "key-1"},
+		{"short-12", "key-1"},
+		{"", "key-1"},
+	}
+	for _, tt := range tests {
+		if got := keyName(0, tt.apiKey); got != tt.want {
+			t.Errorf("keyName(0, %q) = %q, want %q", tt.apiKey, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/keypool"
+)
+
+// NewPool creates a keypool.Pool with a GeminiGenerator for each API key.
+// config supplies everything but the key and may be nil. In the pool's usage
+// keys are named by position and last four characters.
+//
+// Example:
+//
+//	pool, err := gemini.NewPool(ctx, nil, []string{keyA, keyB},
+//	    keypool.WithKeyLimits(imagegen.RateLimits{TokensPerMinute: 100_000, RequestsPerMinute: 10}, nil),
+//	)
+func NewPool(ctx context.Context, config *imagegen.ProviderConfig, apiKeys []string, opts ...keypool.Option) (*keypool.Pool, error) {
+	if config == nil {
+		config = &imagegen.ProviderConfig{Provider: imagegen.ProviderGeminiAPI}
+	}
+
+	keys := make([]keypool.Key, 0, len(apiKeys))
+	closeAll := func() {
+		for _, k := range keys {
+			k.Generator.Close()
+		}
+	}
+
+	for i, apiKey := range apiKeys {
+		if apiKey == "" {
+			closeAll()
+			return nil, fmt.Errorf("API key %d is empty", i+1)
+		}
+
+		keyConfig := *config
+		keyConfig.APIKey = apiKey
+		gen, err := New(ctx, &keyConfig)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		keys = append(keys, keypool.Key{Name: keyName(i, apiKey), Generator: gen})
+	}
+
+	pool, err := keypool.New(keys, opts...)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// keyName names a key by its position and last four characters. Keys too
+// short for the suffix to hide most of them are named by position alone.
+func keyName(i int, apiKey string) string {
+	if len(apiKey) <= 8 {
+		return fmt.Sprintf("key-%d", i+1)
+	}
+	return fmt.Sprintf("key-%d-...%s", i+1, apiKey[len(apiKey)-4:])
+}
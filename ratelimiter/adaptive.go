@@ -0,0 +1,233 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FeedbackLimiter is a Limiter that adjusts to the outcome of the requests it
+// admits. Each report returns the adjustment it caused, if any.
+type FeedbackLimiter interface {
+	Limiter
+
+	// ReportSuccess records that an admitted request succeeded.
+	ReportSuccess() (Adjustment, bool)
+
+	// ReportRateLimited records that the provider rejected an admitted
+	// request for exceeding its rate limit.
+	ReportRateLimited() (Adjustment, bool)
+}
+
+// Adjustment reasons.
+const (
+	ReasonRateLimited = "rate_limited"
+	ReasonSuccess     = "sustained_success"
+)
+
+// Adjustment describes a change to an adaptive limiter's capacity.
+type Adjustment struct {
+	Reason string
+
+	// Scale is the new fraction of the base limits in effect.
+	Scale float64
+
+	// TokensPerMinute and RequestsPerMinute are the new effective limits.
+	TokensPerMinute   int
+	RequestsPerMinute int
+}
+
+// AdaptiveConfig tunes an Adaptive limiter. Zero fields use the defaults.
+type AdaptiveConfig struct {
+	// DecreaseFactor multiplies the scale on a rate limit error. Default 0.5.
+	DecreaseFactor float64
+
+	// IncreaseStep is added to the scale after SuccessesPerIncrease
+	// consecutive successes. Default 0.05.
+	IncreaseStep float64
+
+	// SuccessesPerIncrease is how many consecutive successes it takes to
+	// increase the scale. Default 20.
+	SuccessesPerIncrease int
+
+	// MinScale and MaxScale bound the scale. MaxScale may exceed 1 to probe
+	// for a quota above the base limits. Defaults 0.1 and 1.
+	MinScale float64
+	MaxScale float64
+
+	// DecreaseCooldown is the minimum time between decreases, so that a burst
+	// of errors from requests already in flight counts once. Default 5s.
+	DecreaseCooldown time.Duration
+}
+
+func (c AdaptiveConfig) withDefaults() AdaptiveConfig {
+	if c.DecreaseFactor <= 0 || c.DecreaseFactor >= 1 {
+		c.DecreaseFactor = 0.5
+	}
+	if c.IncreaseStep <= 0 {
+		c.IncreaseStep = 0.05
+	}
+	if c.SuccessesPerIncrease <= 0 {
+		c.SuccessesPerIncrease = 20
+	}
+	if c.MinScale <= 0 {
+		c.MinScale = 0.1
+	}
+	if c.MaxScale <= 0 {
+		c.MaxScale = 1
+	}
+	c.MaxScale = max(c.MaxScale, c.MinScale)
+	if c.DecreaseCooldown <= 0 {
+		c.DecreaseCooldown = 5 * time.Second
+	}
+	return c
+}
+
+// Adaptive is a GCRA limiter whose capacity follows the provider's actual
+// quota using additive increase, multiplicative decrease (AIMD).
+//
+// It starts at the base limits. Each reported rate limit error multiplies the
+// effective limits by DecreaseFactor; each run of SuccessesPerIncrease
+// successes adds IncreaseStep of the base limits back, within MinScale and
+// MaxScale.
+type Adaptive struct {
+	gcra   *GCRA
+	config AdaptiveConfig
+	clock  Clock
+
+	baseTokens   int
+	baseRequests int
+
+	scale        float64
+	successes    int
+	lastDecrease time.Time
+
+	mu sync.Mutex
+}
+
+// Ensure Adaptive implements FeedbackLimiter, Refunder and WaitCounter.
+var (
+	_ FeedbackLimiter = (*Adaptive)(nil)
+	_ Refunder        = (*Adaptive)(nil)
+	_ WaitCounter     = (*Adaptive)(nil)
+)
+
+// NewAdaptive creates an Adaptive limiter with base limits of the given
+// tokens and requests per minute. A limit of zero or less disables that
+// bucket.
+func NewAdaptive(tokensPerMinute, requestsPerMinute int, config AdaptiveConfig, opts ...Option) *Adaptive {
+	o := applyOptions(opts)
+	config = config.withDefaults()
+
+	a := &Adaptive{
+		gcra:         NewGCRA(tokensPerMinute, requestsPerMinute, opts...),
+		config:       config,
+		clock:        o.clock,
+		baseTokens:   tokensPerMinute,
+		baseRequests: requestsPerMinute,
+		scale:        1,
+	}
+	if config.MaxScale < 1 {
+		a.apply(config.MaxScale)
+	}
+	return a
+}
+
+// TryConsume consumes from the current effective limits.
+func (a *Adaptive) TryConsume(numTokens int) bool {
+	return a.gcra.TryConsume(numTokens)
+}
+
+// TimeUntilAvailable returns the wait under the current effective limits.
+// It returns -1 if the request exceeds the current capacity.
+func (a *Adaptive) TimeUntilAvailable(tokens int) time.Duration {
+	return a.gcra.TimeUntilAvailable(tokens)
+}
+
+// WaitAndConsume waits until tokens are available under the current
+// effective limits (up to maxWait), then consumes them.
+func (a *Adaptive) WaitAndConsume(ctx context.Context, tokens int, maxWait time.Duration) error {
+	return a.gcra.WaitAndConsume(ctx, tokens, maxWait)
+}
+
+// Refund returns tokens and one request to the buckets.
+func (a *Adaptive) Refund(tokens int) {
+	a.gcra.Refund(tokens)
+}
+
+// AddWaiters adds delta to the count of waiters.
+func (a *Adaptive) AddWaiters(delta int) {
+	a.gcra.AddWaiters(delta)
+}
+
+// Snapshot returns the state of the buckets at their current effective
+// capacity.
+func (a *Adaptive) Snapshot() Snapshot {
+	return a.gcra.Snapshot()
+}
+
+// Scale returns the fraction of the base limits currently in effect.
+func (a *Adaptive) Scale() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.scale
+}
+
+// ReportSuccess counts a success, increasing capacity after
+// SuccessesPerIncrease in a row.
+func (a *Adaptive) ReportSuccess() (Adjustment, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.successes++
+	if a.successes < a.config.SuccessesPerIncrease || a.scale >= a.config.MaxScale {
+		return Adjustment{}, false
+	}
+
+	a.successes = 0
+	return a.apply(min(a.scale+a.config.IncreaseStep, a.config.MaxScale)), true
+}
+
+// ReportRateLimited decreases capacity, unless it already decreased within
+// DecreaseCooldown or is at MinScale.
+func (a *Adaptive) ReportRateLimited() (Adjustment, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.successes = 0
+	now := a.clock.Now()
+	if !a.lastDecrease.IsZero() && now.Sub(a.lastDecrease) < a.config.DecreaseCooldown {
+		return Adjustment{}, false
+	}
+	if a.scale <= a.config.MinScale {
+		return Adjustment{}, false
+	}
+
+	a.lastDecrease = now
+	adj := a.apply(max(a.scale*a.config.DecreaseFactor, a.config.MinScale))
+	adj.Reason = ReasonRateLimited
+	return adj, true
+}
+
+// apply sets the scale and resizes the buckets. Must be called while holding
+// a.mu.
+func (a *Adaptive) apply(scale float64) Adjustment {
+	a.scale = scale
+	adj := Adjustment{
+		Reason:            ReasonSuccess,
+		Scale:             scale,
+		TokensPerMinute:   ScaleLimit(a.baseTokens, scale),
+		RequestsPerMinute: ScaleLimit(a.baseRequests, scale),
+	}
+	a.gcra.setLimits(adj.TokensPerMinute, adj.RequestsPerMinute)
+	return adj
+}
+
+// ScaleLimit returns limit multiplied by scale. Limits that are set stay at
+// least 1, and disabled limits (zero or less) stay disabled.
+func ScaleLimit(limit int, scale float64) int {
+	if limit <= 0 {
+		return limit
+	}
+	return max(int(float64(limit)*scale), 1)
+}
@@ -0,0 +1,110 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptive_AIMD(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	a := NewAdaptive(1000, 100, AdaptiveConfig{
+		SuccessesPerIncrease: 2,
+		IncreaseStep:         0.25,
+		MinScale:             0.2,
+		DecreaseCooldown:     time.Second,
+	}, WithClock(clock))
+
+	type report int
+	const (
+		success report = iota
+		rateLimited
+	)
+
+	steps := []struct {
+		name         string
+		report       report
+		advance      time.Duration
+		wantAdjusted bool
+		wantScale    float64
+		wantRPM      int
+	}{
+		{"success at max scale", success, 0, false, 1, 100},
+		{"rate limited halves", rateLimited, 0, true, 0.5, 50},
+		{"cooldown ignores burst", rateLimited, 0, false, 0.5, 50},
+		{"first success counts", success, 0, false, 0.5, 50},
+		{"second success increases", success, 0, true, 0.75, 75},
+		{"after cooldown halves", rateLimited, time.Second, true, 0.375, 37},
+		{"halves to floor", rateLimited, time.Second, true, 0.2, 20},
+		{"floor holds", rateLimited, time.Second, false, 0.2, 20},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+
+		var adjusted bool
+		if step.report == success {
+			_, adjusted = a.ReportSuccess()
+		} else {
+			_, adjusted = a.ReportRateLimited()
+		}
+
+		if adjusted != step.wantAdjusted {
+			t.Errorf("%s: adjusted = %v, want %v", step.name, adjusted, step.wantAdjusted)
+		}
+		if got := a.Scale(); got != step.wantScale {
+			t.Errorf("%s: scale = %v, want %v", step.name, got, step.wantScale)
+		}
+		requests, _ := a.Snapshot().Bucket(BucketRequests)
+		if requests.Capacity != step.wantRPM {
+			t.Errorf("%s: requests capacity = %d, want %d", step.name, requests.Capacity, step.wantRPM)
+		}
+	}
+}
+
+func TestAdaptive_Adjustment(t *testing.T) {
+	a := NewAdaptive(1000, 100, AdaptiveConfig{}, WithClock(NewFakeClock(epoch)))
+
+	adj, ok := a.ReportRateLimited()
+	if !ok {
+		t.Fatal("expected an adjustment")
+	}
+	want := Adjustment{
+		Reason:            ReasonRateLimited,
+		Scale:             0.5,
+		TokensPerMinute:   500,
+		RequestsPerMinute: 50,
+	}
+	if adj != want {
+		t.Errorf("adjustment = %+v, want %+v", adj, want)
+	}
+
+	for range 19 {
+		if _, ok := a.ReportSuccess(); ok {
+			t.Fatal("increased before SuccessesPerIncrease successes")
+		}
+	}
+	adj, ok = a.ReportSuccess()
+	if !ok || adj.Reason != ReasonSuccess || adj.RequestsPerMinute != 55 {
+		t.Errorf("unexpected increase: %+v, %v", adj, ok)
+	}
+}
+
+func TestAdaptive_KeepsUsageOnResize(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	a := NewAdaptive(0, 100, AdaptiveConfig{}, WithClock(clock))
+
+	for range 50 {
+		if !a.TryConsume(0) {
+			t.Fatal("failed to consume within the limit")
+		}
+	}
+
+	// Half the capacity was used, so half of the new capacity is left
+	a.ReportRateLimited()
+	requests, _ := a.Snapshot().Bucket(BucketRequests)
+	if requests.Capacity != 50 || requests.Remaining != 25 {
+		t.Errorf("after decrease: capacity %d remaining %d, want 50 and 25", requests.Capacity, requests.Remaining)
+	}
+	if _, ok := a.Snapshot().Bucket(BucketTokens); ok {
+		t.Error("disabled tokens bucket should stay disabled")
+	}
+}
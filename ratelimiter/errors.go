@@ -0,0 +1,13 @@
+package ratelimiter
+
+import "errors"
+
+var (
+	// ErrExceedsCapacity is returned when a request needs more than a bucket
+	// can ever hold, so waiting would never help.
+	ErrExceedsCapacity = errors.New("request exceeds limiter capacity")
+
+	// ErrMaxWaitExceeded is returned when the wait for capacity would exceed
+	// the caller's maximum.
+	ErrMaxWaitExceeded = errors.New("rate limit wait exceeds max wait")
+)
@@ -0,0 +1,270 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GCRA is a Limiter with continuous refill, using the generic cell rate
+// algorithm.
+//
+// Unlike RateLimiter, capacity is regained smoothly (a bucket of 60 per minute
+// regains one unit per second) rather than all at once at the end of each
+// minute, so there are no bursts of twice the limit across a minute boundary.
+// TimeUntilAvailable is exact: a caller that waits that long can consume.
+type GCRA struct {
+	tokens   gcraBucket
+	requests gcraBucket
+	daily    gcraBucket
+	clock    Clock
+
+	// waiters counts goroutines blocked in WaitAndConsume
+	waiters atomic.Int64
+
+	mu sync.Mutex
+}
+
+// Ensure GCRA implements Limiter, Refunder and WaitCounter.
+var (
+	_ Limiter     = (*GCRA)(nil)
+	_ Refunder    = (*GCRA)(nil)
+	_ WaitCounter = (*GCRA)(nil)
+)
+
+// NewGCRA creates a GCRA limiter with the given tokens and requests per
+// minute. A limit of zero or less disables that bucket. Use WithDailyTokens
+// to add a daily token limit.
+func NewGCRA(tokensPerMinute, requestsPerMinute int, opts ...Option) *GCRA {
+	o := applyOptions(opts)
+	return &GCRA{
+		tokens:   newGCRABucket(tokensPerMinute, time.Minute),
+		requests: newGCRABucket(requestsPerMinute, time.Minute),
+		daily:    newGCRABucket(o.dailyTokens, 24*time.Hour),
+		clock:    o.clock,
+	}
+}
+
+// TryConsume consumes tokens and one request if both buckets have capacity.
+func (g *GCRA) TryConsume(numTokens int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	if g.tokens.wait(now, numTokens) != 0 || g.requests.wait(now, 1) != 0 || g.daily.wait(now, numTokens) != 0 {
+		return false
+	}
+
+	g.tokens.consume(now, numTokens)
+	g.requests.consume(now, 1)
+	g.daily.consume(now, numTokens)
+	return true
+}
+
+// Refund returns tokens and one request to the buckets.
+func (g *GCRA) Refund(tokens int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	g.tokens.refund(now, tokens)
+	g.requests.refund(now, 1)
+	g.daily.refund(now, tokens)
+}
+
+// TimeUntilAvailable returns how long until tokens and one request could be
+// consumed. It returns -1 if the request exceeds a bucket's capacity.
+func (g *GCRA) TimeUntilAvailable(tokens int) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	tokenWait := g.tokens.wait(now, tokens)
+	requestWait := g.requests.wait(now, 1)
+	dailyWait := g.daily.wait(now, tokens)
+	if tokenWait < 0 || requestWait < 0 || dailyWait < 0 {
+		return -1
+	}
+	return max(tokenWait, requestWait, dailyWait)
+}
+
+// WaitAndConsume waits until tokens are available (up to maxWait), then
+// consumes them. If maxWait is 0, there is no limit on how long to wait.
+func (g *GCRA) WaitAndConsume(ctx context.Context, tokens int, maxWait time.Duration) error {
+	var deadline time.Time
+	if maxWait > 0 {
+		deadline = g.clock.Now().Add(maxWait)
+	}
+
+	for {
+		if g.TryConsume(tokens) {
+			return nil
+		}
+
+		wait := g.TimeUntilAvailable(tokens)
+		if wait < 0 {
+			return fmt.Errorf("%w: %d tokens", ErrExceedsCapacity, tokens)
+		}
+		if !deadline.IsZero() && g.clock.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("%w: need %v, max %v", ErrMaxWaitExceeded, wait, maxWait)
+		}
+
+		if err := g.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// AddWaiters adds delta to the count of waiters.
+func (g *GCRA) AddWaiters(delta int) {
+	g.waiters.Add(int64(delta))
+}
+
+// sleep blocks for d or until ctx is done.
+func (g *GCRA) sleep(ctx context.Context, d time.Duration) error {
+	g.waiters.Add(1)
+	defer g.waiters.Add(-1)
+
+	timer := g.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// Snapshot returns the state of the token, request and daily buckets.
+// Disabled buckets are omitted.
+func (g *GCRA) Snapshot() Snapshot {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	s := Snapshot{Waiters: int(g.waiters.Load())}
+	if g.tokens.enabled() {
+		s.Buckets = append(s.Buckets, g.tokens.snapshot(BucketTokens, now))
+	}
+	if g.requests.enabled() {
+		s.Buckets = append(s.Buckets, g.requests.snapshot(BucketRequests, now))
+	}
+	if g.daily.enabled() {
+		s.Buckets = append(s.Buckets, g.daily.snapshot(BucketDailyTokens, now))
+	}
+	return s
+}
+
+// setLimits changes the tokens and requests per minute. Usage so far is kept
+// as time until the bucket is full again, so it counts against the new limits
+// in proportion.
+func (g *GCRA) setLimits(tokensPerMinute, requestsPerMinute int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.tokens.resize(tokensPerMinute, time.Minute)
+	g.requests.resize(requestsPerMinute, time.Minute)
+}
+
+// gcraBucket tracks one limit as a theoretical arrival time (tat): the time
+// at which the bucket would be full again. Each unit consumed pushes tat out
+// by interval; a request is allowed if it would not push tat more than burst
+// past now.
+type gcraBucket struct {
+	limit    int
+	interval time.Duration // time to regain one unit
+	burst    time.Duration // limit * interval
+	tat      time.Time
+}
+
+func newGCRABucket(limit int, period time.Duration) gcraBucket {
+	if limit <= 0 {
+		return gcraBucket{}
+	}
+
+	interval := max(period/time.Duration(limit), 1)
+	return gcraBucket{
+		limit:    limit,
+		interval: interval,
+		burst:    interval * time.Duration(limit),
+	}
+}
+
+// resize changes the limit, keeping tat. Disabled buckets stay disabled.
+func (b *gcraBucket) resize(limit int, period time.Duration) {
+	if !b.enabled() || limit <= 0 {
+		return
+	}
+
+	tat := b.tat
+	*b = newGCRABucket(limit, period)
+	b.tat = tat
+}
+
+func (b *gcraBucket) enabled() bool {
+	return b.limit > 0
+}
+
+// wait returns how long until n units could be consumed at now, or -1 if n
+// exceeds the limit.
+func (b *gcraBucket) wait(now time.Time, n int) time.Duration {
+	if !b.enabled() {
+		return 0
+	}
+	if n > b.limit {
+		return -1
+	}
+
+	tat := b.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(b.interval * time.Duration(n))
+
+	return max(newTAT.Sub(now)-b.burst, 0)
+}
+
+// consume records n units consumed at now. Callers must check wait first.
+func (b *gcraBucket) consume(now time.Time, n int) {
+	if !b.enabled() {
+		return
+	}
+
+	if b.tat.Before(now) {
+		b.tat = now
+	}
+	b.tat = b.tat.Add(b.interval * time.Duration(n))
+}
+
+// refund returns n units consumed before now, without overfilling the bucket.
+func (b *gcraBucket) refund(now time.Time, n int) {
+	if !b.enabled() || !b.tat.After(now) {
+		return
+	}
+
+	b.tat = b.tat.Add(-b.interval * time.Duration(n))
+	if b.tat.Before(now) {
+		b.tat = now
+	}
+}
+
+// snapshot returns the bucket's state at now.
+func (b *gcraBucket) snapshot(name string, now time.Time) BucketSnapshot {
+	used := max(b.tat.Sub(now), 0)
+	remaining := min(int((b.burst-used)/b.interval), b.limit)
+
+	s := BucketSnapshot{
+		Name:      name,
+		Capacity:  b.limit,
+		Remaining: remaining,
+	}
+	if remaining < b.limit {
+		// The next unit is regained once used drops to the start of the
+		// next slot
+		s.NextRefill = now.Add(used - (b.burst - time.Duration(remaining+1)*b.interval))
+	}
+	return s
+}
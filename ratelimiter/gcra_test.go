@@ -0,0 +1,159 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGCRA_ContinuousRefill(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	g := NewGCRA(60, 100, WithClock(clock)) // 1 token per second
+
+	if !g.TryConsume(60) {
+		t.Fatal("should consume a full bucket")
+	}
+	if g.TryConsume(1) {
+		t.Error("should not consume from an empty bucket")
+	}
+
+	// Half the bucket is back after half a minute
+	clock.Advance(30 * time.Second)
+	if !g.TryConsume(30) {
+		t.Error("should consume tokens regained after 30s")
+	}
+	if g.TryConsume(1) {
+		t.Error("should not consume more than was regained")
+	}
+}
+
+func TestGCRA_NoBoundaryBurst(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	g := NewGCRA(60, 1000, WithClock(clock))
+
+	// Consume steadily until the limiter refuses, across a minute boundary
+	consumed := 0
+	for range 120 {
+		for g.TryConsume(1) {
+			consumed++
+		}
+		clock.Advance(time.Second)
+	}
+
+	// 60 up front plus one per second for 119 seconds
+	if want := 60 + 119; consumed != want {
+		t.Errorf("consumed %d tokens in 2 minutes, want %d", consumed, want)
+	}
+}
+
+func TestGCRA_WaitEstimateAgrees(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	g := NewGCRA(600, 1000, WithClock(clock)) // 10 tokens per second
+	g.TryConsume(600)
+
+	wait := g.TimeUntilAvailable(50)
+	if wait != 5*time.Second {
+		t.Fatalf("TimeUntilAvailable(50) = %v, want 5s", wait)
+	}
+
+	clock.Advance(wait - time.Nanosecond)
+	if g.TryConsume(50) {
+		t.Error("should not consume before the estimated wait")
+	}
+	clock.Advance(time.Nanosecond)
+	if !g.TryConsume(50) {
+		t.Error("should consume exactly at the estimated wait")
+	}
+}
+
+func TestGCRA_WaitAndConsume(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	g := NewGCRA(60, 1000, WithClock(clock))
+	g.TryConsume(60)
+
+	done := make(chan error)
+	go func() {
+		done <- g.WaitAndConsume(context.Background(), 10, 0)
+	}()
+
+	clock.BlockUntil(1)
+	if got := g.Snapshot().Waiters; got != 1 {
+		t.Errorf("expected 1 waiter, got %d", got)
+	}
+
+	clock.Advance(10 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitAndConsume: %v", err)
+	}
+	if got := g.Snapshot().Waiters; got != 0 {
+		t.Errorf("expected 0 waiters, got %d", got)
+	}
+}
+
+func TestGCRA_WaitAndConsume_Errors(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	g := NewGCRA(60, 1000, WithClock(clock))
+	g.TryConsume(60)
+
+	if err := g.WaitAndConsume(context.Background(), 61, 0); !errors.Is(err, ErrExceedsCapacity) {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+	if err := g.WaitAndConsume(context.Background(), 10, 5*time.Second); !errors.Is(err, ErrMaxWaitExceeded) {
+		t.Errorf("expected ErrMaxWaitExceeded, got %v", err)
+	}
+	if g.TimeUntilAvailable(61) >= 0 {
+		t.Error("expected a negative wait for a request over capacity")
+	}
+}
+
+func TestGCRA_Snapshot(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	g := NewGCRA(60, 0, WithClock(clock))
+
+	snapshot := g.Snapshot()
+	if _, ok := snapshot.Bucket(BucketRequests); ok {
+		t.Error("disabled requests bucket should be omitted")
+	}
+	tokens, ok := snapshot.Bucket(BucketTokens)
+	if !ok || tokens.Remaining != 60 || !tokens.NextRefill.IsZero() {
+		t.Fatalf("unexpected full bucket snapshot: %+v", tokens)
+	}
+
+	g.TryConsume(10)
+	clock.Advance(2500 * time.Millisecond)
+
+	tokens, _ = g.Snapshot().Bucket(BucketTokens)
+	if tokens.Remaining != 52 {
+		t.Errorf("remaining = %d, want 52", tokens.Remaining)
+	}
+	if want := clock.Now().Add(500 * time.Millisecond); !tokens.NextRefill.Equal(want) {
+		t.Errorf("next refill = %v, want %v", tokens.NextRefill, want)
+	}
+}
+
+func TestGCRA_DailyTokens(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	// 240 tokens a day refill at 10 an hour
+	g := NewGCRA(100, 0, WithClock(clock), WithDailyTokens(240))
+
+	for i := range 2 {
+		if !g.TryConsume(100) {
+			t.Fatalf("request %d: expected success", i)
+		}
+		clock.Advance(time.Minute)
+	}
+	if !g.TryConsume(40) {
+		t.Fatal("expected the rest of the daily budget to be available")
+	}
+
+	if g.TryConsume(10) {
+		t.Error("expected the daily limit to refuse the request")
+	}
+	if wait := g.TimeUntilAvailable(10); wait < 50*time.Minute || wait > time.Hour {
+		t.Errorf("wait = %v, want about an hour", wait)
+	}
+	if _, ok := g.Snapshot().Bucket(BucketDailyTokens); !ok {
+		t.Error("expected the daily bucket in the snapshot")
+	}
+}
@@ -0,0 +1,303 @@
+package ratelimiter
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Refunder is implemented by limiters that can return consumed capacity.
+// Hierarchy uses it to undo a partial consumption.
+type Refunder interface {
+	// Refund returns tokens and one request to the limiter.
+	Refund(tokens int)
+}
+
+// PriorityRefunder is a Refunder whose capacity depends on the caller's
+// priority, so a refund must name the priority it was consumed with.
+type PriorityRefunder interface {
+	Refunder
+
+	// RefundPriority is Refund on behalf of a caller with priority.
+	RefundPriority(tokens, priority int)
+}
+
+// RefundWithPriority calls l.RefundPriority if l is a PriorityRefunder, and
+// l.Refund if it is a Refunder. It reports false if l cannot refund.
+func RefundWithPriority(l Limiter, tokens, priority int) bool {
+	switch r := l.(type) {
+	case PriorityRefunder:
+		r.RefundPriority(tokens, priority)
+	case Refunder:
+		r.Refund(tokens)
+	default:
+		return false
+	}
+	return true
+}
+
+// Level is one limiter in a Hierarchy, named by its scope (e.g. "model" or
+// "provider").
+type Level struct {
+	Scope   string
+	Limiter Limiter
+}
+
+// LevelError is returned by Hierarchy.WaitAndConsume when a level's limit
+// cannot be met. It unwraps to the underlying error, such as
+// ErrMaxWaitExceeded.
+type LevelError struct {
+	Scope string
+	Err   error
+}
+
+func (e *LevelError) Error() string {
+	return fmt.Sprintf("%s rate limit: %v", e.Scope, e.Err)
+}
+
+func (e *LevelError) Unwrap() error {
+	return e.Err
+}
+
+// Hierarchy is a Limiter that admits a request only if every level does, and
+// consumes from all of them or none.
+//
+// Levels are checked before any is consumed. If a level still refuses after
+// others consumed (because another caller got there first), those levels are
+// refunded. Every limiter in this package and in ratelimiter/redis is a
+// Refunder; levels that are not are consumed after all the others, so a
+// Hierarchy with at most one of them never loses capacity.
+type Hierarchy struct {
+	levels []Level
+	clock  Clock
+
+	// consumeOrder is levels with Refunders first
+	consumeOrder []Level
+
+	// waiters counts goroutines blocked in WaitAndConsume
+	waiters atomic.Int64
+}
+
+// Ensure Hierarchy implements PriorityLimiter and WaitCounter.
+var (
+	_ PriorityLimiter = (*Hierarchy)(nil)
+	_ WaitCounter     = (*Hierarchy)(nil)
+)
+
+// NewHierarchy creates a Hierarchy of levels, consumed in order.
+func NewHierarchy(levels []Level, opts ...Option) *Hierarchy {
+	o := applyOptions(opts)
+
+	consumeOrder := slices.Clone(levels)
+	slices.SortStableFunc(consumeOrder, func(a, b Level) int {
+		return cmp.Compare(refundRank(a.Limiter), refundRank(b.Limiter))
+	})
+
+	return &Hierarchy{
+		levels:       levels,
+		clock:        o.clock,
+		consumeOrder: consumeOrder,
+	}
+}
+
+// refundRank orders limiters that can refund before those that cannot.
+func refundRank(l Limiter) int {
+	if _, ok := l.(Refunder); ok {
+		return 0
+	}
+	return 1
+}
+
+// TryConsume consumes from every level or none.
+func (h *Hierarchy) TryConsume(numTokens int) bool {
+	_, ok := h.Consume(numTokens, 0)
+	return ok
+}
+
+// TryConsumePriority consumes from every level or none on behalf of a
+// caller with priority.
+func (h *Hierarchy) TryConsumePriority(numTokens, priority int) bool {
+	_, ok := h.Consume(numTokens, priority)
+	return ok
+}
+
+// Consume consumes from every level or none on behalf of a caller with
+// priority. If a level refuses, it returns that level's scope.
+func (h *Hierarchy) Consume(numTokens, priority int) (refusedBy string, ok bool) {
+	for _, level := range h.levels {
+		if TimeUntilAvailableWithPriority(level.Limiter, numTokens, priority) != 0 {
+			return level.Scope, false
+		}
+	}
+
+	for i, level := range h.consumeOrder {
+		if TryConsumeWithPriority(level.Limiter, numTokens, priority) {
+			continue
+		}
+		for _, consumed := range h.consumeOrder[:i] {
+			RefundWithPriority(consumed.Limiter, numTokens, priority)
+		}
+		return level.Scope, false
+	}
+
+	return "", true
+}
+
+// TimeUntilAvailable returns the longest wait of any level, or -1 if the
+// request exceeds a level's capacity.
+func (h *Hierarchy) TimeUntilAvailable(tokens int) time.Duration {
+	wait, _ := h.Wait(tokens, 0)
+	return wait
+}
+
+// TimeUntilAvailablePriority is TimeUntilAvailable on behalf of a caller
+// with priority.
+func (h *Hierarchy) TimeUntilAvailablePriority(tokens, priority int) time.Duration {
+	wait, _ := h.Wait(tokens, priority)
+	return wait
+}
+
+// Wait returns the longest wait of any level for a caller with priority, and
+// the scope of the level that imposes it. The wait is -1 if the request
+// exceeds that level's capacity.
+func (h *Hierarchy) Wait(tokens, priority int) (time.Duration, string) {
+	var (
+		longest time.Duration
+		scope   string
+	)
+	for _, level := range h.levels {
+		wait := TimeUntilAvailableWithPriority(level.Limiter, tokens, priority)
+		if wait < 0 {
+			return -1, level.Scope
+		}
+		if wait > longest {
+			longest, scope = wait, level.Scope
+		}
+	}
+	return longest, scope
+}
+
+// WaitAndConsume waits until every level has capacity (up to maxWait), then
+// consumes from all of them. If maxWait is 0, there is no limit on how long
+// to wait. Priority is taken from ctx; see WithPriority. Errors from a level
+// are returned as a *LevelError. While waiting, the caller is counted in the
+// Snapshot.Waiters of every level that is a WaitCounter.
+//
+// If a level is a Queued limiter, callers wait in its queue and are served
+// in its order. Any further Queued levels only refuse while their own queues
+// are non-empty.
+func (h *Hierarchy) WaitAndConsume(ctx context.Context, tokens int, maxWait time.Duration) error {
+	for i, level := range h.levels {
+		if q, ok := level.Limiter.(*Queued); ok {
+			return h.waitInQueue(ctx, i, q, tokens, maxWait)
+		}
+	}
+
+	priority := PriorityFromContext(ctx)
+
+	var deadline time.Time
+	if maxWait > 0 {
+		deadline = h.clock.Now().Add(maxWait)
+	}
+
+	for {
+		refusedBy, ok := h.Consume(tokens, priority)
+		if ok {
+			return nil
+		}
+
+		wait, scope := h.Wait(tokens, priority)
+		if scope == "" {
+			scope = refusedBy
+		}
+		if wait < 0 {
+			return &LevelError{Scope: scope, Err: fmt.Errorf("%w: %d tokens", ErrExceedsCapacity, tokens)}
+		}
+		if wait == 0 {
+			// A level's estimate can be optimistic
+			wait = minRetryInterval
+		}
+		if !deadline.IsZero() && h.clock.Now().Add(wait).After(deadline) {
+			return &LevelError{Scope: scope, Err: fmt.Errorf("%w: need %v, max %v", ErrMaxWaitExceeded, wait, maxWait)}
+		}
+
+		if err := h.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// waitInQueue implements WaitAndConsume when the level at index i is q: the
+// caller waits in q's queue and, at its head, consumes from q's inner limiter
+// and every other level together. q counts the caller in its queue length;
+// the other levels count it as a waiter.
+func (h *Hierarchy) waitInQueue(ctx context.Context, i int, q *Queued, tokens int, maxWait time.Duration) error {
+	levels := slices.Clone(h.levels)
+	levels[i].Limiter = q.inner
+
+	others := slices.Delete(slices.Clone(h.levels), i, i+1)
+	h.addWaiters(others, 1)
+	defer h.addWaiters(others, -1)
+	via := NewHierarchy(levels, WithClock(h.clock))
+
+	err := q.waitAndConsume(ctx, tokens, maxWait, via)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	scope := h.levels[i].Scope
+	if _, s := via.Wait(tokens, PriorityFromContext(ctx)); s != "" {
+		scope = s
+	}
+	return &LevelError{Scope: scope, Err: err}
+}
+
+// AddWaiters adds delta to the count of waiters, and to that of every level
+// that is a WaitCounter.
+func (h *Hierarchy) AddWaiters(delta int) {
+	h.addWaiters(h.levels, delta)
+}
+
+// addWaiters adds delta to the count of waiters, and to that of each of
+// levels that is a WaitCounter.
+func (h *Hierarchy) addWaiters(levels []Level, delta int) {
+	h.waiters.Add(int64(delta))
+	for _, level := range levels {
+		if wc, ok := level.Limiter.(WaitCounter); ok {
+			wc.AddWaiters(delta)
+		}
+	}
+}
+
+// sleep blocks for d or until ctx is done, counted as a waiter on every
+// level.
+func (h *Hierarchy) sleep(ctx context.Context, d time.Duration) error {
+	h.AddWaiters(1)
+	defer h.AddWaiters(-1)
+
+	timer := h.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// Snapshot returns every level's buckets, with names prefixed by the level's
+// scope and a dot (e.g. "provider.requests").
+func (h *Hierarchy) Snapshot() Snapshot {
+	s := Snapshot{Waiters: int(h.waiters.Load())}
+	for _, level := range h.levels {
+		for _, b := range level.Limiter.Snapshot().Buckets {
+			b.Name = level.Scope + "." + b.Name
+			s.Buckets = append(s.Buckets, b)
+		}
+	}
+	return s
+}
@@ -0,0 +1,254 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// racyLimiter reports capacity but refuses to consume, as a limiter does
+// when another caller takes the capacity between the check and the consume.
+type racyLimiter struct {
+	*GCRA
+}
+
+func (racyLimiter) TryConsume(int) bool { return false }
+
+func TestHierarchy_Consume(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	model := NewGCRA(100, 10, WithClock(clock))
+	provider := NewGCRA(0, 2, WithClock(clock))
+	h := NewHierarchy([]Level{
+		{Scope: "model", Limiter: model},
+		{Scope: "provider", Limiter: provider},
+	}, WithClock(clock))
+
+	steps := []struct {
+		name          string
+		tokens        int
+		wantRefusedBy string
+		wantOK        bool
+	}{
+		{"both have capacity", 10, "", true},
+		{"model refuses", 95, "model", false},
+		{"both have capacity again", 10, "", true},
+		{"provider refuses", 10, "provider", false},
+	}
+	for _, step := range steps {
+		refusedBy, ok := h.Consume(step.tokens, 0)
+		if refusedBy != step.wantRefusedBy || ok != step.wantOK {
+			t.Errorf("%s: Consume(%d) = %q, %v, want %q, %v",
+				step.name, step.tokens, refusedBy, ok, step.wantRefusedBy, step.wantOK)
+		}
+	}
+
+	// Refusals consumed nothing from the other level
+	tokens, _ := model.Snapshot().Bucket(BucketTokens)
+	if tokens.Remaining != 80 {
+		t.Errorf("model tokens remaining = %d, want 80", tokens.Remaining)
+	}
+}
+
+func TestHierarchy_RefundsOnLateRefusal(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	model := NewGCRA(100, 10, WithClock(clock))
+	local := New(100, 10, WithClock(clock))
+	h := NewHierarchy([]Level{
+		{Scope: "model", Limiter: model},
+		{Scope: "local", Limiter: local},
+		{Scope: "key", Limiter: racyLimiter{NewGCRA(100, 10, WithClock(clock))}},
+	}, WithClock(clock))
+
+	if refusedBy, ok := h.Consume(30, 0); ok || refusedBy != "key" {
+		t.Fatalf("Consume = %q, %v, want key to refuse", refusedBy, ok)
+	}
+
+	for _, l := range []Limiter{model, local} {
+		s := l.Snapshot()
+		tokens, _ := s.Bucket(BucketTokens)
+		requests, _ := s.Bucket(BucketRequests)
+		if tokens.Remaining != 100 || requests.Remaining != 10 {
+			t.Errorf("%T not refunded: %d tokens and %d requests remaining", l, tokens.Remaining, requests.Remaining)
+		}
+	}
+}
+
+func TestHierarchy_RefundsWrappedLevels(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	queuedInner := New(100, 10, WithClock(clock))
+	shared := New(100, 10, WithClock(clock))
+	unreserved := New(50, 5, WithClock(clock))
+	h := NewHierarchy([]Level{
+		{Scope: "queued", Limiter: NewQueued(queuedInner, WithClock(clock))},
+		{Scope: "reserved", Limiter: NewReserved(shared, unreserved, WithClock(clock))},
+		{Scope: "key", Limiter: racyLimiter{NewGCRA(100, 10, WithClock(clock))}},
+	}, WithClock(clock))
+
+	if refusedBy, ok := h.Consume(30, 0); ok || refusedBy != "key" {
+		t.Fatalf("Consume = %q, %v, want key to refuse", refusedBy, ok)
+	}
+
+	for _, l := range []Limiter{queuedInner, shared, unreserved} {
+		s := l.Snapshot()
+		tokens, _ := s.Bucket(BucketTokens)
+		requests, _ := s.Bucket(BucketRequests)
+		if tokens.Remaining != tokens.Capacity || requests.Remaining != requests.Capacity {
+			t.Errorf("capacity leaked: %d of %d tokens and %d of %d requests remaining",
+				tokens.Remaining, tokens.Capacity, requests.Remaining, requests.Capacity)
+		}
+	}
+}
+
+// plainLimiter hides a limiter's Refund method.
+type plainLimiter struct {
+	Limiter
+}
+
+func TestHierarchy_ConsumesNonRefundersLast(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	plain := NewGCRA(100, 10, WithClock(clock))
+	h := NewHierarchy([]Level{
+		{Scope: "plain", Limiter: plainLimiter{plain}},
+		{Scope: "key", Limiter: racyLimiter{NewGCRA(100, 10, WithClock(clock))}},
+	}, WithClock(clock))
+
+	// The level that cannot refund is only consumed once the others have
+	if refusedBy, ok := h.Consume(30, 0); ok || refusedBy != "key" {
+		t.Fatalf("Consume = %q, %v, want key to refuse", refusedBy, ok)
+	}
+	if tokens, _ := plain.Snapshot().Bucket(BucketTokens); tokens.Remaining != 100 {
+		t.Errorf("plain tokens remaining = %d, want 100", tokens.Remaining)
+	}
+}
+
+func TestHierarchy_WaitAndConsume(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	model := NewGCRA(60, 0, WithClock(clock))
+	provider := NewGCRA(0, 6, WithClock(clock))
+	h := NewHierarchy([]Level{
+		{Scope: "model", Limiter: model},
+		{Scope: "provider", Limiter: provider},
+	}, WithClock(clock))
+
+	model.TryConsume(55)
+	for range 6 {
+		provider.TryConsume(0)
+	}
+
+	// The provider's 10s wait dominates the model's 5s
+	if wait, scope := h.Wait(10, 0); wait != 10*time.Second || scope != "provider" {
+		t.Errorf("Wait = %v, %q, want 10s, provider", wait, scope)
+	}
+
+	ctx := context.Background()
+	var levelErr *LevelError
+	err := h.WaitAndConsume(ctx, 10, time.Second)
+	if !errors.As(err, &levelErr) || levelErr.Scope != "provider" || !errors.Is(err, ErrMaxWaitExceeded) {
+		t.Errorf("expected provider ErrMaxWaitExceeded, got %v", err)
+	}
+	err = h.WaitAndConsume(ctx, 61, 0)
+	if !errors.As(err, &levelErr) || levelErr.Scope != "model" || !errors.Is(err, ErrExceedsCapacity) {
+		t.Errorf("expected model ErrExceedsCapacity, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- h.WaitAndConsume(ctx, 10, 0)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("WaitAndConsume: %v", err)
+	}
+}
+
+func TestHierarchy_CountsWaitersOnLevels(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	model := NewGCRA(60, 0, WithClock(clock))
+	provider := NewGCRA(0, 1, WithClock(clock))
+	h := NewHierarchy([]Level{
+		{Scope: "model", Limiter: model},
+		{Scope: "provider", Limiter: provider},
+	}, WithClock(clock))
+	provider.TryConsume(0)
+
+	done := make(chan error)
+	go func() {
+		done <- h.WaitAndConsume(context.Background(), 1, 0)
+	}()
+	clock.BlockUntil(1)
+
+	// The caller waits on the provider, but every level reports it
+	for name, l := range map[string]Limiter{"hierarchy": h, "model": model, "provider": provider} {
+		if got := l.Snapshot().Waiters; got != 1 {
+			t.Errorf("%s waiters = %d, want 1", name, got)
+		}
+	}
+
+	clock.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitAndConsume: %v", err)
+	}
+	if got := model.Snapshot().Waiters; got != 0 {
+		t.Errorf("model waiters after the wait = %d, want 0", got)
+	}
+}
+
+func TestHierarchy_WaitsInQueue(t *testing.T) {
+	q, clock := newExhaustedQueue(t)
+	h := NewHierarchy([]Level{
+		{Scope: "model", Limiter: q},
+		{Scope: "provider", Limiter: NewGCRA(0, 100, WithClock(clock))},
+	}, WithClock(clock))
+
+	waiters := []struct {
+		name     string
+		tokens   int
+		priority int
+	}{
+		{"large", 60, 0},
+		{"small", 1, 0},
+		{"urgent", 1, 5},
+	}
+	served := make(chan string, len(waiters))
+	for i, w := range waiters {
+		go func() {
+			ctx := WithPriority(context.Background(), w.priority)
+			if err := h.WaitAndConsume(ctx, w.tokens, 0); err != nil {
+				t.Errorf("%s: %v", w.name, err)
+			}
+			served <- w.name
+		}()
+		waitForQueue(t, q, i+1)
+	}
+
+	// Capacity returns, but callers that do not wait cannot jump the queue
+	clock.Advance(time.Second)
+	if h.TryConsume(1) {
+		t.Error("TryConsume should not jump the queue")
+	}
+
+	for _, want := range []string{"urgent", "large", "small"} {
+		if got := advanceUntil(t, clock, served); got != want {
+			t.Errorf("served %s, want %s", got, want)
+		}
+	}
+}
+
+func TestHierarchy_Snapshot(t *testing.T) {
+	h := NewHierarchy([]Level{
+		{Scope: "model", Limiter: NewGCRA(100, 0)},
+		{Scope: "provider", Limiter: NewGCRA(0, 10)},
+	})
+
+	s := h.Snapshot()
+	if len(s.Buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %+v", s.Buckets)
+	}
+	for _, name := range []string{"model.tokens", "provider.requests"} {
+		if _, ok := s.Bucket(name); !ok {
+			t.Errorf("no %s bucket in snapshot", name)
+		}
+	}
+}
@@ -18,4 +18,113 @@ type Limiter interface {
 	// WaitAndConsume waits until tokens are available, then consumes them.
 	// Returns error if context is cancelled or maxWait is exceeded.
 	WaitAndConsume(ctx context.Context, tokens int, maxWait time.Duration) error
+
+	// Snapshot returns a read-only view of the limiter's current state.
+	Snapshot() Snapshot
+}
+
+// PriorityLimiter is a Limiter whose available capacity depends on the
+// caller's priority. Plain Limiter methods act as priority 0.
+type PriorityLimiter interface {
+	Limiter
+
+	// TryConsumePriority is TryConsume on behalf of a caller with priority.
+	TryConsumePriority(numTokens, priority int) bool
+
+	// TimeUntilAvailablePriority is TimeUntilAvailable on behalf of a caller
+	// with priority.
+	TimeUntilAvailablePriority(tokens, priority int) time.Duration
+}
+
+// TryConsumeWithPriority calls l.TryConsumePriority if l is a PriorityLimiter,
+// and l.TryConsume otherwise.
+func TryConsumeWithPriority(l Limiter, numTokens, priority int) bool {
+	if pl, ok := l.(PriorityLimiter); ok {
+		return pl.TryConsumePriority(numTokens, priority)
+	}
+	return l.TryConsume(numTokens)
+}
+
+// TimeUntilAvailableWithPriority calls l.TimeUntilAvailablePriority if l is a
+// PriorityLimiter, and l.TimeUntilAvailable otherwise.
+func TimeUntilAvailableWithPriority(l Limiter, tokens, priority int) time.Duration {
+	if pl, ok := l.(PriorityLimiter); ok {
+		return pl.TimeUntilAvailablePriority(tokens, priority)
+	}
+	return l.TimeUntilAvailable(tokens)
+}
+
+// WaitCounter is implemented by limiters that report the callers blocked on
+// them as Snapshot.Waiters. A Hierarchy counts its waiting callers against
+// each level, so they show up in every limiter they wait on.
+type WaitCounter interface {
+	// AddWaiters adds delta to the limiter's count of waiters.
+	AddWaiters(delta int)
+}
+
+// Bucket names used in snapshots.
+const (
+	BucketTokens      = "tokens"
+	BucketRequests    = "requests"
+	BucketDailyTokens = "daily_tokens"
+)
+
+// BucketSnapshot is the state of a single bucket.
+type BucketSnapshot struct {
+	Name      string
+	Capacity  int
+	Remaining int
+
+	// NextRefill is when the bucket next gains capacity. It is zero if the
+	// bucket is full.
+	NextRefill time.Time
+}
+
+// Snapshot is a read-only view of a limiter's state.
+type Snapshot struct {
+	Buckets []BucketSnapshot
+
+	// Waiters is the number of callers blocked in WaitAndConsume.
+	Waiters int
+}
+
+// Bucket returns the bucket with the given name.
+func (s Snapshot) Bucket(name string) (BucketSnapshot, bool) {
+	for _, b := range s.Buckets {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return BucketSnapshot{}, false
+}
+
+// Option configures a limiter.
+type Option func(*options)
+
+type options struct {
+	clock       Clock
+	dailyTokens int
+}
+
+// WithClock sets the clock used by a limiter. The default is SystemClock.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithDailyTokens adds a limit on tokens per day to a RateLimiter, GCRA or
+// Adaptive limiter. Zero or less means no daily limit, the default.
+func WithDailyTokens(tokensPerDay int) Option {
+	return func(o *options) {
+		o.dailyTokens = tokensPerDay
+	}
+}
+
+func applyOptions(opts []Option) options {
+	o := options{clock: SystemClock}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
 }
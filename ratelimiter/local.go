@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,10 +13,20 @@ type RateLimiter struct {
 	mu             sync.Mutex
 	TokensBucket   *TokenBucket
 	RequestsBucket *TokenBucket
+	DailyBucket    *TokenBucket // nil if there is no daily token limit
+
+	// waiters counts goroutines blocked in WaitAndConsume
+	waiters atomic.Int64
+
+	clock Clock
 }
 
-// Ensure RateLimiter implements Limiter.
-var _ Limiter = (*RateLimiter)(nil)
+// Ensure RateLimiter implements Limiter, Refunder and WaitCounter.
+var (
+	_ Limiter     = (*RateLimiter)(nil)
+	_ Refunder    = (*RateLimiter)(nil)
+	_ WaitCounter = (*RateLimiter)(nil)
+)
 
 // TryConsume atomically checks capacity and consumes tokens if available.
 // Both token and request buckets are checked together before consuming from either,
@@ -24,18 +35,23 @@ func (rl *RateLimiter) TryConsume(numTokens int) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	// Check both buckets have capacity before consuming from either
+	// Check all buckets have capacity before consuming from any
 	if !rl.TokensBucket.HasCapacity(numTokens) || !rl.RequestsBucket.HasCapacity(1) {
 		return false
 	}
+	if rl.DailyBucket != nil && !rl.DailyBucket.HasCapacity(numTokens) {
+		return false
+	}
 
-	// Both have capacity, now consume from both
+	// All have capacity, now consume from each
 	rl.TokensBucket.consume(numTokens)
 	rl.RequestsBucket.consume(1)
+	if rl.DailyBucket != nil {
+		rl.DailyBucket.consume(numTokens)
+	}
 	return true
 }
 
-
 // TokenBucket implements a token bucket rate limit algorithm.
 type TokenBucket struct {
 	mu             sync.Mutex
@@ -43,15 +59,18 @@ type TokenBucket struct {
 	remaining      int
 	refillInterval time.Duration
 	lastRefill     time.Time
+	clock          Clock
 }
 
 // NewTokenBucket creates a new token bucket.
-func NewTokenBucket(capacity int, initialTokens int, refillInterval time.Duration) *TokenBucket {
+func NewTokenBucket(capacity int, initialTokens int, refillInterval time.Duration, opts ...Option) *TokenBucket {
+	o := applyOptions(opts)
 	return &TokenBucket{
 		capacity:       capacity,
 		remaining:      initialTokens,
 		refillInterval: refillInterval,
-		lastRefill:     time.Now(),
+		lastRefill:     o.clock.Now(),
+		clock:          o.clock,
 	}
 }
 
@@ -86,10 +105,42 @@ func (tb *TokenBucket) consume(tokens int) {
 	tb.remaining -= tokens
 }
 
+// refund returns tokens to the bucket, up to its capacity.
+func (tb *TokenBucket) refund(tokens int) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.remaining = min(tb.remaining+tokens, tb.capacity)
+}
+
+// Refund returns tokens and one request to the buckets.
+func (rl *RateLimiter) Refund(tokens int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.TokensBucket.refund(tokens)
+	rl.RequestsBucket.refund(1)
+	if rl.DailyBucket != nil {
+		rl.DailyBucket.refund(tokens)
+	}
+}
+
+// Remaining returns the tokens currently available, after any due refill.
+func (tb *TokenBucket) Remaining() int {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refillLocked()
+	return tb.remaining
+}
+
+// Capacity returns the maximum number of tokens the bucket holds.
+func (tb *TokenBucket) Capacity() int {
+	return tb.capacity
+}
+
 // refillLocked refills the bucket based on elapsed time.
 // Must be called while holding tb.mu.
 func (tb *TokenBucket) refillLocked() {
-	now := time.Now()
+	now := tb.clock.Now()
 	if now.Sub(tb.lastRefill) >= tb.refillInterval {
 		tb.remaining = tb.capacity
 		tb.lastRefill = now
@@ -106,10 +157,11 @@ func (rl *RateLimiter) Wait(tokens int) time.Duration {
 func (rl *RateLimiter) TimeUntilAvailable(tokens int) time.Duration {
 	tokenWait := rl.TokensBucket.TimeUntilAvailable(tokens)
 	requestWait := rl.RequestsBucket.TimeUntilAvailable(1)
-	if tokenWait > requestWait {
-		return tokenWait
+	wait := max(tokenWait, requestWait)
+	if rl.DailyBucket != nil {
+		wait = max(wait, rl.DailyBucket.TimeUntilAvailable(tokens))
 	}
-	return requestWait
+	return wait
 }
 
 // WaitAndConsume waits until tokens are available (up to maxWait), then consumes them.
@@ -121,17 +173,20 @@ func (rl *RateLimiter) WaitAndConsume(ctx context.Context, tokens int, maxWait t
 	if waitDuration > 0 {
 		// Check if we would exceed maxWait
 		if maxWait > 0 && waitDuration > maxWait {
-			return fmt.Errorf("rate limit wait time %v exceeds max wait %v", waitDuration, maxWait)
+			return fmt.Errorf("%w: need %v, max %v", ErrMaxWaitExceeded, waitDuration, maxWait)
 		}
 
+		rl.waiters.Add(1)
+		defer rl.waiters.Add(-1)
+
 		// Create a timer for the wait
-		timer := time.NewTimer(waitDuration)
+		timer := rl.clockOrDefault().NewTimer(waitDuration)
 		defer timer.Stop()
 
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-timer.C:
+		case <-timer.C():
 			// Wait complete, proceed to consume
 		}
 	}
@@ -145,12 +200,22 @@ func (rl *RateLimiter) WaitAndConsume(ctx context.Context, tokens int, maxWait t
 	return nil
 }
 
+// Waiters returns the number of goroutines currently blocked in WaitAndConsume.
+func (rl *RateLimiter) Waiters() int {
+	return int(rl.waiters.Load())
+}
+
+// AddWaiters adds delta to the count of waiters.
+func (rl *RateLimiter) AddWaiters(delta int) {
+	rl.waiters.Add(int64(delta))
+}
+
 // TimeUntilAvailable returns how long until tokens would be available (read-only).
 func (tb *TokenBucket) TimeUntilAvailable(tokens int) time.Duration {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	now := time.Now()
+	now := tb.clock.Now()
 	timeSinceLastRefill := now.Sub(tb.lastRefill)
 
 	// Calculate current effective remaining (with partial refill)
@@ -186,7 +251,7 @@ func (tb *TokenBucket) Wait(tokens int) time.Duration {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	now := time.Now()
+	now := tb.clock.Now()
 	timeSinceLastRefill := now.Sub(tb.lastRefill)
 
 	// Calculate how many tokens have been replenished since last refill
@@ -219,11 +284,59 @@ func (tb *TokenBucket) Wait(tokens int) time.Duration {
 	return waitDuration + (waitDuration / 10)
 }
 
+// Snapshot returns the state of the token, request and daily buckets.
+func (rl *RateLimiter) Snapshot() Snapshot {
+	s := Snapshot{
+		Buckets: []BucketSnapshot{
+			rl.TokensBucket.Snapshot(BucketTokens),
+			rl.RequestsBucket.Snapshot(BucketRequests),
+		},
+		Waiters: rl.Waiters(),
+	}
+	if rl.DailyBucket != nil {
+		s.Buckets = append(s.Buckets, rl.DailyBucket.Snapshot(BucketDailyTokens))
+	}
+	return s
+}
+
+// Snapshot returns the bucket's state under the given name.
+func (tb *TokenBucket) Snapshot(name string) BucketSnapshot {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refillLocked()
+
+	s := BucketSnapshot{
+		Name:      name,
+		Capacity:  tb.capacity,
+		Remaining: tb.remaining,
+	}
+	if tb.remaining < tb.capacity {
+		s.NextRefill = tb.lastRefill.Add(tb.refillInterval)
+	}
+	return s
+}
+
+// clockOrDefault returns the limiter's clock, or SystemClock for a
+// RateLimiter built without New.
+func (rl *RateLimiter) clockOrDefault() Clock {
+	if rl.clock == nil {
+		return SystemClock
+	}
+	return rl.clock
+}
+
 // New creates a RateLimiter with the specified tokens and requests per minute limits.
-func New(tokensPerMinute, requestsPerMinute int) *RateLimiter {
+// Use WithDailyTokens to add a daily token limit.
+func New(tokensPerMinute, requestsPerMinute int, opts ...Option) *RateLimiter {
+	o := applyOptions(opts)
 	refillInterval := time.Minute
-	return &RateLimiter{
-		TokensBucket:   NewTokenBucket(tokensPerMinute, tokensPerMinute, refillInterval),
-		RequestsBucket: NewTokenBucket(requestsPerMinute, requestsPerMinute, refillInterval),
+	rl := &RateLimiter{
+		TokensBucket:   NewTokenBucket(tokensPerMinute, tokensPerMinute, refillInterval, opts...),
+		RequestsBucket: NewTokenBucket(requestsPerMinute, requestsPerMinute, refillInterval, opts...),
+		clock:          o.clock,
+	}
+	if o.dailyTokens > 0 {
+		rl.DailyBucket = NewTokenBucket(o.dailyTokens, o.dailyTokens, 24*time.Hour, opts...)
 	}
+	return rl
 }
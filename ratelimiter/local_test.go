@@ -1,21 +1,26 @@
 package ratelimiter
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 )
 
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func TestTokenBucket(t *testing.T) {
+	clock := NewFakeClock(epoch)
 	capacity := 10
 	refillInterval := time.Minute
-	bucket := NewTokenBucket(capacity, capacity, refillInterval)
+	bucket := NewTokenBucket(capacity, capacity, refillInterval, WithClock(clock))
 
 	// Test initial capacity
 	if !bucket.TryConsume(5) {
 		t.Error("failed to consume tokens from full bucket")
 	}
-	if bucket.remaining != 5 {
-		t.Errorf("expected 5 remaining tokens, got %d", bucket.remaining)
+	if got := bucket.Remaining(); got != 5 {
+		t.Errorf("expected 5 remaining tokens, got %d", got)
 	}
 
 	// Test consuming more than remaining
@@ -23,22 +28,22 @@ func TestTokenBucket(t *testing.T) {
 		t.Error("should not be able to consume more than remaining")
 	}
 
-	// Test refill (mocking time would be better, but for now we test logic)
-	// We can't easily mock time in this implementation without refactoring,
-	// so we'll test the logic by manually manipulating the struct if needed,
-	// or just trust the logic we read.
-	// For a robust test, we should verify the refill logic.
-	// Let's create a bucket with a short interval for testing.
-	shortInterval := 10 * time.Millisecond
-	fastBucket := NewTokenBucket(capacity, 0, shortInterval)
+	// Test refill
+	fastBucket := NewTokenBucket(capacity, 0, refillInterval, WithClock(clock))
 
 	// Should fail initially
 	if fastBucket.TryConsume(1) {
 		t.Error("should fail to consume from empty bucket")
 	}
 
+	// Not refilled before a full interval
+	clock.Advance(refillInterval - time.Second)
+	if fastBucket.TryConsume(1) {
+		t.Error("should fail to consume before the refill interval")
+	}
+
 	// Wait for refill
-	time.Sleep(20 * time.Millisecond)
+	clock.Advance(time.Second)
 
 	// Should succeed now
 	if !fastBucket.TryConsume(1) {
@@ -74,15 +79,135 @@ func TestRateLimiter_TryConsume(t *testing.T) {
 }
 
 func TestRateLimiter_Wait(t *testing.T) {
-	rl := New(60, 60) // 1 token per second
+	rl := New(60, 60, WithClock(NewFakeClock(epoch))) // 1 token per second
 
 	// Consume all tokens
 	rl.TokensBucket.TryConsume(60)
 
-	// We need 1 token. Refill rate is 1/sec.
-	// Wait should return approx 1s.
+	// We need 1 token. Refill rate is 1/sec, plus a 10% buffer.
 	wait := rl.Wait(1)
-	if wait < 900*time.Millisecond || wait > 1500*time.Millisecond {
-		t.Errorf("expected wait around 1s, got %v", wait)
+	if wait < 1099*time.Millisecond || wait > 1101*time.Millisecond {
+		t.Errorf("expected wait of 1.1s, got %v", wait)
+	}
+}
+
+func TestRateLimiter_Waiters(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock))
+	rl.TryConsume(100)
+
+	if got := rl.TokensBucket.Remaining(); got != 0 {
+		t.Errorf("expected 0 remaining, got %d", got)
+	}
+
+	done := make(chan error)
+	go func() {
+		// A full bucket's worth waits past the refill interval
+		done <- rl.WaitAndConsume(context.Background(), 100, 0)
+	}()
+
+	clock.BlockUntil(1)
+	if got := rl.Waiters(); got != 1 {
+		t.Errorf("expected 1 waiter, got %d", got)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitAndConsume: %v", err)
+	}
+	if got := rl.Waiters(); got != 0 {
+		t.Errorf("expected 0 waiters after wait, got %d", got)
+	}
+	if got := rl.TokensBucket.Remaining(); got != 0 {
+		t.Errorf("expected 0 remaining, got %d", got)
+	}
+}
+
+func TestRateLimiter_WaitAndConsume_Canceled(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock))
+	rl.TryConsume(100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- rl.WaitAndConsume(ctx, 50, 0)
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if got := rl.Waiters(); got != 0 {
+		t.Errorf("expected 0 waiters after cancel, got %d", got)
+	}
+}
+
+func TestRateLimiter_Snapshot(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock))
+
+	snapshot := rl.Snapshot()
+	tokens, ok := snapshot.Bucket(BucketTokens)
+	if !ok {
+		t.Fatal("no tokens bucket in snapshot")
+	}
+	if tokens.Capacity != 100 || tokens.Remaining != 100 || !tokens.NextRefill.IsZero() {
+		t.Errorf("unexpected full bucket snapshot: %+v", tokens)
+	}
+
+	clock.Advance(10 * time.Second)
+	rl.TryConsume(30)
+
+	snapshot = rl.Snapshot()
+	tokens, _ = snapshot.Bucket(BucketTokens)
+	requests, _ := snapshot.Bucket(BucketRequests)
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"tokens remaining", tokens.Remaining, 70},
+		{"requests remaining", requests.Remaining, 9},
+		{"requests capacity", requests.Capacity, 10},
+		{"waiters", snapshot.Waiters, 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if want := epoch.Add(time.Minute); !tokens.NextRefill.Equal(want) {
+		t.Errorf("next refill = %v, want %v", tokens.NextRefill, want)
+	}
+}
+
+func TestRateLimiter_DailyTokens(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock), WithDailyTokens(150))
+
+	if !rl.TryConsume(100) {
+		t.Fatal("expected first request to succeed")
+	}
+	clock.Advance(time.Minute)
+
+	// The minute buckets have refilled but the daily budget has not
+	if rl.TryConsume(100) {
+		t.Error("expected the daily limit to refuse the request")
+	}
+	if !rl.TryConsume(50) {
+		t.Error("expected the rest of the daily budget to be available")
+	}
+	if b, ok := rl.Snapshot().Bucket(BucketDailyTokens); !ok || b.Remaining != 0 {
+		t.Errorf("expected an empty daily bucket in the snapshot, got %+v (found %v)", b, ok)
+	}
+
+	clock.Advance(24 * time.Hour)
+	if !rl.TryConsume(100) {
+		t.Error("expected the daily budget to refill after a day")
 	}
 }
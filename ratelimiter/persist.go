@@ -0,0 +1,340 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStaleState is returned when restoring a state older than the
+	// caller's maximum age.
+	ErrStaleState = errors.New("limiter state is stale")
+
+	// ErrFutureState is returned when restoring a state saved after the
+	// current time, which indicates clock skew between processes.
+	ErrFutureState = errors.New("limiter state was saved in the future")
+)
+
+// State is the saved state of a limiter's buckets, for restoring after a
+// restart.
+type State struct {
+	SavedAt time.Time     `json:"saved_at"`
+	Buckets []BucketState `json:"buckets"`
+
+	// Scale is an Adaptive limiter's fraction of its base limits, and zero
+	// for other limiters.
+	Scale float64 `json:"scale,omitempty"`
+}
+
+// BucketState is the saved state of one bucket.
+type BucketState struct {
+	Name       string    `json:"name"`
+	Remaining  int       `json:"remaining"`
+	LastRefill time.Time `json:"last_refill"`
+
+	// TAT is a GCRA bucket's theoretical arrival time, when it would be full
+	// again. It is zero for other limiters.
+	TAT time.Time `json:"tat,omitzero"`
+}
+
+// Persistent is a Limiter whose state can be saved and restored, so that a
+// restarted process does not start with full buckets.
+type Persistent interface {
+	Limiter
+
+	// SaveState returns the current state.
+	SaveState() State
+
+	// RestoreState replaces the state of the buckets named in state. It
+	// returns ErrStaleState if maxAge is positive and state is older, and
+	// ErrFutureState if state was saved after the limiter's current time.
+	RestoreState(state State, maxAge time.Duration) error
+}
+
+// Ensure the limiters implement Persistent.
+var (
+	_ Persistent = (*RateLimiter)(nil)
+	_ Persistent = (*GCRA)(nil)
+	_ Persistent = (*Adaptive)(nil)
+)
+
+// checkStateAge returns ErrFutureState or ErrStaleState if state cannot be
+// restored at now.
+func checkStateAge(state State, now time.Time, maxAge time.Duration) error {
+	if state.SavedAt.After(now) {
+		return fmt.Errorf("%w: saved at %v", ErrFutureState, state.SavedAt)
+	}
+	if maxAge > 0 && now.Sub(state.SavedAt) > maxAge {
+		return fmt.Errorf("%w: saved %v ago", ErrStaleState, now.Sub(state.SavedAt))
+	}
+	return nil
+}
+
+// SaveState returns the state of the token, request and daily buckets.
+func (rl *RateLimiter) SaveState() State {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	state := State{
+		SavedAt: rl.clockOrDefault().Now(),
+		Buckets: []BucketState{
+			rl.TokensBucket.saveState(BucketTokens),
+			rl.RequestsBucket.saveState(BucketRequests),
+		},
+	}
+	if rl.DailyBucket != nil {
+		state.Buckets = append(state.Buckets, rl.DailyBucket.saveState(BucketDailyTokens))
+	}
+	return state
+}
+
+// RestoreState restores the buckets named in state. Time since the state was
+// saved counts towards refills as usual, so old state only limits buckets
+// whose refill interval has not yet passed. Remaining tokens are capped at
+// each bucket's current capacity.
+func (rl *RateLimiter) RestoreState(state State, maxAge time.Duration) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clockOrDefault().Now()
+	if err := checkStateAge(state, now, maxAge); err != nil {
+		return err
+	}
+
+	buckets := map[string]*TokenBucket{
+		BucketTokens:      rl.TokensBucket,
+		BucketRequests:    rl.RequestsBucket,
+		BucketDailyTokens: rl.DailyBucket,
+	}
+	for _, saved := range state.Buckets {
+		if bucket := buckets[saved.Name]; bucket != nil {
+			bucket.restoreState(saved, now)
+		}
+	}
+	return nil
+}
+
+// saveState returns the bucket's state under the given name.
+func (tb *TokenBucket) saveState(name string) BucketState {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refillLocked()
+
+	return BucketState{
+		Name:       name,
+		Remaining:  tb.remaining,
+		LastRefill: tb.lastRefill,
+	}
+}
+
+// restoreState sets the bucket from a saved state.
+func (tb *TokenBucket) restoreState(saved BucketState, now time.Time) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.remaining = min(max(saved.Remaining, 0), tb.capacity)
+	tb.lastRefill = saved.LastRefill
+	if tb.lastRefill.After(now) {
+		tb.lastRefill = now
+	}
+}
+
+// SaveState returns the state of the enabled buckets.
+func (g *GCRA) SaveState() State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	state := State{SavedAt: now}
+	for _, b := range g.namedBuckets() {
+		if b.bucket.enabled() {
+			state.Buckets = append(state.Buckets, b.bucket.saveState(b.name, now))
+		}
+	}
+	return state
+}
+
+// RestoreState restores the buckets named in state. Capacity is regained
+// while the process is down as if it had kept running. State saved by a
+// RateLimiter is converted from its remaining capacity.
+func (g *GCRA) RestoreState(state State, maxAge time.Duration) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	if err := checkStateAge(state, now, maxAge); err != nil {
+		return err
+	}
+
+	for _, saved := range state.Buckets {
+		for _, b := range g.namedBuckets() {
+			if b.name == saved.Name {
+				b.bucket.restoreState(saved, state.SavedAt, now)
+			}
+		}
+	}
+	return nil
+}
+
+// namedBucket is a GCRA bucket and its snapshot name.
+type namedBucket struct {
+	name   string
+	bucket *gcraBucket
+}
+
+// namedBuckets returns the buckets with their snapshot names.
+func (g *GCRA) namedBuckets() []namedBucket {
+	return []namedBucket{
+		{BucketTokens, &g.tokens},
+		{BucketRequests, &g.requests},
+		{BucketDailyTokens, &g.daily},
+	}
+}
+
+// saveState returns the bucket's state under the given name.
+func (b *gcraBucket) saveState(name string, now time.Time) BucketState {
+	return BucketState{
+		Name:      name,
+		Remaining: b.snapshot(name, now).Remaining,
+		TAT:       b.tat,
+	}
+}
+
+// restoreState sets the bucket from a state saved at savedAt, never leaving
+// it more than empty.
+func (b *gcraBucket) restoreState(saved BucketState, savedAt, now time.Time) {
+	if !b.enabled() {
+		return
+	}
+
+	tat := saved.TAT
+	if tat.IsZero() {
+		used := b.limit - min(max(saved.Remaining, 0), b.limit)
+		tat = savedAt.Add(b.interval * time.Duration(used))
+	}
+	if limit := now.Add(b.burst); tat.After(limit) {
+		tat = limit
+	}
+	b.tat = tat
+}
+
+// SaveState returns the state of the buckets and the current scale.
+func (a *Adaptive) SaveState() State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	state := a.gcra.SaveState()
+	state.Scale = a.scale
+	return state
+}
+
+// RestoreState restores the scale, within the configured bounds, and then
+// the buckets.
+func (a *Adaptive) RestoreState(state State, maxAge time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := checkStateAge(state, a.clock.Now(), maxAge); err != nil {
+		return err
+	}
+	if state.Scale > 0 {
+		a.apply(min(max(state.Scale, a.config.MinScale), a.config.MaxScale))
+	}
+	return a.gcra.RestoreState(state, maxAge)
+}
+
+// Store saves limiter states by key.
+type Store interface {
+	// Load returns the state saved under key, and false if there is none.
+	Load(key string) (State, bool, error)
+
+	// Save saves state under key, replacing any previous state.
+	Save(key string, state State) error
+}
+
+// FileStore is a Store that keeps every state in one JSON file. It is safe
+// for concurrent use within a process; processes must not share a file.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Ensure FileStore implements Store.
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore creates a FileStore at path. The file is created on the first
+// Save.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load returns the state saved under key.
+func (s *FileStore) Load(key string) (State, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states, err := s.read()
+	if err != nil {
+		return State{}, false, err
+	}
+	state, ok := states[key]
+	return state, ok, nil
+}
+
+// Save saves state under key. The file is replaced atomically.
+func (s *FileStore) Save(key string, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states, err := s.read()
+	if err != nil {
+		return err
+	}
+	states[key] = state
+
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding limiter state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("saving limiter state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving limiter state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving limiter state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("saving limiter state: %w", err)
+	}
+	return nil
+}
+
+// read returns every saved state. Must be called while holding s.mu.
+func (s *FileStore) read() (map[string]State, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]State), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading limiter state: %w", err)
+	}
+
+	states := make(map[string]State)
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("decoding limiter state %s: %w", s.path, err)
+	}
+	return states, nil
+}
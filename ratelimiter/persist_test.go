@@ -0,0 +1,218 @@
+package ratelimiter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_SaveRestoreState(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock), WithDailyTokens(1000))
+	for range 3 {
+		rl.TryConsume(100)
+		clock.Advance(time.Minute)
+	}
+	state := rl.SaveState()
+
+	// A restarted limiter picks up where the old one left off
+	clock.Advance(time.Hour)
+	restored := New(100, 10, WithClock(clock), WithDailyTokens(1000))
+	if err := restored.RestoreState(state, 0); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+
+	snapshot := restored.Snapshot()
+	if b, _ := snapshot.Bucket(BucketDailyTokens); b.Remaining != 700 {
+		t.Errorf("daily remaining = %d, want 700", b.Remaining)
+	}
+	// The minute buckets refilled while the process was down
+	if b, _ := snapshot.Bucket(BucketTokens); b.Remaining != 100 {
+		t.Errorf("tokens remaining = %d, want 100", b.Remaining)
+	}
+
+	// The daily bucket still refills a day after its last refill
+	clock.Advance(23 * time.Hour)
+	if b, _ := restored.Snapshot().Bucket(BucketDailyTokens); b.Remaining != 1000 {
+		t.Errorf("daily remaining after a day = %d, want 1000", b.Remaining)
+	}
+}
+
+func TestRateLimiter_RestoreState_Capped(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	state := New(0, 0, WithClock(clock), WithDailyTokens(1000)).SaveState()
+
+	// The daily limit was lowered since the state was saved
+	rl := New(100, 10, WithClock(clock), WithDailyTokens(500))
+	if err := rl.RestoreState(state, 0); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	if b, _ := rl.Snapshot().Bucket(BucketDailyTokens); b.Remaining != 500 {
+		t.Errorf("daily remaining = %d, want 500", b.Remaining)
+	}
+}
+
+func TestRateLimiter_RestoreState_Errors(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock), WithDailyTokens(1000))
+	rl.TryConsume(100)
+	state := rl.SaveState()
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		maxAge  time.Duration
+		want    error
+	}{
+		{"fresh", time.Hour, 2 * time.Hour, nil},
+		{"no max age", 48 * time.Hour, 0, nil},
+		{"stale", 3 * time.Hour, 2 * time.Hour, ErrStaleState},
+		{"future", -time.Minute, 0, ErrFutureState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewFakeClock(epoch.Add(tt.advance))
+			fresh := New(100, 10, WithClock(c), WithDailyTokens(1000))
+
+			err := fresh.RestoreState(state, tt.maxAge)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("RestoreState() error = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				// A refused state leaves the buckets full
+				if b, _ := fresh.Snapshot().Bucket(BucketDailyTokens); b.Remaining != 1000 {
+					t.Errorf("daily remaining = %d, want 1000", b.Remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestGCRA_SaveRestoreState(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	g := NewGCRA(60, 4, WithClock(clock), WithDailyTokens(1000))
+	for range 3 {
+		g.TryConsume(20)
+	}
+
+	// Round trip through a store, as the Manager does
+	store := NewFileStore(filepath.Join(t.TempDir(), "limits.json"))
+	if err := store.Save("model", g.SaveState()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	state, _, err := store.Load("model")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// A restarted limiter picks up where the old one left off, having
+	// regained capacity smoothly while it was down
+	clock.Advance(30 * time.Second)
+	restored := NewGCRA(60, 4, WithClock(clock), WithDailyTokens(1000))
+	if err := restored.RestoreState(state, 0); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+
+	want := map[string]int{BucketTokens: 30, BucketRequests: 3, BucketDailyTokens: 940}
+	for name, remaining := range want {
+		if b, _ := restored.Snapshot().Bucket(name); b.Remaining != remaining {
+			t.Errorf("%s remaining = %d, want %d", name, b.Remaining, remaining)
+		}
+	}
+}
+
+func TestGCRA_RestoreState_FromRateLimiter(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock))
+	rl.TryConsume(100)
+
+	// Switching limiter type keeps the usage
+	g := NewGCRA(100, 10, WithClock(clock))
+	if err := g.RestoreState(rl.SaveState(), 0); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	if g.TryConsume(1) {
+		t.Error("restored GCRA should have no tokens left")
+	}
+}
+
+func TestAdaptive_SaveRestoreState(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	config := AdaptiveConfig{MinScale: 0.1}
+	a := NewAdaptive(1000, 100, config, WithClock(clock))
+	a.ReportRateLimited()
+	a.TryConsume(400)
+	state := a.SaveState()
+
+	restored := NewAdaptive(1000, 100, config, WithClock(clock))
+	if err := restored.RestoreState(state, 0); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	if got := restored.Scale(); got != 0.5 {
+		t.Errorf("Scale() = %v, want 0.5", got)
+	}
+	if b, _ := restored.Snapshot().Bucket(BucketTokens); b.Capacity != 500 || b.Remaining != 100 {
+		t.Errorf("tokens = %d of %d, want 100 of 500", b.Remaining, b.Capacity)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	store := NewFileStore(path)
+
+	if _, ok, err := store.Load("a"); err != nil || ok {
+		t.Fatalf("Load() from missing file = %v, %v; want not found", ok, err)
+	}
+
+	clock := NewFakeClock(epoch)
+	rl := New(100, 10, WithClock(clock), WithDailyTokens(1000))
+	rl.TryConsume(40)
+
+	if err := store.Save("a", rl.SaveState()); err != nil {
+		t.Fatalf("Save(a): %v", err)
+	}
+	if err := store.Save("b", New(1, 1, WithClock(clock)).SaveState()); err != nil {
+		t.Fatalf("Save(b): %v", err)
+	}
+
+	// A new store reads what the old one wrote
+	state, ok, err := NewFileStore(path).Load("a")
+	if err != nil || !ok {
+		t.Fatalf("Load(a) = %v, %v; want found", ok, err)
+	}
+	if !state.SavedAt.Equal(epoch) || len(state.Buckets) != 3 {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+	for _, b := range state.Buckets {
+		if b.Name == BucketDailyTokens && b.Remaining != 960 {
+			t.Errorf("daily remaining = %d, want 960", b.Remaining)
+		}
+	}
+	if _, ok, _ := store.Load("b"); !ok {
+		t.Error("expected state b to be kept when a was saved")
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file, found %d entries", len(entries))
+	}
+}
+
+func TestFileStore_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileStore(path)
+	if _, _, err := store.Load("a"); err == nil {
+		t.Error("expected an error loading a corrupt file")
+	}
+	if err := store.Save("a", State{}); err == nil {
+		t.Error("expected Save not to overwrite a corrupt file")
+	}
+}
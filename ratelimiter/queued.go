@@ -0,0 +1,314 @@
+package ratelimiter
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// minRetryInterval bounds how often the head of a Queued limiter retries when
+// the inner limiter reports capacity it then refuses.
+const minRetryInterval = 10 * time.Millisecond
+
+type priorityKey struct{}
+
+type positionFuncKey struct{}
+
+// WithPriority returns a context whose WaitAndConsume calls on a Queued
+// limiter are served before those of lower priority. The default is 0.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set with WithPriority, or 0.
+func PriorityFromContext(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityKey{}).(int)
+	return priority
+}
+
+// WithPositionFunc returns a context whose WaitAndConsume calls on a Queued
+// limiter report their queue position to fn whenever it changes. Position 0
+// is the head of the queue. fn is called from the waiting goroutine.
+func WithPositionFunc(ctx context.Context, fn func(position int)) context.Context {
+	return context.WithValue(ctx, positionFuncKey{}, fn)
+}
+
+// QueueEntry describes a caller waiting in a Queued limiter.
+type QueueEntry struct {
+	Position int
+	Priority int
+	Tokens   int
+	Since    time.Time
+}
+
+// Queued wraps a Limiter so that callers of WaitAndConsume are served in
+// order: by priority, then first come, first served.
+//
+// Only the head of the queue tries to consume from the inner limiter, so a
+// large request is not starved by a stream of small ones. TryConsume only
+// succeeds when nobody is queued.
+type Queued struct {
+	inner Limiter
+	clock Clock
+
+	queue []*waiter
+	seq   uint64
+	mu    sync.Mutex
+}
+
+// waiter is a caller blocked in WaitAndConsume.
+type waiter struct {
+	seq      uint64
+	priority int
+	tokens   int
+	since    time.Time
+
+	// wake is signaled when the queue changes
+	wake chan struct{}
+}
+
+// Ensure Queued implements PriorityLimiter and PriorityRefunder.
+var (
+	_ PriorityLimiter  = (*Queued)(nil)
+	_ PriorityRefunder = (*Queued)(nil)
+)
+
+// NewQueued creates a Queued limiter around inner.
+func NewQueued(inner Limiter, opts ...Option) *Queued {
+	o := applyOptions(opts)
+	return &Queued{
+		inner: inner,
+		clock: o.clock,
+	}
+}
+
+// TryConsume consumes from the inner limiter if no callers are queued.
+func (q *Queued) TryConsume(numTokens int) bool {
+	return q.TryConsumePriority(numTokens, 0)
+}
+
+// TryConsumePriority consumes from the inner limiter if no callers are
+// queued, passing priority on if the inner limiter is a PriorityLimiter.
+func (q *Queued) TryConsumePriority(numTokens, priority int) bool {
+	return q.tryConsumeVia(q.inner, numTokens, priority)
+}
+
+// Refund returns tokens and one request to the inner limiter, if it can
+// refund, and wakes the queue.
+func (q *Queued) Refund(tokens int) {
+	q.RefundPriority(tokens, 0)
+}
+
+// RefundPriority is Refund on behalf of a caller with priority.
+func (q *Queued) RefundPriority(tokens, priority int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if RefundWithPriority(q.inner, tokens, priority) {
+		q.notifyLocked()
+	}
+}
+
+// TimeUntilAvailable returns the inner limiter's estimate. It does not
+// account for callers already queued.
+func (q *Queued) TimeUntilAvailable(tokens int) time.Duration {
+	return q.inner.TimeUntilAvailable(tokens)
+}
+
+// TimeUntilAvailablePriority returns the inner limiter's estimate for a
+// caller with priority. It does not account for callers already queued.
+func (q *Queued) TimeUntilAvailablePriority(tokens, priority int) time.Duration {
+	return TimeUntilAvailableWithPriority(q.inner, tokens, priority)
+}
+
+// WaitAndConsume joins the queue and waits until this caller reaches the head
+// and the inner limiter has capacity, then consumes. If maxWait is 0, there
+// is no limit on how long to wait. Priority and position reporting are taken
+// from ctx; see WithPriority and WithPositionFunc.
+func (q *Queued) WaitAndConsume(ctx context.Context, tokens int, maxWait time.Duration) error {
+	return q.waitAndConsume(ctx, tokens, maxWait, q.inner)
+}
+
+// waitAndConsume implements WaitAndConsume, consuming from via instead of
+// the inner limiter. Hierarchy passes a limiter that includes the inner one,
+// so that its callers wait in q's queue.
+func (q *Queued) waitAndConsume(ctx context.Context, tokens int, maxWait time.Duration, via Limiter) error {
+	priority := PriorityFromContext(ctx)
+	if q.tryConsumeVia(via, tokens, priority) {
+		return nil
+	}
+
+	w := q.enqueue(priority, tokens)
+	defer q.remove(w)
+
+	var deadline time.Time
+	if maxWait > 0 {
+		deadline = w.since.Add(maxWait)
+	}
+	onPosition, _ := ctx.Value(positionFuncKey{}).(func(int))
+	lastPosition := -1
+
+	for {
+		position, consumed := q.advance(w, via)
+		if consumed {
+			return nil
+		}
+		if position != lastPosition && onPosition != nil {
+			onPosition(position)
+		}
+		lastPosition = position
+
+		// The head sleeps until the inner limiter has capacity; everyone
+		// else sleeps until the queue changes
+		wait := time.Duration(-1)
+		if position == 0 {
+			wait = TimeUntilAvailableWithPriority(via, tokens, priority)
+			if wait < 0 {
+				return fmt.Errorf("%w: %d tokens", ErrExceedsCapacity, tokens)
+			}
+			if wait == 0 {
+				wait = minRetryInterval
+			}
+		}
+
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(q.clock.Now())
+			if position == 0 && wait > remaining {
+				return fmt.Errorf("%w: need %v, max %v", ErrMaxWaitExceeded, wait, maxWait)
+			}
+			if remaining <= 0 {
+				return fmt.Errorf("%w: still queued at position %d after %v", ErrMaxWaitExceeded, position, maxWait)
+			}
+			if wait < 0 {
+				wait = remaining
+			}
+		}
+
+		if err := q.sleep(ctx, w, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// tryConsumeVia consumes from via if no callers are queued.
+func (q *Queued) tryConsumeVia(via Limiter, numTokens, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.queue) == 0 && TryConsumeWithPriority(via, numTokens, priority)
+}
+
+// advance returns w's position, first consuming from via for it if it is at
+// the head.
+func (q *Queued) advance(w *waiter, via Limiter) (position int, consumed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	position = slices.Index(q.queue, w)
+	if position == 0 && TryConsumeWithPriority(via, w.tokens, w.priority) {
+		return 0, true
+	}
+	return position, false
+}
+
+// sleep blocks until d elapses (forever if d is negative), the queue changes
+// or ctx is done.
+func (q *Queued) sleep(ctx context.Context, w *waiter, d time.Duration) error {
+	var timeout <-chan time.Time
+	if d >= 0 {
+		timer := q.clock.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.wake:
+		return nil
+	case <-timeout:
+		return nil
+	}
+}
+
+// enqueue adds a waiter behind everyone of equal or higher priority.
+func (q *Queued) enqueue(priority, tokens int) *waiter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	w := &waiter{
+		seq:      q.seq,
+		priority: priority,
+		tokens:   tokens,
+		since:    q.clock.Now(),
+		wake:     make(chan struct{}, 1),
+	}
+
+	i, _ := slices.BinarySearchFunc(q.queue, w, func(a, b *waiter) int {
+		if a.priority != b.priority {
+			return cmp.Compare(b.priority, a.priority)
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+	q.queue = slices.Insert(q.queue, i, w)
+	q.notifyLocked()
+
+	return w
+}
+
+// remove takes w out of the queue, if it is still there.
+func (q *Queued) remove(w *waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i := slices.Index(q.queue, w); i >= 0 {
+		q.queue = slices.Delete(q.queue, i, i+1)
+		q.notifyLocked()
+	}
+}
+
+// notifyLocked wakes every waiter so they re-check their position.
+// Must be called while holding q.mu.
+func (q *Queued) notifyLocked() {
+	for _, w := range q.queue {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Len returns the number of callers waiting.
+func (q *Queued) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queue)
+}
+
+// Queue returns the callers waiting, head first.
+func (q *Queued) Queue() []QueueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]QueueEntry, len(q.queue))
+	for i, w := range q.queue {
+		entries[i] = QueueEntry{
+			Position: i,
+			Priority: w.priority,
+			Tokens:   w.tokens,
+			Since:    w.since,
+		}
+	}
+	return entries
+}
+
+// Snapshot returns the inner limiter's buckets with the queue depth as Waiters.
+func (q *Queued) Snapshot() Snapshot {
+	s := q.inner.Snapshot()
+	s.Waiters = q.Len()
+	return s
+}
@@ -0,0 +1,230 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForQueue blocks until q has n waiters.
+func waitForQueue(t *testing.T, q *Queued, n int) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if q.Len() == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("queue length = %d, want %d", q.Len(), n)
+}
+
+// advanceUntil advances clock a second at a time until done has a value.
+func advanceUntil[T any](t *testing.T, clock *FakeClock, done <-chan T) T {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		select {
+		case v := <-done:
+			return v
+		default:
+		}
+		clock.Advance(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the queue to drain")
+	var zero T
+	return zero
+}
+
+// newExhaustedQueue returns a Queued limiter around a GCRA limiter of 60
+// tokens per minute with no tokens left.
+func newExhaustedQueue(t *testing.T) (*Queued, *FakeClock) {
+	t.Helper()
+	clock := NewFakeClock(epoch)
+	inner := NewGCRA(60, 0, WithClock(clock))
+	if !inner.TryConsume(60) {
+		t.Fatal("failed to exhaust inner limiter")
+	}
+	return NewQueued(inner, WithClock(clock)), clock
+}
+
+func TestQueued_ServesInOrder(t *testing.T) {
+	q, clock := newExhaustedQueue(t)
+
+	// The large request arrives first and must not be overtaken by the
+	// small ones, even though they would fit sooner
+	waiters := []struct {
+		name     string
+		tokens   int
+		priority int
+	}{
+		{"large", 60, 0},
+		{"small-1", 1, 0},
+		{"small-2", 1, 0},
+		{"urgent", 1, 5},
+	}
+
+	served := make(chan string, len(waiters))
+	var wg sync.WaitGroup
+	for i, w := range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := WithPriority(context.Background(), w.priority)
+			if err := q.WaitAndConsume(ctx, w.tokens, 0); err != nil {
+				t.Errorf("%s: %v", w.name, err)
+			}
+			served <- w.name
+		}()
+		waitForQueue(t, q, i+1)
+	}
+
+	queue := q.Queue()
+	wantQueue := []string{"urgent", "large", "small-1", "small-2"}
+	for i, entry := range queue {
+		if entry.Position != i {
+			t.Errorf("entry %d has position %d", i, entry.Position)
+		}
+	}
+	if queue[0].Priority != 5 || queue[1].Tokens != 60 {
+		t.Errorf("unexpected queue order: %+v", queue)
+	}
+
+	for _, want := range wantQueue {
+		if got := advanceUntil(t, clock, served); got != want {
+			t.Errorf("served %s, want %s", got, want)
+		}
+	}
+	wg.Wait()
+
+	if got := q.Len(); got != 0 {
+		t.Errorf("expected empty queue, got %d", got)
+	}
+}
+
+func TestQueued_TryConsume(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	q := NewQueued(NewGCRA(60, 0, WithClock(clock)), WithClock(clock))
+
+	if !q.TryConsume(60) {
+		t.Fatal("should consume from an empty queue")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.WaitAndConsume(ctx, 60, 0)
+	waitForQueue(t, q, 1)
+
+	// Capacity returns, but the queued caller is ahead of TryConsume
+	clock.Advance(time.Second)
+	if q.TryConsume(1) {
+		t.Error("TryConsume should not jump the queue")
+	}
+}
+
+func TestQueued_Canceled(t *testing.T) {
+	q, _ := newExhaustedQueue(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- q.WaitAndConsume(ctx, 10, 0)
+	}()
+
+	waitForQueue(t, q, 1)
+	if got := q.Snapshot().Waiters; got != 1 {
+		t.Errorf("expected 1 waiter in snapshot, got %d", got)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if got := q.Len(); got != 0 {
+		t.Errorf("expected canceled waiter to leave the queue, got %d", got)
+	}
+}
+
+func TestQueued_Errors(t *testing.T) {
+	q, _ := newExhaustedQueue(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		tokens  int
+		maxWait time.Duration
+		want    error
+	}{
+		{"exceeds capacity", 61, 0, ErrExceedsCapacity},
+		{"exceeds max wait", 10, time.Second, ErrMaxWaitExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := q.WaitAndConsume(ctx, tt.tokens, tt.maxWait); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			if got := q.Len(); got != 0 {
+				t.Errorf("expected empty queue, got %d", got)
+			}
+		})
+	}
+}
+
+func TestQueued_MaxWaitWhileQueued(t *testing.T) {
+	q, clock := newExhaustedQueue(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.WaitAndConsume(ctx, 60, 0)
+	waitForQueue(t, q, 1)
+
+	// Behind a full-bucket request, 10 tokens could never arrive within 5s
+	done := make(chan error, 1)
+	go func() {
+		done <- q.WaitAndConsume(context.Background(), 10, 5*time.Second)
+	}()
+	waitForQueue(t, q, 2)
+
+	if err := advanceUntil(t, clock, done); !errors.Is(err, ErrMaxWaitExceeded) {
+		t.Errorf("expected ErrMaxWaitExceeded, got %v", err)
+	}
+}
+
+func TestQueued_PositionFunc(t *testing.T) {
+	q, clock := newExhaustedQueue(t)
+
+	first := make(chan error, 1)
+	go func() {
+		first <- q.WaitAndConsume(context.Background(), 30, 0)
+	}()
+	waitForQueue(t, q, 1)
+
+	var (
+		positions []int
+		mu        sync.Mutex
+	)
+	ctx := WithPositionFunc(context.Background(), func(position int) {
+		mu.Lock()
+		defer mu.Unlock()
+		positions = append(positions, position)
+	})
+	second := make(chan error, 1)
+	go func() {
+		second <- q.WaitAndConsume(ctx, 30, 0)
+	}()
+	waitForQueue(t, q, 2)
+
+	if err := advanceUntil(t, clock, first); err != nil {
+		t.Fatalf("first: %v", err)
+	}
+	if err := advanceUntil(t, clock, second); err != nil {
+		t.Fatalf("second: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(positions) != 2 || positions[0] != 1 || positions[1] != 0 {
+		t.Errorf("positions = %v, want [1 0]", positions)
+	}
+}
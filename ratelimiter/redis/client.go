@@ -0,0 +1,320 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Client.Do after Close.
+var ErrClosed = errors.New("redis: client closed")
+
+// Doer sends a command to Redis and returns its reply. Replies are decoded as
+// string (simple and bulk strings), int64, []any, nil, or Error.
+//
+// Client implements Doer. Adapt another client (e.g. go-redis) by wrapping
+// its Do method.
+type Doer interface {
+	Do(ctx context.Context, args ...string) (any, error)
+}
+
+// Error is an error reply from Redis.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client is a minimal Redis client speaking RESP over a small pool of
+// connections. It is safe for concurrent use.
+type Client struct {
+	addr string
+	opts clientOptions
+
+	// idle holds connections ready for reuse
+	idle   chan *conn
+	closed atomic.Bool
+}
+
+// Ensure Client implements Doer.
+var _ Doer = (*Client)(nil)
+
+// ClientOption configures a Client.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	password    string
+	db          int
+	poolSize    int
+	dialTimeout time.Duration
+}
+
+// WithPassword authenticates new connections with AUTH.
+func WithPassword(password string) ClientOption {
+	return func(o *clientOptions) {
+		o.password = password
+	}
+}
+
+// WithDB selects the database on new connections. The default is 0.
+func WithDB(db int) ClientOption {
+	return func(o *clientOptions) {
+		o.db = db
+	}
+}
+
+// WithPoolSize sets how many idle connections are kept. The default is 10.
+func WithPoolSize(n int) ClientOption {
+	return func(o *clientOptions) {
+		o.poolSize = n
+	}
+}
+
+// WithDialTimeout sets the timeout for opening a connection. The default is
+// 5 seconds.
+func WithDialTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.dialTimeout = d
+	}
+}
+
+// NewClient creates a Client for the Redis server at addr ("host:port").
+// Connections are opened on demand.
+func NewClient(addr string, opts ...ClientOption) *Client {
+	o := clientOptions{
+		poolSize:    10,
+		dialTimeout: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Client{
+		addr: addr,
+		opts: o,
+		idle: make(chan *conn, max(o.poolSize, 1)),
+	}
+}
+
+// Do sends a command and returns its reply. An error reply is returned as an
+// Error. The command is abandoned, and its connection discarded, when ctx is
+// done.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	if c.closed.Load() {
+		return nil, ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.roundTrip(ctx, args)
+	if err != nil {
+		cn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	c.put(cn)
+
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Close closes the client and its idle connections.
+func (c *Client) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// get returns an idle connection or dials a new one.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: c.opts.dialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: dial %s: %w", c.addr, err)
+	}
+	cn := newConn(nc)
+
+	if err := c.setup(ctx, cn); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// setup authenticates and selects the database on a new connection.
+func (c *Client) setup(ctx context.Context, cn *conn) error {
+	var commands [][]string
+	if c.opts.password != "" {
+		commands = append(commands, []string{"AUTH", c.opts.password})
+	}
+	if c.opts.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(c.opts.db)})
+	}
+
+	for _, args := range commands {
+		reply, err := cn.roundTrip(ctx, args)
+		if err != nil {
+			return err
+		}
+		if e, ok := reply.(Error); ok {
+			return fmt.Errorf("redis: %s: %w", args[0], e)
+		}
+	}
+	return nil
+}
+
+// put returns a connection to the pool, or closes it if the pool is full.
+func (c *Client) put(cn *conn) {
+	if c.closed.Load() {
+		cn.Close()
+		return
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// conn is a single RESP connection.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newConn(nc net.Conn) *conn {
+	return &conn{
+		Conn: nc,
+		r:    bufio.NewReader(nc),
+		w:    bufio.NewWriter(nc),
+	}
+}
+
+// roundTrip writes a command and reads its reply.
+func (cn *conn) roundTrip(ctx context.Context, args []string) (any, error) {
+	deadline, _ := ctx.Deadline()
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Unblock reads and writes as soon as ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		cn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if err := WriteCommand(cn.w, args); err != nil {
+		return nil, err
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return ReadReply(cn.r)
+}
+
+// WriteCommand writes args as a RESP array of bulk strings.
+func WriteCommand(w *bufio.Writer, args []string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return nil
+}
+
+// ReadReply reads one RESP value. See Doer for how values are decoded.
+func ReadReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply line")
+	}
+
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return Error(payload), nil
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad integer %q", payload)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+// readLine reads a CRLF-terminated line without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
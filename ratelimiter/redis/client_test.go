@@ -0,0 +1,85 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter/redis"
+)
+
+func TestClient_Do(t *testing.T) {
+	_, client, _ := newServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		args []string
+		want any
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"SET", "key", "value"}, "OK"},
+		{[]string{"GET", "key"}, "value"},
+		{[]string{"DEL", "key", "missing"}, int64(1)},
+		{[]string{"TIME"}, []any{"1735689600", "0"}},
+	}
+	for _, tt := range tests {
+		got, err := client.Do(ctx, tt.args...)
+		if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v = %#v, want %#v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	srv, client, _ := newServer(t)
+	ctx := context.Background()
+
+	var redisErr redis.Error
+	if _, err := client.Do(ctx, "NOPE"); !errors.As(err, &redisErr) {
+		t.Errorf("expected an error reply, got %v", err)
+	}
+
+	// The connection survives an error reply
+	if _, err := client.Do(ctx, "PING"); err != nil {
+		t.Errorf("PING after error reply: %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.Do(canceled, "PING"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	srv.SetUnavailable(true)
+	if _, err := client.Do(ctx, "PING"); err == nil {
+		t.Error("expected an error while the server is unavailable")
+	}
+	srv.SetUnavailable(false)
+	if _, err := client.Do(ctx, "PING"); err != nil {
+		t.Errorf("PING after recovery: %v", err)
+	}
+
+	client.Close()
+	if _, err := client.Do(ctx, "PING"); !errors.Is(err, redis.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	_, client, _ := newServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+
+	if _, err := client.Do(ctx, "PING"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
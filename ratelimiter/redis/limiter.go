@@ -0,0 +1,457 @@
+// Package redis provides a ratelimiter.Limiter whose state lives in Redis, so
+// that every replica of a service shares one set of limits.
+//
+// Each bucket (tokens per minute, requests per minute, tokens per day) is a
+// GCRA bucket, like ratelimiter.GCRA, stored as a single key. Checks and
+// consumption run in one Lua script, so they are atomic across replicas, and
+// use the Redis server's clock, so replicas need not agree on the time. A
+// limiter's keys share a hash tag, so it also works on Redis Cluster.
+//
+//	client := redis.NewClient("localhost:6379")
+//	manager.SetRateLimiter(model, redis.New(client, "imagegen:"+string(model), redis.Limits{
+//		TokensPerMinute:   1_000_000,
+//		RequestsPerMinute: 360,
+//	}))
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+// ErrUnavailable is returned by WaitAndConsume when Redis cannot be reached
+// and the limiter fails closed.
+var ErrUnavailable = errors.New("redis rate limiter unavailable")
+
+// Script is the Lua script run by Limiter. It is exported so that test
+// servers such as redistest can recognize and emulate it.
+//
+// KEYS are the bucket keys. ARGV[1] is "1" to consume, "0" to only check or
+// "-1" to refund, followed by a limit, period in microseconds and amount for
+// each key. The reply is {wait, now, tat...}: the wait in microseconds before
+// the amounts fit (-1 if one exceeds its limit, 0 if they fit now, always 0
+// for a refund), the server time in microseconds, and each bucket's
+// theoretical arrival time after the call.
+const Script = `
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local consume = ARGV[1] == "1"
+local refund = ARGV[1] == "-1"
+local wait = 0
+local tats = {}
+
+for i, key in ipairs(KEYS) do
+	local limit = tonumber(ARGV[i * 3 - 1])
+	local period = tonumber(ARGV[i * 3])
+	local n = tonumber(ARGV[i * 3 + 1])
+	if n > limit and not refund then
+		return {-1, now}
+	end
+
+	local interval = math.max(math.floor(period / limit), 1)
+	local tat = math.max(tonumber(redis.call("GET", key) or 0), now)
+	if refund then
+		tats[i] = math.max(tat - interval * n, now)
+	else
+		tats[i] = tat + interval * n
+		wait = math.max(wait, tats[i] - now - interval * limit)
+	end
+end
+
+if refund or (consume and wait == 0) then
+	for i, key in ipairs(KEYS) do
+		local ttl = math.max(math.ceil((tats[i] - now) / 1000), 1)
+		redis.call("SET", key, string.format("%d", tats[i]), "PX", ttl)
+	end
+end
+
+local reply = {wait, now}
+for i = 1, #tats do
+	reply[i + 2] = tats[i]
+end
+return reply
+`
+
+// scriptSHA is the SHA1 used to run Script with EVALSHA.
+var scriptSHA = func() string {
+	sum := sha1.Sum([]byte(Script))
+	return hex.EncodeToString(sum[:])
+}()
+
+// Limits are the limits shared by every Limiter using the same key.
+// A limit of zero or less disables that bucket.
+type Limits struct {
+	TokensPerMinute   int
+	RequestsPerMinute int
+	TokensPerDay      int
+}
+
+// FailurePolicy decides what a Limiter does when Redis cannot be reached.
+type FailurePolicy int
+
+const (
+	// FailOpen allows requests while Redis is unreachable. Providers still
+	// enforce their own quotas, so this trades exactness for availability.
+	FailOpen FailurePolicy = iota
+
+	// FailClosed refuses requests while Redis is unreachable.
+	FailClosed
+)
+
+// Option configures a Limiter.
+type Option func(*Limiter)
+
+// WithFailurePolicy sets what happens when Redis cannot be reached. The
+// default is FailOpen.
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(l *Limiter) {
+		l.policy = policy
+	}
+}
+
+// WithErrorHandler sets a function called with every error talking to Redis,
+// for logging or metrics.
+func WithErrorHandler(fn func(error)) Option {
+	return func(l *Limiter) {
+		l.onError = fn
+	}
+}
+
+// WithTimeout sets the timeout for Redis calls made without a caller's
+// context (TryConsume, TimeUntilAvailable and Snapshot). The default is one
+// second.
+func WithTimeout(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.timeout = d
+	}
+}
+
+// WithRetryInterval sets the wait TimeUntilAvailable reports while Redis is
+// unreachable and the limiter fails closed. The default is one second.
+func WithRetryInterval(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.retryInterval = d
+	}
+}
+
+// WithClock sets the clock used to sleep in WaitAndConsume. Bucket state
+// always uses the Redis server's clock.
+func WithClock(clock ratelimiter.Clock) Option {
+	return func(l *Limiter) {
+		l.clock = clock
+	}
+}
+
+// Limiter is a ratelimiter.Limiter backed by Redis.
+type Limiter struct {
+	client  Doer
+	buckets []bucket
+
+	policy        FailurePolicy
+	onError       func(error)
+	timeout       time.Duration
+	retryInterval time.Duration
+	clock         ratelimiter.Clock
+
+	// waiters counts goroutines blocked in WaitAndConsume in this process
+	waiters atomic.Int64
+}
+
+// Ensure Limiter implements ratelimiter.Limiter, ratelimiter.Refunder and
+// ratelimiter.WaitCounter.
+var (
+	_ ratelimiter.Limiter     = (*Limiter)(nil)
+	_ ratelimiter.Refunder    = (*Limiter)(nil)
+	_ ratelimiter.WaitCounter = (*Limiter)(nil)
+)
+
+// Script modes, passed as ARGV[1].
+const (
+	modeCheck   = "0"
+	modeConsume = "1"
+	modeRefund  = "-1"
+)
+
+// bucket is one limit, stored under key.
+type bucket struct {
+	name   string
+	key    string
+	limit  int
+	period time.Duration
+
+	// perRequest buckets count requests rather than tokens
+	perRequest bool
+}
+
+// New creates a Limiter storing its buckets under keys of the form
+// "{prefix}:tokens". Limiters with the same prefix share limits, so use one
+// prefix per model. The braces make prefix a Redis Cluster hash tag, so a
+// limiter's keys share a slot and its script can run on a cluster.
+func New(client Doer, prefix string, limits Limits, opts ...Option) *Limiter {
+	l := &Limiter{
+		client:        client,
+		timeout:       time.Second,
+		retryInterval: time.Second,
+		clock:         ratelimiter.SystemClock,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	candidates := []bucket{
+		{name: ratelimiter.BucketTokens, limit: limits.TokensPerMinute, period: time.Minute},
+		{name: ratelimiter.BucketRequests, limit: limits.RequestsPerMinute, period: time.Minute, perRequest: true},
+		{name: ratelimiter.BucketDailyTokens, limit: limits.TokensPerDay, period: 24 * time.Hour},
+	}
+	for _, b := range candidates {
+		if b.limit > 0 {
+			b.key = "{" + prefix + "}:" + b.name
+			l.buckets = append(l.buckets, b)
+		}
+	}
+
+	return l
+}
+
+// TryConsume consumes tokens and one request if every bucket has capacity.
+// If Redis cannot be reached it returns true when failing open.
+func (l *Limiter) TryConsume(numTokens int) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	res, err := l.eval(ctx, numTokens, 1, modeConsume)
+	if err != nil {
+		return l.policy == FailOpen
+	}
+	return res.wait == 0
+}
+
+// Refund returns tokens and one request to the buckets, for a request that
+// was consumed but never sent. Errors reaching Redis are only reported to the
+// error handler.
+func (l *Limiter) Refund(tokens int) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	l.eval(ctx, tokens, 1, modeRefund)
+}
+
+// TimeUntilAvailable returns how long until tokens and one request could be
+// consumed, or -1 if the request exceeds a bucket's capacity. If Redis cannot
+// be reached it returns 0 when failing open and the retry interval otherwise.
+func (l *Limiter) TimeUntilAvailable(tokens int) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	res, err := l.eval(ctx, tokens, 1, modeCheck)
+	if err != nil {
+		if l.policy == FailOpen {
+			return 0
+		}
+		return l.retryInterval
+	}
+	if res.wait < 0 {
+		return -1
+	}
+	return res.wait
+}
+
+// WaitAndConsume waits until tokens are available (up to maxWait), then
+// consumes them. If maxWait is 0, there is no limit on how long to wait.
+// If Redis cannot be reached it returns nil when failing open and
+// ErrUnavailable otherwise.
+func (l *Limiter) WaitAndConsume(ctx context.Context, tokens int, maxWait time.Duration) error {
+	var deadline time.Time
+	if maxWait > 0 {
+		deadline = l.clock.Now().Add(maxWait)
+	}
+
+	for {
+		res, err := l.eval(ctx, tokens, 1, modeConsume)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if l.policy == FailOpen {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+
+		wait := res.wait
+		if wait == 0 {
+			return nil
+		}
+		if wait < 0 {
+			return fmt.Errorf("%w: %d tokens", ratelimiter.ErrExceedsCapacity, tokens)
+		}
+		if !deadline.IsZero() && l.clock.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("%w: need %v, max %v", ratelimiter.ErrMaxWaitExceeded, wait, maxWait)
+		}
+
+		if err := l.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// AddWaiters adds delta to the count of waiters in this process.
+func (l *Limiter) AddWaiters(delta int) {
+	l.waiters.Add(int64(delta))
+}
+
+// sleep blocks for d or until ctx is done.
+func (l *Limiter) sleep(ctx context.Context, d time.Duration) error {
+	l.waiters.Add(1)
+	defer l.waiters.Add(-1)
+
+	timer := l.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// Snapshot returns the state of each enabled bucket as seen by Redis. If
+// Redis cannot be reached, only Waiters is set. Waiters only counts callers
+// in this process.
+func (l *Limiter) Snapshot() ratelimiter.Snapshot {
+	s := ratelimiter.Snapshot{Waiters: int(l.waiters.Load())}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	res, err := l.eval(ctx, 0, 0, modeCheck)
+	if err != nil || len(res.tats) != len(l.buckets) {
+		return s
+	}
+
+	for i, b := range l.buckets {
+		s.Buckets = append(s.Buckets, b.snapshot(res.now, res.tats[i]))
+	}
+	return s
+}
+
+// snapshot returns the bucket's state given the server time and its
+// theoretical arrival time, both in microseconds.
+func (b bucket) snapshot(now, tat int64) ratelimiter.BucketSnapshot {
+	interval := max(b.period.Microseconds()/int64(b.limit), 1)
+	burst := interval * int64(b.limit)
+	used := max(tat-now, 0)
+	remaining := min(int((burst-used)/interval), b.limit)
+
+	s := ratelimiter.BucketSnapshot{
+		Name:      b.name,
+		Capacity:  b.limit,
+		Remaining: remaining,
+	}
+	if remaining < b.limit {
+		// The next unit is regained once used drops to the start of the
+		// next slot
+		s.NextRefill = time.UnixMicro(now + used - (burst - int64(remaining+1)*interval))
+	}
+	return s
+}
+
+// result is a decoded Script reply.
+type result struct {
+	wait time.Duration // -1 if the request exceeds capacity
+	now  int64
+	tats []int64
+}
+
+// eval runs Script for tokens and requests in mode.
+func (l *Limiter) eval(ctx context.Context, tokens, requests int, mode string) (result, error) {
+	if len(l.buckets) == 0 {
+		return result{}, nil
+	}
+
+	keys := make([]string, len(l.buckets))
+	args := []string{mode}
+	for i, b := range l.buckets {
+		keys[i] = b.key
+
+		n := tokens
+		if b.perRequest {
+			n = requests
+		}
+		args = append(args,
+			strconv.Itoa(b.limit),
+			strconv.FormatInt(b.period.Microseconds(), 10),
+			strconv.Itoa(n))
+	}
+
+	reply, err := l.run(ctx, keys, args)
+	if err != nil {
+		l.reportError(err)
+		return result{}, err
+	}
+
+	res, err := parseResult(reply)
+	if err != nil {
+		l.reportError(err)
+		return result{}, err
+	}
+	return res, nil
+}
+
+// run calls Script with EVALSHA, falling back to EVAL if Redis has not
+// cached it yet.
+func (l *Limiter) run(ctx context.Context, keys, args []string) (any, error) {
+	command := func(name, script string) []string {
+		c := []string{name, script, strconv.Itoa(len(keys))}
+		c = append(c, keys...)
+		return append(c, args...)
+	}
+
+	reply, err := l.client.Do(ctx, command("EVALSHA", scriptSHA)...)
+	var redisErr Error
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+		reply, err = l.client.Do(ctx, command("EVAL", Script)...)
+	}
+	return reply, err
+}
+
+func (l *Limiter) reportError(err error) {
+	if l.onError != nil {
+		l.onError(err)
+	}
+}
+
+// parseResult decodes a Script reply.
+func parseResult(reply any) (result, error) {
+	values, ok := reply.([]any)
+	if !ok || len(values) < 2 {
+		return result{}, fmt.Errorf("redis: unexpected script reply %v", reply)
+	}
+
+	ints := make([]int64, len(values))
+	for i, v := range values {
+		n, ok := v.(int64)
+		if !ok {
+			return result{}, fmt.Errorf("redis: unexpected script reply %v", reply)
+		}
+		ints[i] = n
+	}
+
+	res := result{now: ints[1], tats: ints[2:]}
+	if ints[0] < 0 {
+		res.wait = -1
+	} else {
+		res.wait = time.Duration(ints[0]) * time.Microsecond
+	}
+	return res, nil
+}
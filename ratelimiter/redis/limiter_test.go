@@ -0,0 +1,256 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter"
+	"github.com/mhpenta/imagegen/ratelimiter/redis"
+	"github.com/mhpenta/imagegen/ratelimiter/redis/redistest"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newServer starts a redistest.Server on a fake clock and returns a client
+// for it.
+func newServer(t *testing.T) (*redistest.Server, *redis.Client, *ratelimiter.FakeClock) {
+	t.Helper()
+
+	clock := ratelimiter.NewFakeClock(epoch)
+	srv := redistest.NewServer(redistest.WithClock(clock))
+	t.Cleanup(func() { srv.Close() })
+
+	client := redis.NewClient(srv.Addr())
+	t.Cleanup(func() { client.Close() })
+
+	return srv, client, clock
+}
+
+func TestLimiter_TryConsume(t *testing.T) {
+	srv, client, _ := newServer(t)
+	limits := redis.Limits{TokensPerMinute: 100, RequestsPerMinute: 3, TokensPerDay: 150}
+
+	// Two replicas sharing a prefix share the limits
+	a := redis.New(client, "model", limits)
+	b := redis.New(client, "model", limits)
+
+	steps := []struct {
+		name    string
+		limiter *redis.Limiter
+		tokens  int
+		want    bool
+	}{
+		{"first replica", a, 60, true},
+		{"tokens exhausted across replicas", b, 60, false},
+		{"second replica", b, 40, true},
+		{"requests exhausted", a, 0, true},
+		{"requests exhausted across replicas", b, 0, false},
+	}
+	for _, step := range steps {
+		if got := step.limiter.TryConsume(step.tokens); got != step.want {
+			t.Errorf("%s: TryConsume(%d) = %v, want %v", step.name, step.tokens, got, step.want)
+		}
+	}
+
+	// The prefix is a hash tag, so a limiter's keys share a cluster slot
+	for _, key := range []string{"{model}:tokens", "{model}:requests", "{model}:daily_tokens"} {
+		if _, ok := srv.Get(key); !ok {
+			t.Errorf("no key %s", key)
+		}
+	}
+
+	// An unrelated prefix has its own buckets
+	if !redis.New(client, "other", limits).TryConsume(100) {
+		t.Error("a different prefix should not share limits")
+	}
+}
+
+func TestLimiter_DailyLimit(t *testing.T) {
+	_, client, clock := newServer(t)
+	limiter := redis.New(client, "model", redis.Limits{TokensPerMinute: 100, TokensPerDay: 150})
+
+	if !limiter.TryConsume(100) {
+		t.Fatal("failed to consume from full buckets")
+	}
+
+	// The minute bucket refills, but the daily bucket does not
+	clock.Advance(time.Minute)
+	if limiter.TryConsume(100) {
+		t.Error("daily limit should refuse after a minute")
+	}
+	if !limiter.TryConsume(50) {
+		t.Error("daily limit should allow what is left of the day")
+	}
+
+	wait := limiter.TimeUntilAvailable(50)
+	if want := 8*time.Hour - time.Minute; wait != want {
+		t.Errorf("TimeUntilAvailable(50) = %v, want %v", wait, want)
+	}
+	if got := limiter.TimeUntilAvailable(151); got != -1 {
+		t.Errorf("TimeUntilAvailable over daily capacity = %v, want -1", got)
+	}
+}
+
+func TestLimiter_Refund(t *testing.T) {
+	_, client, _ := newServer(t)
+	limiter := redis.New(client, "model", redis.Limits{TokensPerMinute: 100, RequestsPerMinute: 1})
+
+	if !limiter.TryConsume(80) {
+		t.Fatal("failed to consume from full buckets")
+	}
+	if limiter.TryConsume(80) {
+		t.Fatal("expected the buckets to be exhausted")
+	}
+
+	limiter.Refund(80)
+	if !limiter.TryConsume(100) {
+		t.Error("refunded tokens and request should be available again")
+	}
+
+	// A refund never takes a bucket above full
+	tokensOnly := redis.New(client, "tokens", redis.Limits{TokensPerMinute: 100})
+	tokensOnly.Refund(50)
+	if !tokensOnly.TryConsume(100) || tokensOnly.TryConsume(1) {
+		t.Error("a refund to a full bucket should not add capacity")
+	}
+}
+
+func TestLimiter_LoadsScript(t *testing.T) {
+	srv, client, _ := newServer(t)
+	limiter := redis.New(client, "model", redis.Limits{RequestsPerMinute: 10})
+
+	limiter.TryConsume(1)
+	limiter.TryConsume(1)
+
+	// The first call falls back to EVAL; after that the cached script is used
+	if got := srv.Calls("EVAL"); got != 1 {
+		t.Errorf("EVAL calls = %d, want 1", got)
+	}
+	if got := srv.Calls("EVALSHA"); got != 2 {
+		t.Errorf("EVALSHA calls = %d, want 2", got)
+	}
+
+	srv.FlushAll()
+	if !limiter.TryConsume(1) {
+		t.Error("TryConsume should reload the script after a flush")
+	}
+}
+
+func TestLimiter_WaitAndConsume(t *testing.T) {
+	_, client, clock := newServer(t)
+	limiter := redis.New(client, "model", redis.Limits{TokensPerMinute: 60}, redis.WithClock(clock))
+	limiter.TryConsume(60)
+
+	ctx := context.Background()
+	if err := limiter.WaitAndConsume(ctx, 10, time.Second); !errors.Is(err, ratelimiter.ErrMaxWaitExceeded) {
+		t.Errorf("expected ErrMaxWaitExceeded, got %v", err)
+	}
+	if err := limiter.WaitAndConsume(ctx, 61, 0); !errors.Is(err, ratelimiter.ErrExceedsCapacity) {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- limiter.WaitAndConsume(ctx, 10, 0)
+	}()
+
+	clock.BlockUntil(1)
+	if got := limiter.Snapshot().Waiters; got != 1 {
+		t.Errorf("expected 1 waiter, got %d", got)
+	}
+	clock.Advance(10 * time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("WaitAndConsume: %v", err)
+	}
+}
+
+func TestLimiter_Unavailable(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      redis.FailurePolicy
+		wantConsume bool
+		wantWait    time.Duration
+		wantErr     error
+	}{
+		{"fail open", redis.FailOpen, true, 0, nil},
+		{"fail closed", redis.FailClosed, false, 5 * time.Second, redis.ErrUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client, _ := newServer(t)
+
+			var errs int
+			limiter := redis.New(client, "model", redis.Limits{RequestsPerMinute: 1},
+				redis.WithFailurePolicy(tt.policy),
+				redis.WithRetryInterval(5*time.Second),
+				redis.WithErrorHandler(func(error) { errs++ }),
+			)
+			limiter.TryConsume(1)
+
+			srv.SetUnavailable(true)
+			if got := limiter.TryConsume(1); got != tt.wantConsume {
+				t.Errorf("TryConsume = %v, want %v", got, tt.wantConsume)
+			}
+			if got := limiter.TimeUntilAvailable(1); got != tt.wantWait {
+				t.Errorf("TimeUntilAvailable = %v, want %v", got, tt.wantWait)
+			}
+			if err := limiter.WaitAndConsume(context.Background(), 1, 0); !errors.Is(err, tt.wantErr) {
+				t.Errorf("WaitAndConsume = %v, want %v", err, tt.wantErr)
+			}
+			if errs != 3 {
+				t.Errorf("error handler called %d times, want 3", errs)
+			}
+
+			// Limits apply again once Redis is back
+			srv.SetUnavailable(false)
+			if limiter.TryConsume(1) {
+				t.Error("TryConsume should be limited once Redis recovers")
+			}
+		})
+	}
+}
+
+func TestLimiter_Snapshot(t *testing.T) {
+	_, client, clock := newServer(t)
+	limiter := redis.New(client, "model", redis.Limits{TokensPerMinute: 100, RequestsPerMinute: 10})
+
+	limiter.TryConsume(30)
+	snapshot := limiter.Snapshot()
+
+	tests := []struct {
+		bucket        string
+		wantCapacity  int
+		wantRemaining int
+	}{
+		{ratelimiter.BucketTokens, 100, 70},
+		{ratelimiter.BucketRequests, 10, 9},
+	}
+	for _, tt := range tests {
+		b, ok := snapshot.Bucket(tt.bucket)
+		if !ok {
+			t.Errorf("no %s bucket in snapshot", tt.bucket)
+			continue
+		}
+		if b.Capacity != tt.wantCapacity || b.Remaining != tt.wantRemaining {
+			t.Errorf("%s: got capacity %d remaining %d, want %d and %d",
+				tt.bucket, b.Capacity, b.Remaining, tt.wantCapacity, tt.wantRemaining)
+		}
+	}
+	if _, ok := snapshot.Bucket(ratelimiter.BucketDailyTokens); ok {
+		t.Error("disabled daily bucket should not be in snapshot")
+	}
+
+	// A token is regained every 600ms
+	tokens, _ := snapshot.Bucket(ratelimiter.BucketTokens)
+	if want := epoch.Add(600 * time.Millisecond); !tokens.NextRefill.Equal(want) {
+		t.Errorf("next refill = %v, want %v", tokens.NextRefill, want)
+	}
+
+	clock.Advance(time.Minute)
+	tokens, _ = limiter.Snapshot().Bucket(ratelimiter.BucketTokens)
+	if tokens.Remaining != 100 || !tokens.NextRefill.IsZero() {
+		t.Errorf("expected full bucket after a minute, got %+v", tokens)
+	}
+}
@@ -0,0 +1,415 @@
+// Package redistest provides an in-process Redis stand-in for testing code
+// that uses the ratelimiter/redis package.
+//
+// Server speaks RESP and supports the handful of commands the limiter and
+// Client use. It cannot run Lua; instead it recognizes redis.Script and
+// emulates it in Go.
+package redistest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter"
+	"github.com/mhpenta/imagegen/ratelimiter/redis"
+)
+
+// Server is an in-process Redis stand-in listening on a local port.
+type Server struct {
+	listener net.Listener
+	clock    ratelimiter.Clock
+
+	data        map[string]entry
+	scripts     map[string]string // SHA1 to source, for EVALSHA
+	calls       map[string]int
+	conns       map[net.Conn]struct{}
+	unavailable bool
+
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+type entry struct {
+	value    string
+	expireAt time.Time // zero if the key does not expire
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithClock sets the clock used for TIME and key expiry. The default is
+// ratelimiter.SystemClock.
+func WithClock(clock ratelimiter.Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// NewServer starts a Server on a random local port. It panics if it cannot
+// listen, like httptest.NewServer. Call Close when done.
+func NewServer(opts ...Option) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("redistest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		listener: listener,
+		clock:    ratelimiter.SystemClock,
+		data:     make(map[string]entry),
+		scripts:  make(map[string]string),
+		calls:    make(map[string]int),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// Addr returns the server's address, for redis.NewClient.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and closes all connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// SetUnavailable simulates an outage: while unavailable, open connections
+// are dropped and new ones are closed as soon as they are accepted.
+func (s *Server) SetUnavailable(unavailable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unavailable = unavailable
+	if unavailable {
+		for c := range s.conns {
+			c.Close()
+		}
+	}
+}
+
+// Calls returns how many times a command (e.g. "EVALSHA") has been received.
+func (s *Server) Calls(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[strings.ToUpper(command)]
+}
+
+// Get returns the value of key and whether it exists.
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key)
+}
+
+// FlushAll deletes every key and cached script.
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.data)
+	clear(s.scripts)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.unavailable {
+			s.mu.Unlock()
+			c.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// handle serves commands on one connection until it is closed.
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		request, err := redis.ReadReply(r)
+		if err != nil {
+			return
+		}
+		args, ok := stringArgs(request)
+		if !ok || len(args) == 0 {
+			writeReply(w, redis.Error("ERR protocol error: expected array of bulk strings"))
+		} else {
+			writeReply(w, s.execute(args))
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs one command and returns its reply.
+func (s *Server) execute(args []string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	command := strings.ToUpper(args[0])
+	s.calls[command]++
+
+	switch command {
+	case "PING":
+		return "PONG"
+	case "AUTH", "SELECT":
+		return "OK"
+	case "TIME":
+		now := s.clock.Now()
+		return []any{strconv.FormatInt(now.Unix(), 10), strconv.Itoa(now.Nanosecond() / 1000)}
+	case "GET":
+		if len(args) != 2 {
+			return wrongArgs(command)
+		}
+		if v, ok := s.get(args[1]); ok {
+			return v
+		}
+		return nil
+	case "SET":
+		return s.set(args[1:])
+	case "DEL":
+		n := int64(0)
+		for _, key := range args[1:] {
+			if _, ok := s.get(key); ok {
+				delete(s.data, key)
+				n++
+			}
+		}
+		return n
+	case "FLUSHALL":
+		clear(s.data)
+		clear(s.scripts)
+		return "OK"
+	case "SCRIPT":
+		if len(args) != 3 || strings.ToUpper(args[1]) != "LOAD" {
+			return redis.Error("ERR only SCRIPT LOAD is supported")
+		}
+		return s.load(args[2])
+	case "EVAL":
+		if len(args) < 3 {
+			return wrongArgs(command)
+		}
+		s.load(args[1])
+		return s.eval(args[1], args[2:])
+	case "EVALSHA":
+		if len(args) < 3 {
+			return wrongArgs(command)
+		}
+		script, ok := s.scripts[strings.ToLower(args[1])]
+		if !ok {
+			return redis.Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return s.eval(script, args[2:])
+	default:
+		return redis.Error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+// get returns a key's value if it exists and has not expired. Must be called
+// while holding s.mu.
+func (s *Server) get(key string) (string, bool) {
+	e, ok := s.data[key]
+	if !ok {
+		return "", false
+	}
+	if !e.expireAt.IsZero() && !s.clock.Now().Before(e.expireAt) {
+		delete(s.data, key)
+		return "", false
+	}
+	return e.value, true
+}
+
+// set implements SET key value [PX milliseconds].
+func (s *Server) set(args []string) any {
+	if len(args) != 2 && len(args) != 4 {
+		return wrongArgs("SET")
+	}
+
+	e := entry{value: args[1]}
+	if len(args) == 4 {
+		if strings.ToUpper(args[2]) != "PX" {
+			return redis.Error("ERR only the PX option is supported")
+		}
+		ms, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || ms <= 0 {
+			return redis.Error("ERR invalid expire time in 'set' command")
+		}
+		e.expireAt = s.clock.Now().Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	s.data[args[0]] = e
+	return "OK"
+}
+
+// load caches a script for EVALSHA and returns its SHA1.
+func (s *Server) load(script string) string {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+	s.scripts[sha] = script
+	return sha
+}
+
+// eval runs a script given "numkeys key... arg...". Only redis.Script is
+// supported.
+func (s *Server) eval(script string, args []string) any {
+	if script != redis.Script {
+		return redis.Error("ERR redistest only supports redis.Script")
+	}
+
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 || numKeys > len(args)-1 {
+		return redis.Error("ERR Number of keys can't be greater than number of args")
+	}
+	keys, argv := args[1:1+numKeys], args[1+numKeys:]
+
+	reply, err := s.limiterScript(keys, argv)
+	if err != nil {
+		return redis.Error("ERR " + err.Error())
+	}
+	return reply
+}
+
+// limiterScript is a Go translation of redis.Script.
+func (s *Server) limiterScript(keys, argv []string) (any, error) {
+	if len(argv) != 1+3*len(keys) {
+		return nil, errors.New("wrong number of script arguments")
+	}
+
+	ints := make([]int64, len(argv)-1)
+	for i, arg := range argv[1:] {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad script argument %q", arg)
+		}
+		ints[i] = n
+	}
+
+	now := s.clock.Now().UnixMicro()
+	consume := argv[0] == "1"
+	refund := argv[0] == "-1"
+	wait := int64(0)
+	tats := make([]int64, len(keys))
+
+	for i, key := range keys {
+		limit, period, n := ints[i*3], ints[i*3+1], ints[i*3+2]
+		if n > limit && !refund {
+			return []any{int64(-1), now}, nil
+		}
+
+		interval := max(period/limit, 1)
+		tat := now
+		if v, ok := s.get(key); ok {
+			stored, _ := strconv.ParseInt(v, 10, 64)
+			tat = max(stored, now)
+		}
+		if refund {
+			tats[i] = max(tat-interval*n, now)
+		} else {
+			tats[i] = tat + interval*n
+			wait = max(wait, tats[i]-now-interval*limit)
+		}
+	}
+
+	if refund || (consume && wait == 0) {
+		for i, key := range keys {
+			ttl := max((tats[i]-now+999)/1000, 1)
+			s.data[key] = entry{
+				value:    strconv.FormatInt(tats[i], 10),
+				expireAt: s.clock.Now().Add(time.Duration(ttl) * time.Millisecond),
+			}
+		}
+	}
+
+	reply := []any{wait, now}
+	for _, tat := range tats {
+		reply = append(reply, tat)
+	}
+	return reply, nil
+}
+
+func wrongArgs(command string) redis.Error {
+	return redis.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+}
+
+// stringArgs converts a decoded request to its arguments.
+func stringArgs(request any) ([]string, bool) {
+	values, ok := request.([]any)
+	if !ok {
+		return nil, false
+	}
+	args := make([]string, len(values))
+	for i, v := range values {
+		if args[i], ok = v.(string); !ok {
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+// writeReply encodes a reply as RESP.
+func writeReply(w io.Writer, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		io.WriteString(w, "$-1\r\n")
+	case redis.Error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("redistest: unsupported reply type %T", reply))
+	}
+}
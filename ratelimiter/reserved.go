@@ -0,0 +1,192 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HighPriority is the lowest priority that may use a Reserved limiter's
+// reserved capacity.
+const HighPriority = 1
+
+// bucketPrefixUnreserved prefixes the names of the unreserved limiter's
+// buckets in a Reserved limiter's snapshot.
+const bucketPrefixUnreserved = "unreserved_"
+
+// Reserved is a Limiter that holds back part of its capacity for
+// high-priority callers.
+//
+// Every caller consumes from the shared limiter, which has the full capacity.
+// Callers below HighPriority must also consume from the unreserved limiter,
+// which is sized to the capacity they may use, so they can never take what
+// is reserved. If the shared limiter's estimate is optimistic (as with
+// RateLimiter), a low-priority request can still be admitted by the
+// unreserved limiter and then refused; the unreserved capacity is refunded
+// if the unreserved limiter is a Refunder, and the reserve is never at risk.
+type Reserved struct {
+	shared     Limiter
+	unreserved Limiter
+	clock      Clock
+
+	// waiters counts goroutines blocked in WaitAndConsume
+	waiters atomic.Int64
+
+	mu sync.Mutex
+}
+
+// Ensure Reserved implements PriorityLimiter, PriorityRefunder and
+// WaitCounter.
+var (
+	_ PriorityLimiter  = (*Reserved)(nil)
+	_ PriorityRefunder = (*Reserved)(nil)
+	_ WaitCounter      = (*Reserved)(nil)
+)
+
+// NewReserved creates a Reserved limiter. shared limits all traffic;
+// unreserved additionally limits traffic below HighPriority and should have
+// a fraction of shared's capacity.
+func NewReserved(shared, unreserved Limiter, opts ...Option) *Reserved {
+	o := applyOptions(opts)
+	return &Reserved{
+		shared:     shared,
+		unreserved: unreserved,
+		clock:      o.clock,
+	}
+}
+
+// TryConsume consumes on behalf of a priority 0 caller, which may not use
+// reserved capacity.
+func (r *Reserved) TryConsume(numTokens int) bool {
+	return r.TryConsumePriority(numTokens, 0)
+}
+
+// TryConsumePriority consumes tokens and one request if the capacity
+// available to priority allows it.
+func (r *Reserved) TryConsumePriority(numTokens, priority int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if priority >= HighPriority {
+		return r.shared.TryConsume(numTokens)
+	}
+
+	// Check the shared limiter first so a refusal does not waste
+	// unreserved capacity
+	if r.shared.TimeUntilAvailable(numTokens) != 0 || !r.unreserved.TryConsume(numTokens) {
+		return false
+	}
+	if !r.shared.TryConsume(numTokens) {
+		RefundWithPriority(r.unreserved, numTokens, 0)
+		return false
+	}
+	return true
+}
+
+// Refund returns what a priority 0 caller consumed.
+func (r *Reserved) Refund(tokens int) {
+	r.RefundPriority(tokens, 0)
+}
+
+// RefundPriority returns tokens and one request consumed by a caller with
+// priority: to the shared limiter and, below HighPriority, to the unreserved
+// limiter. Limiters that cannot refund keep the capacity.
+func (r *Reserved) RefundPriority(tokens, priority int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	RefundWithPriority(r.shared, tokens, 0)
+	if priority < HighPriority {
+		RefundWithPriority(r.unreserved, tokens, 0)
+	}
+}
+
+// TimeUntilAvailable returns the wait for a priority 0 caller.
+func (r *Reserved) TimeUntilAvailable(tokens int) time.Duration {
+	return r.TimeUntilAvailablePriority(tokens, 0)
+}
+
+// TimeUntilAvailablePriority returns how long until a caller with priority
+// could consume tokens. It returns -1 if the request exceeds the capacity
+// available to priority.
+func (r *Reserved) TimeUntilAvailablePriority(tokens, priority int) time.Duration {
+	wait := r.shared.TimeUntilAvailable(tokens)
+	if priority >= HighPriority || wait < 0 {
+		return wait
+	}
+
+	unreservedWait := r.unreserved.TimeUntilAvailable(tokens)
+	if unreservedWait < 0 {
+		return -1
+	}
+	return max(wait, unreservedWait)
+}
+
+// WaitAndConsume waits until tokens are available to the caller's priority
+// (see WithPriority), then consumes them. If maxWait is 0, there is no limit
+// on how long to wait.
+func (r *Reserved) WaitAndConsume(ctx context.Context, tokens int, maxWait time.Duration) error {
+	priority := PriorityFromContext(ctx)
+
+	var deadline time.Time
+	if maxWait > 0 {
+		deadline = r.clock.Now().Add(maxWait)
+	}
+
+	for {
+		if r.TryConsumePriority(tokens, priority) {
+			return nil
+		}
+
+		wait := r.TimeUntilAvailablePriority(tokens, priority)
+		if wait < 0 {
+			return fmt.Errorf("%w: %d tokens", ErrExceedsCapacity, tokens)
+		}
+		if wait == 0 {
+			// The inner limiters' estimates can be optimistic
+			wait = minRetryInterval
+		}
+		if !deadline.IsZero() && r.clock.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("%w: need %v, max %v", ErrMaxWaitExceeded, wait, maxWait)
+		}
+
+		if err := r.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// AddWaiters adds delta to the count of waiters.
+func (r *Reserved) AddWaiters(delta int) {
+	r.waiters.Add(int64(delta))
+}
+
+// sleep blocks for d or until ctx is done.
+func (r *Reserved) sleep(ctx context.Context, d time.Duration) error {
+	r.waiters.Add(1)
+	defer r.waiters.Add(-1)
+
+	timer := r.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// Snapshot returns the shared limiter's buckets, followed by the unreserved
+// limiter's buckets with their names prefixed by "unreserved_".
+func (r *Reserved) Snapshot() Snapshot {
+	s := r.shared.Snapshot()
+	for _, b := range r.unreserved.Snapshot().Buckets {
+		b.Name = bucketPrefixUnreserved + b.Name
+		s.Buckets = append(s.Buckets, b)
+	}
+	s.Waiters = int(r.waiters.Load())
+	return s
+}
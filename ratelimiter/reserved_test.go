@@ -0,0 +1,154 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newReserved returns a Reserved limiter of 100 tokens per minute with 20
+// reserved for high priority.
+func newReserved() (*Reserved, *FakeClock) {
+	clock := NewFakeClock(epoch)
+	r := NewReserved(
+		NewGCRA(100, 0, WithClock(clock)),
+		NewGCRA(80, 0, WithClock(clock)),
+		WithClock(clock),
+	)
+	return r, clock
+}
+
+func TestReserved_TryConsumePriority(t *testing.T) {
+	r, _ := newReserved()
+
+	steps := []struct {
+		name     string
+		tokens   int
+		priority int
+		want     bool
+	}{
+		{"low uses unreserved capacity", 80, 0, true},
+		{"low cannot use reserve", 1, 0, false},
+		{"negative priority cannot use reserve", 1, -1, false},
+		{"high uses reserve", 20, HighPriority, true},
+		{"high cannot exceed total", 1, HighPriority, false},
+	}
+	for _, step := range steps {
+		if got := r.TryConsumePriority(step.tokens, step.priority); got != step.want {
+			t.Errorf("%s: TryConsumePriority(%d, %d) = %v, want %v",
+				step.name, step.tokens, step.priority, got, step.want)
+		}
+	}
+}
+
+func TestReserved_RefundsUnreservedOnRefusal(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	unreserved := NewGCRA(80, 0, WithClock(clock))
+	r := NewReserved(racyLimiter{NewGCRA(100, 0, WithClock(clock))}, unreserved, WithClock(clock))
+
+	// The shared limiter reports capacity, then refuses every consume
+	for range 5 {
+		if r.TryConsume(40) {
+			t.Fatal("TryConsume succeeded with a refusing shared limiter")
+		}
+	}
+	if b, _ := unreserved.Snapshot().Bucket(BucketTokens); b.Remaining != 80 {
+		t.Errorf("unreserved tokens remaining = %d, want 80", b.Remaining)
+	}
+}
+
+func TestReserved_HighPriorityIgnoresUnreservedUsage(t *testing.T) {
+	r, _ := newReserved()
+
+	if !r.TryConsumePriority(50, HighPriority) {
+		t.Fatal("high priority should consume from a full limiter")
+	}
+
+	// High-priority traffic counts against the total, leaving low priority
+	// with only what is left
+	if r.TryConsume(60) {
+		t.Error("low priority should not consume beyond the remaining total")
+	}
+	if !r.TryConsume(50) {
+		t.Error("low priority should consume the remaining unreserved capacity")
+	}
+}
+
+func TestReserved_TimeUntilAvailablePriority(t *testing.T) {
+	r, _ := newReserved()
+	r.TryConsume(80)
+
+	tests := []struct {
+		name     string
+		tokens   int
+		priority int
+		want     time.Duration
+	}{
+		{"high has reserve", 20, HighPriority, 0},
+		{"low waits for unreserved refill", 6, 0, 6 * 750 * time.Millisecond},
+		{"low exceeds unreserved capacity", 81, 0, -1},
+		{"high within total capacity", 81, HighPriority, 36600 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := r.TimeUntilAvailablePriority(tt.tokens, tt.priority); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReserved_WaitAndConsume(t *testing.T) {
+	r, clock := newReserved()
+	r.TryConsume(80)
+
+	ctx := context.Background()
+	if err := r.WaitAndConsume(ctx, 10, time.Second); !errors.Is(err, ErrMaxWaitExceeded) {
+		t.Errorf("expected ErrMaxWaitExceeded, got %v", err)
+	}
+	if err := r.WaitAndConsume(ctx, 81, 0); !errors.Is(err, ErrExceedsCapacity) {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+	if err := r.WaitAndConsume(WithPriority(ctx, HighPriority), 10, time.Second); err != nil {
+		t.Errorf("high priority should not wait: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- r.WaitAndConsume(ctx, 10, 0)
+	}()
+
+	clock.BlockUntil(1)
+	if got := r.Snapshot().Waiters; got != 1 {
+		t.Errorf("expected 1 waiter, got %d", got)
+	}
+	clock.Advance(10 * time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("WaitAndConsume: %v", err)
+	}
+}
+
+func TestReserved_Snapshot(t *testing.T) {
+	r, _ := newReserved()
+	r.TryConsume(30)
+
+	snapshot := r.Snapshot()
+	tests := []struct {
+		bucket        string
+		wantCapacity  int
+		wantRemaining int
+	}{
+		{BucketTokens, 100, 70},
+		{"unreserved_" + BucketTokens, 80, 50},
+	}
+	for _, tt := range tests {
+		b, ok := snapshot.Bucket(tt.bucket)
+		if !ok {
+			t.Errorf("no %s bucket in snapshot", tt.bucket)
+			continue
+		}
+		if b.Capacity != tt.wantCapacity || b.Remaining != tt.wantRemaining {
+			t.Errorf("%s: got capacity %d remaining %d, want %d and %d",
+				tt.bucket, b.Capacity, b.Remaining, tt.wantCapacity, tt.wantRemaining)
+		}
+	}
+}
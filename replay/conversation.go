@@ -0,0 +1,88 @@
+package replay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// Conversation records or replays a multi-turn conversation.
+// Each turn is matched on its own request and on every earlier turn.
+//
+// In ModeAuto, a turn recorded after replayed turns is sent to a provider
+// conversation that has not seen the replayed turns.
+type Conversation struct {
+	recorder *Recorder
+	inner    imagegen.Conversation // nil when replaying
+	history  []imagegen.ConversationTurn
+
+	// chain is a hash of all earlier turn requests
+	chain string
+
+	mu sync.Mutex
+}
+
+// Send sends a message and receives a response.
+func (c *Conversation) Send(ctx context.Context, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req := newRequest(imagegen.OperationConversation, prompt, images, config, c.chain)
+	result, err := c.recorder.do(req, func() (*imagegen.GenerateResult, error) {
+		if c.inner == nil {
+			return nil, ErrNoRecording
+		}
+		return c.inner.Send(ctx, prompt, images, config)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(c.chain + req.key()))
+	c.chain = hex.EncodeToString(sum[:])
+
+	userTurn := imagegen.ConversationTurn{
+		Role: "user",
+		Text: prompt,
+	}
+	for _, img := range images {
+		userTurn.Images = append(userTurn.Images, imagegen.GeneratedImage{
+			Data:     img.Data,
+			MIMEType: img.MIMEType,
+		})
+	}
+	c.history = append(c.history, userTurn)
+
+	c.history = append(c.history, imagegen.ConversationTurn{
+		Role:   "model",
+		Text:   result.Text,
+		Images: result.Images,
+	})
+
+	return result, nil
+}
+
+// History returns the conversation history.
+func (c *Conversation) History() []imagegen.ConversationTurn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	historyCopy := make([]imagegen.ConversationTurn, len(c.history))
+	copy(historyCopy, c.history)
+	return historyCopy
+}
+
+// Clear resets the conversation history.
+func (c *Conversation) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.history = make([]imagegen.ConversationTurn, 0)
+	c.chain = ""
+	if c.inner != nil {
+		c.inner.Clear()
+	}
+}
@@ -0,0 +1,424 @@
+// Package replay provides an ImageGenerator wrapper that records provider
+// interactions to a cassette directory and replays them without calling the
+// provider.
+//
+// Record real interactions once:
+//
+//	gen, _ := gemini.NewWithAPIKey(ctx, apiKey)
+//	rec, _ := replay.New(gen, "testdata/cassettes", replay.ModeRecord)
+//	manager := imagegen.NewManager(rec)
+//
+// and replay them in CI without network access or keys:
+//
+//	rec, _ := replay.New(nil, "testdata/cassettes", replay.ModeReplay)
+//	manager := imagegen.NewManager(rec)
+//
+// Requests are matched on operation, prompt, input image hashes and the
+// generation-relevant fields of GenerateConfig. Conversation turns are
+// additionally matched on all earlier turns of the conversation.
+package replay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+)
+
+// Mode selects whether a Recorder records or replays interactions.
+type Mode int
+
+const (
+	// ModeReplay serves recorded interactions and never calls the provider.
+	ModeReplay Mode = iota
+
+	// ModeRecord calls the provider and records every interaction,
+	// replacing existing recordings for the same request.
+	ModeRecord
+
+	// ModeAuto replays recorded interactions and records missing ones.
+	ModeAuto
+)
+
+// ErrNoRecording is returned in ModeReplay when no recording matches a request.
+var ErrNoRecording = errors.New("replay: no recording for request")
+
+const modelsFile = "models.json"
+
+// Request is the recorded form of a request.
+type Request struct {
+	Operation    imagegen.Operation       `json:"operation"`
+	Prompt       string                   `json:"prompt"`
+	Images       []ImageRef               `json:"images,omitempty"`
+	Config       *imagegen.GenerateConfig `json:"config,omitempty"`
+	Conversation string                   `json:"conversation,omitempty"`
+}
+
+// ImageRef identifies an input image by content hash.
+type ImageRef struct {
+	SHA256   string `json:"sha256"`
+	MIMEType string `json:"mime_type"`
+	URI      string `json:"uri,omitempty"`
+}
+
+// Interaction is a recorded request and its outcome.
+type Interaction struct {
+	Request    Request                  `json:"request"`
+	Result     *imagegen.GenerateResult `json:"result,omitempty"`
+	Error      *RecordedError           `json:"error,omitempty"`
+	RecordedAt time.Time                `json:"recorded_at"`
+}
+
+// RecordedError is the recorded form of a provider error. Rate limit errors
+// are restored as *imagegen.RateLimitError, errors with a StatusCode as
+// *imagegen.ProviderError, and safety blocks wrap imagegen.ErrContentBlocked,
+// so replays are classified like the live errors they captured.
+type RecordedError struct {
+	Message   string        `json:"message"`
+	RateLimit bool          `json:"rate_limit,omitempty"`
+	LimitType string        `json:"limit_type,omitempty"`
+	Model     string        `json:"model,omitempty"`
+	RetryIn   time.Duration `json:"retry_after,omitempty"`
+
+	Provider       string `json:"provider,omitempty"`
+	StatusCode     int    `json:"status_code,omitempty"`
+	ContentBlocked bool   `json:"content_blocked,omitempty"`
+}
+
+// Recorder wraps an ImageGenerator to record or replay its interactions.
+type Recorder struct {
+	inner imagegen.ImageGenerator
+	dir   string
+	mode  Mode
+
+	// served counts replays per key, so repeated identical requests replay
+	// successive recordings in order.
+	served   map[string]int
+	recorded map[string]bool
+
+	mu sync.Mutex
+}
+
+// Ensure Recorder implements the interfaces.
+var (
+	_ imagegen.ImageGenerator               = (*Recorder)(nil)
+	_ imagegen.ConversationalImageGenerator = (*Recorder)(nil)
+)
+
+// New creates a Recorder storing cassettes in dir.
+// inner may be nil in ModeReplay.
+func New(inner imagegen.ImageGenerator, dir string, mode Mode) (*Recorder, error) {
+	if inner == nil && mode != ModeReplay {
+		return nil, errors.New("replay: a provider is required to record")
+	}
+
+	if mode != ModeReplay {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("replay: creating cassette dir: %w", err)
+		}
+		if err := writeJSON(filepath.Join(dir, modelsFile), inner.Models()); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Recorder{
+		inner:    inner,
+		dir:      dir,
+		mode:     mode,
+		served:   make(map[string]int),
+		recorded: make(map[string]bool),
+	}, nil
+}
+
+// Generate creates images from a text prompt.
+func (r *Recorder) Generate(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	req := newRequest(imagegen.OperationGenerate, prompt, nil, config, "")
+	return r.do(req, func() (*imagegen.GenerateResult, error) {
+		return r.inner.Generate(ctx, prompt, config)
+	})
+}
+
+// Edit modifies an existing image based on a text instruction.
+func (r *Recorder) Edit(ctx context.Context, image imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	req := newRequest(imagegen.OperationEdit, instruction, []imagegen.InputImage{image}, config, "")
+	return r.do(req, func() (*imagegen.GenerateResult, error) {
+		return r.inner.Edit(ctx, image, instruction, config)
+	})
+}
+
+// EditMultiple performs editing with multiple reference images.
+func (r *Recorder) EditMultiple(ctx context.Context, images []imagegen.InputImage, instruction string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+	req := newRequest(imagegen.OperationEditMultiple, instruction, images, config, "")
+	return r.do(req, func() (*imagegen.GenerateResult, error) {
+		return r.inner.EditMultiple(ctx, images, instruction, config)
+	})
+}
+
+// Models returns the provider's models, or the recorded ones when replaying.
+func (r *Recorder) Models() []imagegen.ModelInfo {
+	if r.inner != nil {
+		return r.inner.Models()
+	}
+
+	var models []imagegen.ModelInfo
+	data, err := os.ReadFile(filepath.Join(r.dir, modelsFile))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil
+	}
+	return models
+}
+
+// Close closes the wrapped provider, if any.
+func (r *Recorder) Close() error {
+	if r.inner == nil {
+		return nil
+	}
+	return r.inner.Close()
+}
+
+// StartConversation begins a new recorded or replayed conversation.
+// When recording a provider without native conversation support, turns are
+// recorded from an imagegen.EmulatedConversation.
+func (r *Recorder) StartConversation() imagegen.Conversation {
+	conv := &Conversation{
+		recorder: r,
+		history:  make([]imagegen.ConversationTurn, 0),
+	}
+
+	if r.inner != nil {
+		if convGen, ok := r.inner.(imagegen.ConversationalImageGenerator); ok {
+			conv.inner = convGen.StartConversation()
+		} else {
+			conv.inner = imagegen.NewEmulatedConversation(r.inner, imagegen.DefaultEmulationOptions())
+		}
+	}
+
+	return conv
+}
+
+// do replays the interaction for req or records the outcome of call.
+func (r *Recorder) do(req Request, call func() (*imagegen.GenerateResult, error)) (*imagegen.GenerateResult, error) {
+	key := req.key()
+
+	if r.mode != ModeRecord {
+		interaction, ok, err := r.next(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return interaction.Result, interaction.Error.err()
+		}
+		if r.mode == ModeReplay {
+			return nil, fmt.Errorf("%w: %s %q (key %s)", ErrNoRecording, req.Operation, req.Prompt, key[:12])
+		}
+	}
+
+	result, callErr := call()
+
+	interaction := Interaction{
+		Request:    req,
+		Result:     result,
+		Error:      newRecordedError(callErr),
+		RecordedAt: time.Now().UTC(),
+	}
+	if err := r.record(key, interaction); err != nil {
+		return nil, err
+	}
+
+	return result, callErr
+}
+
+// next returns the next unserved recording for key.
+func (r *Recorder) next(key string) (Interaction, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interactions, err := r.load(key)
+	if err != nil {
+		return Interaction{}, false, err
+	}
+
+	i := r.served[key]
+	if i >= len(interactions) {
+		return Interaction{}, false, nil
+	}
+	r.served[key] = i + 1
+
+	return interactions[i], true, nil
+}
+
+// record appends an interaction to the cassette for key. The first recording
+// of a key in ModeRecord replaces any cassette left from earlier runs.
+func (r *Recorder) record(key string, interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var interactions []Interaction
+	if r.recorded[key] || r.mode == ModeAuto {
+		var err error
+		if interactions, err = r.load(key); err != nil {
+			return err
+		}
+	}
+	interactions = append(interactions, interaction)
+	r.recorded[key] = true
+	r.served[key] = len(interactions)
+
+	return writeJSON(r.path(key), interactions)
+}
+
+// load reads all recordings for key. Must be called while holding r.mu.
+func (r *Recorder) load(key string) ([]Interaction, error) {
+	data, err := os.ReadFile(r.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("replay: reading cassette: %w", err)
+	}
+
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("replay: decoding cassette %s: %w", r.path(key), err)
+	}
+	return interactions, nil
+}
+
+func (r *Recorder) path(key string) string {
+	return filepath.Join(r.dir, key+".json")
+}
+
+// newRequest builds the recorded form of a request. Config fields that do not
+// affect the provider's output (metadata and rate limit waiting) are dropped.
+func newRequest(op imagegen.Operation, prompt string, images []imagegen.InputImage, config *imagegen.GenerateConfig, conversation string) Request {
+	req := Request{
+		Operation:    op,
+		Prompt:       prompt,
+		Conversation: conversation,
+	}
+
+	for _, img := range images {
+		sum := sha256.Sum256(img.Data)
+		req.Images = append(req.Images, ImageRef{
+			SHA256:   hex.EncodeToString(sum[:]),
+			MIMEType: img.MIMEType,
+			URI:      img.URI,
+		})
+	}
+
+	if config != nil {
+		configCopy := *config
+		configCopy.Metadata = nil
+		configCopy.WaitOnRateLimit = false
+		configCopy.MaxWaitDuration = 0
+		configCopy.Priority = 0
+		req.Config = &configCopy
+	}
+
+	return req
+}
+
+// key returns the cassette key for a request.
+func (req Request) key() string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newRecordedError(err error) *RecordedError {
+	if err == nil {
+		return nil
+	}
+
+	recErr := &RecordedError{Message: err.Error()}
+	var rlErr *imagegen.RateLimitError
+	if errors.As(err, &rlErr) {
+		recErr.RateLimit = true
+		recErr.LimitType = rlErr.LimitType
+		recErr.Model = rlErr.Model
+		recErr.RetryIn = rlErr.RetryAfter
+	}
+	var providerErr *imagegen.ProviderError
+	if errors.As(err, &providerErr) {
+		recErr.Provider = string(providerErr.Provider)
+		recErr.Model = providerErr.Model
+		recErr.StatusCode = providerErr.StatusCode
+	}
+	recErr.ContentBlocked = errors.Is(err, imagegen.ErrContentBlocked)
+	return recErr
+}
+
+// err restores a recorded error.
+func (e *RecordedError) err() error {
+	if e == nil {
+		return nil
+	}
+
+	var cause error
+	if e.ContentBlocked {
+		cause = imagegen.ErrContentBlocked
+	}
+	if e.StatusCode != 0 {
+		inner := cause
+		if inner == nil {
+			inner = errors.New(e.Message)
+		}
+		cause = &imagegen.ProviderError{
+			Provider:   imagegen.Provider(e.Provider),
+			Model:      e.Model,
+			StatusCode: e.StatusCode,
+			Err:        inner,
+		}
+	}
+
+	err := errors.New(e.Message)
+	if cause != nil {
+		err = &restoredError{message: e.Message, cause: cause}
+	}
+	if e.RateLimit {
+		return &imagegen.RateLimitError{
+			RetryAfter: e.RetryIn,
+			LimitType:  e.LimitType,
+			Model:      e.Model,
+			Err:        err,
+		}
+	}
+	return err
+}
+
+// restoredError is a replayed error with its recorded message, wrapping the
+// typed error it was recorded as.
+type restoredError struct {
+	message string
+	cause   error
+}
+
+func (e *restoredError) Error() string {
+	return e.message
+}
+
+func (e *restoredError) Unwrap() error {
+	return e.cause
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("replay: encoding %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("replay: writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/provider/fake"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	config := &imagegen.GenerateConfig{Size: imagegen.ImageSize1K, Metadata: map[string]string{"request_id": "1"}}
+
+	gen := fake.New(fake.Options{MaxDimension: 64})
+	rec, err := New(gen, dir, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := rec.Generate(ctx, "a windmill", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := New(nil, dir, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata does not affect matching
+	replayConfig := *config
+	replayConfig.Metadata = map[string]string{"request_id": "2"}
+	replayed, err := player.Generate(ctx, "a windmill", &replayConfig)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if !bytes.Equal(recorded.Images[0].Data, replayed.Images[0].Data) {
+		t.Error("replayed image differs from recording")
+	}
+	if replayed.UsageMetadata == nil || *replayed.UsageMetadata != *recorded.UsageMetadata {
+		t.Errorf("replayed usage %+v, want %+v", replayed.UsageMetadata, recorded.UsageMetadata)
+	}
+
+	if _, err := player.Generate(ctx, "a lighthouse", config); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("expected ErrNoRecording, got %v", err)
+	}
+
+	if models := player.Models(); len(models) != 1 || models[0].Name != string(fake.ModelFake) {
+		t.Errorf("expected recorded models, got %+v", models)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		check func(error) bool
+	}{
+		{
+			name:  "rate limit",
+			err:   &imagegen.RateLimitError{LimitType: "requests", Model: "fake-image-v1"},
+			check: imagegen.IsRateLimitError,
+		},
+		{
+			name: "provider error",
+			err:  &imagegen.ProviderError{Provider: "fake", Model: "fake-image-v1", StatusCode: 503, Err: errors.New("unavailable")},
+			check: func(err error) bool {
+				var providerErr *imagegen.ProviderError
+				return errors.As(err, &providerErr) && providerErr.Temporary() &&
+					providerErr.Provider == "fake" && providerErr.Model == "fake-image-v1"
+			},
+		},
+		{
+			name: "content blocked",
+			err:  fmt.Errorf("%w: prompt blocked: SAFETY", imagegen.ErrContentBlocked),
+			check: func(err error) bool {
+				return errors.Is(err, imagegen.ErrContentBlocked)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.Background()
+
+			failing := fake.New(fake.Options{FailureRate: 1, FailureErr: tt.err})
+			rec, err := New(failing, dir, ModeRecord)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rec.Generate(ctx, "a cat", nil); !tt.check(err) {
+				t.Fatalf("unexpected error while recording: %v", err)
+			}
+
+			player, _ := New(nil, dir, ModeReplay)
+			_, err = player.Generate(ctx, "a cat", nil)
+			if !tt.check(err) {
+				t.Errorf("replayed error %v (%T) is not classified like the recording", err, err)
+			}
+			if err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("replayed message %v, want %q", err, tt.err.Error())
+			}
+			if got, want := imagegen.ErrorKind(err), imagegen.ErrorKind(tt.err); got != want {
+				t.Errorf("ErrorKind() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReplayConversation(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	turns := []string{"a boat", "add a sail", "make it night"}
+
+	rec, err := New(fake.New(fake.Options{MaxDimension: 64}), dir, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedConv := imagegen.NewManager(rec, imagegen.WithDefaultModel(fake.ModelFake)).StartConversation()
+
+	var recorded []*imagegen.GenerateResult
+	for _, prompt := range turns {
+		result, err := recordedConv.Send(ctx, prompt, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, result)
+	}
+
+	player, _ := New(nil, dir, ModeReplay)
+	replayedConv := imagegen.NewManager(player, imagegen.WithDefaultModel(fake.ModelFake)).StartConversation()
+
+	for i, prompt := range turns {
+		result, err := replayedConv.Send(ctx, prompt, nil, nil)
+		if err != nil {
+			t.Fatalf("turn %d: %v", i+1, err)
+		}
+		if !bytes.Equal(result.Images[0].Data, recorded[i].Images[0].Data) {
+			t.Errorf("turn %d: replayed image differs from recording", i+1)
+		}
+	}
+	if got := len(replayedConv.History()); got != 6 {
+		t.Errorf("expected 6 history turns, got %d", got)
+	}
+
+	// The same prompt out of sequence does not match
+	outOfOrder := imagegen.NewManager(player, imagegen.WithDefaultModel(fake.ModelFake)).StartConversation()
+	if _, err := outOfOrder.Send(ctx, "add a sail", nil, nil); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("expected ErrNoRecording for out-of-sequence turn, got %v", err)
+	}
+}
+
+func TestAutoModeRecordsMissesOnly(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	gen := fake.New(fake.Options{MaxDimension: 64})
+
+	rec, err := New(gen, dir, ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Generate(ctx, "a tree", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	again, _ := New(gen, dir, ModeAuto)
+	if _, err := again.Generate(ctx, "a tree", nil); err != nil {
+		t.Fatal(err)
+	}
+	if gen.Calls() != 1 {
+		t.Errorf("expected the second run to replay, got %d provider calls", gen.Calls())
+	}
+}
@@ -0,0 +1,147 @@
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Shutdown stops the Manager gracefully. New requests, including
+// conversation turns, fail with ErrManagerClosed; requests in flight are
+// given until ctx is done to finish. Then the shutdown hooks run, rate
+// limiter state is saved (see SetLimiterStore), and providers are closed.
+//
+// If ctx is done first, Shutdown closes the providers anyway, failing the
+// remaining requests, and returns an error wrapping ctx.Err().
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//	if err := manager.Shutdown(ctx); err != nil {
+//	    log.Printf("shutdown: %v", err)
+//	}
+func (m *Manager) Shutdown(ctx context.Context) error {
+	drained := m.requests.close()
+
+	var errs []error
+	if n := m.requests.inFlight(); n > 0 {
+		m.logger.Info("draining requests", "in_flight", n)
+	}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		n := m.requests.inFlight()
+		m.logger.Warn("shutdown deadline reached with requests in flight", "in_flight", n)
+		errs = append(errs, fmt.Errorf("%w: %d requests still in flight", ctx.Err(), n))
+	}
+
+	if err := m.finishShutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// OnShutdown adds a hook run by Shutdown and Close once requests have
+// drained, before providers are closed, to persist state such as pending
+// work. Hooks run in the order they were added; their errors are returned by
+// Shutdown and Close.
+func (m *Manager) OnShutdown(hook func(ctx context.Context) error) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.shutdownHooks = append(m.shutdownHooks, hook)
+	return m
+}
+
+// finishShutdown runs the shutdown hooks, saves limiter state and closes the
+// providers. Only the first call does anything. m.mu is not held while the
+// hooks and Close run, so they may call the Manager.
+func (m *Manager) finishShutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.shutdownDone {
+		m.mu.Unlock()
+		return nil
+	}
+	m.shutdownDone = true
+	hooks := slices.Clone(m.shutdownHooks)
+	providers := m.providers
+	m.providers = make(map[Provider]ImageGenerator)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook: %w", err))
+		}
+	}
+	if err := m.SaveLimiterState(); err != nil {
+		errs = append(errs, err)
+	}
+	for provider, gen := range providers {
+		if err := gen.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s: %w", provider, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// requestTracker counts requests in flight and refuses new ones once closed.
+type requestTracker struct {
+	closed  bool
+	active  int
+	drained chan struct{} // closed once closed and no requests are active
+
+	mu sync.Mutex
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{drained: make(chan struct{})}
+}
+
+// begin counts a new request, or reports false if the tracker is closed.
+func (t *requestTracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return false
+	}
+	t.active++
+	return true
+}
+
+// end counts a request begun with begin as finished.
+func (t *requestTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active--
+	if t.closed && t.active == 0 {
+		close(t.drained)
+	}
+}
+
+// close refuses new requests and returns a channel that is closed once the
+// requests in flight have finished.
+func (t *requestTracker) close() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		if t.active == 0 {
+			close(t.drained)
+		}
+	}
+	return t.drained
+}
+
+func (t *requestTracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
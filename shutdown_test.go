@@ -0,0 +1,158 @@
+package imagegen_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+)
+
+func TestManager_Shutdown_DrainsRequests(t *testing.T) {
+	release := make(chan struct{})
+	gen := blockingGenerator(release, "slow")
+
+	var (
+		events []string
+		mu     sync.Mutex
+	)
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	gen.CloseFunc = func() error {
+		record("provider closed")
+		return nil
+	}
+
+	manager := imagegen.NewManager(gen, imagegen.WithDefaultModel("slow"))
+	manager.OnShutdown(func(ctx context.Context) error {
+		record("hook")
+		return nil
+	})
+
+	inFlight := make(chan error)
+	go func() {
+		_, err := manager.Generate(context.Background(), "a cat", nil)
+		record("request finished")
+		inFlight <- err
+	}()
+	waitFor(t, "the request", func() bool { return manager.Stats()["slow"].InFlight == 1 })
+
+	shutdown := make(chan error)
+	go func() { shutdown <- manager.Shutdown(context.Background()) }()
+
+	// New requests and conversation turns are refused while draining. The
+	// probe requests are canceled so they cannot block if accepted.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	waitFor(t, "new requests to be refused", func() bool {
+		_, err := manager.Generate(canceled, "another cat", nil)
+		return errors.Is(err, imagegen.ErrManagerClosed)
+	})
+	if _, err := manager.StartConversation().Send(context.Background(), "a dog", nil, nil); !errors.Is(err, imagegen.ErrManagerClosed) {
+		t.Errorf("expected conversation turn to fail with ErrManagerClosed, got %v", err)
+	}
+
+	close(release)
+	if err := <-inFlight; err != nil {
+		t.Errorf("in-flight request: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"request finished", "hook", "provider closed"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("events = %v, want %v", events, want)
+			break
+		}
+	}
+}
+
+func TestManager_Shutdown_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	closed := false
+	gen := blockingGenerator(release, "slow")
+	gen.CloseFunc = func() error {
+		closed = true
+		return nil
+	}
+	manager := imagegen.NewManager(gen, imagegen.WithDefaultModel("slow"))
+
+	go manager.Generate(context.Background(), "a cat", nil)
+	waitFor(t, "the request", func() bool { return manager.Stats()["slow"].InFlight == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := manager.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Shutdown to report the deadline, got %v", err)
+	}
+	if !closed {
+		t.Error("expected providers to be closed after the deadline")
+	}
+}
+
+func TestManager_Close_RefusesRequests(t *testing.T) {
+	hookErr := errors.New("hook failed")
+	hooks := 0
+	manager := imagegen.NewManager(blockingGenerator(nil, "slow"), imagegen.WithDefaultModel("slow"))
+	manager.OnShutdown(func(ctx context.Context) error {
+		hooks++
+		return hookErr
+	})
+
+	if err := manager.Close(); !errors.Is(err, hookErr) {
+		t.Errorf("Close() error = %v, want the hook error", err)
+	}
+	if err := manager.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if hooks != 1 {
+		t.Errorf("hook ran %d times, want 1", hooks)
+	}
+
+	_, err := manager.Generate(context.Background(), "a cat", nil)
+	if !errors.Is(err, imagegen.ErrManagerClosed) {
+		t.Fatalf("expected ErrManagerClosed, got %v", err)
+	}
+	if kind := imagegen.ErrorKind(err); kind != "closed" {
+		t.Errorf("ErrorKind() = %q, want closed", kind)
+	}
+}
+
+func TestManager_Shutdown_HookCallsManager(t *testing.T) {
+	manager := imagegen.NewManager(blockingGenerator(nil, "slow"), imagegen.WithDefaultModel("slow"))
+
+	var stats map[imagegen.Model]imagegen.ModelStats
+	manager.OnShutdown(func(ctx context.Context) error {
+		stats = manager.Stats()
+		return manager.SaveLimiterState()
+	})
+
+	done := make(chan error)
+	go func() { done <- manager.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown deadlocked on a hook calling the Manager")
+	}
+	if _, ok := stats["slow"]; !ok {
+		t.Errorf("hook got stats %v, want the slow model", stats)
+	}
+}
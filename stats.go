@@ -0,0 +1,304 @@
+package imagegen
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+// latencyWindow is how many recent latencies per model are kept for P95Latency.
+const latencyWindow = 1024
+
+// ModelStats summarizes the requests made to a model since the Manager was created.
+type ModelStats struct {
+	Model Model
+
+	Requests  int64
+	Successes int64
+	Failures  int64
+
+	// FailuresByKind counts failures by ErrorKind.
+	FailuresByKind map[string]int64
+
+	// AverageLatency is the mean over all requests; P95Latency is computed
+	// over the most recent requests.
+	AverageLatency time.Duration
+	P95Latency     time.Duration
+
+	PromptTokens  int64
+	OutputTokens  int64
+	Images        int64
+	EstimatedCost float64
+
+	// RateLimit is the live limiter state, or nil if the model has no limiter.
+	RateLimit *RateLimitStats
+
+	// InFlight is how many requests are waiting on the provider now.
+	InFlight int64
+
+	// Hedges is how many requests sent a hedge (see Manager.SetHedging), and
+	// HedgeWins how many of those were answered by the hedge.
+	Hedges    int64
+	HedgeWins int64
+
+	// Concurrency is the live state of the model's concurrency limit, or nil
+	// if it has none (see Manager.SetConcurrencyLimit).
+	Concurrency *ConcurrencyStats
+}
+
+// RateLimitStats is the live state of a model's rate limiter.
+type RateLimitStats struct {
+	// RemainingTokens and RemainingRequests are -1 if the limiter does not
+	// report them.
+	RemainingTokens   int
+	RemainingRequests int
+
+	// TimeUntilAvailable is how long until a single request could proceed.
+	TimeUntilAvailable time.Duration
+
+	// Snapshot is the limiter's full state.
+	Snapshot ratelimiter.Snapshot
+}
+
+// Stats returns a snapshot of per-model statistics for every registered model
+// and any model that was registered when it received requests. Requests for
+// models that were never registered are not recorded.
+func (m *Manager) Stats() map[Model]ModelStats {
+	m.mu.RLock()
+	models := make([]Model, 0, len(m.modelMappings))
+	for model := range m.modelMappings {
+		models = append(models, model)
+	}
+	m.mu.RUnlock()
+
+	stats := m.stats.snapshot(models)
+
+	limiters := m.RateLimiters()
+	for model, s := range stats {
+		if limiter := limiters[model]; limiter != nil {
+			s.RateLimit = limiterStats(limiter)
+			stats[model] = s
+		}
+	}
+
+	m.mu.RLock()
+	for model, sem := range m.modelConcurrency {
+		if s, ok := stats[model]; ok {
+			concurrency := sem.stats()
+			s.Concurrency = &concurrency
+			stats[model] = s
+		}
+	}
+	m.mu.RUnlock()
+
+	return stats
+}
+
+// limiterStats reads the live state of a limiter.
+func limiterStats(limiter ratelimiter.Limiter) *RateLimitStats {
+	s := &RateLimitStats{
+		RemainingTokens:    -1,
+		RemainingRequests:  -1,
+		TimeUntilAvailable: limiter.TimeUntilAvailable(1),
+		Snapshot:           limiter.Snapshot(),
+	}
+
+	if b, ok := s.Snapshot.Bucket(ratelimiter.BucketTokens); ok {
+		s.RemainingTokens = b.Remaining
+	}
+	if b, ok := s.Snapshot.Bucket(ratelimiter.BucketRequests); ok {
+		s.RemainingRequests = b.Remaining
+	}
+
+	return s
+}
+
+// statsRecorder accumulates per-model statistics.
+type statsRecorder struct {
+	models map[Model]*modelCounters
+	mu     sync.Mutex
+}
+
+type modelCounters struct {
+	requests, successes, failures int64
+	inFlight                      int64
+	hedges, hedgeWins             int64
+	failuresByKind                map[string]int64
+
+	totalLatency time.Duration
+	latencies    latencyRing
+
+	// dispatchLatencies are provider call latencies of successful attempts,
+	// used for hedging
+	dispatchLatencies latencyRing
+
+	promptTokens, outputTokens, images int64
+	cost                               float64
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{models: make(map[Model]*modelCounters)}
+}
+
+// counterLocked returns the counters for model, creating them if needed.
+// Must be called while holding s.mu.
+func (s *statsRecorder) counterLocked(model Model) *modelCounters {
+	c := s.models[model]
+	if c == nil {
+		c = &modelCounters{failuresByKind: make(map[string]int64)}
+		s.models[model] = c
+	}
+	return c
+}
+
+// begin counts a request as in flight until end is called.
+func (s *statsRecorder) begin(model Model) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counterLocked(model).inFlight++
+}
+
+// end counts a request begun with begin as finished.
+func (s *statsRecorder) end(model Model) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counterLocked(model).inFlight--
+}
+
+// hedged counts a hedge sent for a request to model.
+func (s *statsRecorder) hedged(model Model) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counterLocked(model).hedges++
+}
+
+// hedgeWon counts a request to model answered by its hedge.
+func (s *statsRecorder) hedgeWon(model Model) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counterLocked(model).hedgeWins++
+}
+
+// recordDispatch adds the provider call latency of a successful attempt.
+func (s *statsRecorder) recordDispatch(model Model, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counterLocked(model).dispatchLatencies.add(latency)
+}
+
+// dispatchPercentile returns the p-th percentile of model's recent provider
+// call latencies and how many latencies it was computed over. The latencies
+// are sorted after releasing s.mu, so hedged requests do not hold up others.
+func (s *statsRecorder) dispatchPercentile(model Model, p float64) (time.Duration, int) {
+	s.mu.Lock()
+	var latencies []time.Duration
+	if c := s.models[model]; c != nil {
+		latencies = slices.Clone(c.dispatchLatencies.values)
+	}
+	s.mu.Unlock()
+
+	return percentile(latencies, p), len(latencies)
+}
+
+// record adds the outcome of a request. cost is the estimated cost of result.
+func (s *statsRecorder) record(model Model, latency time.Duration, result *GenerateResult, err error, cost float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.counterLocked(model)
+	c.requests++
+	c.totalLatency += latency
+	c.latencies.add(latency)
+
+	if err != nil {
+		c.failures++
+		c.failuresByKind[ErrorKind(err)]++
+		return
+	}
+
+	c.successes++
+	c.images += int64(len(result.Images))
+	c.cost += cost
+	if usage := result.UsageMetadata; usage != nil {
+		c.promptTokens += int64(usage.PromptTokens)
+		c.outputTokens += int64(usage.CandidatesTokens)
+	}
+}
+
+// snapshot returns the statistics for every recorded model plus models.
+func (s *statsRecorder) snapshot(models []Model) map[Model]ModelStats {
+	s.mu.Lock()
+
+	stats := make(map[Model]ModelStats, len(s.models)+len(models))
+	latencies := make(map[Model][]time.Duration, len(s.models))
+	for _, model := range models {
+		stats[model] = ModelStats{Model: model, FailuresByKind: make(map[string]int64)}
+	}
+
+	for model, c := range s.models {
+		ms := ModelStats{
+			Model:          model,
+			Requests:       c.requests,
+			Successes:      c.successes,
+			Failures:       c.failures,
+			InFlight:       c.inFlight,
+			Hedges:         c.hedges,
+			HedgeWins:      c.hedgeWins,
+			FailuresByKind: make(map[string]int64, len(c.failuresByKind)),
+			PromptTokens:   c.promptTokens,
+			OutputTokens:   c.outputTokens,
+			Images:         c.images,
+			EstimatedCost:  c.cost,
+		}
+		for kind, n := range c.failuresByKind {
+			ms.FailuresByKind[kind] = n
+		}
+		if c.requests > 0 {
+			ms.AverageLatency = c.totalLatency / time.Duration(c.requests)
+		}
+		latencies[model] = slices.Clone(c.latencies.values)
+
+		stats[model] = ms
+	}
+	s.mu.Unlock()
+
+	// Sort outside s.mu, like dispatchPercentile
+	for model, values := range latencies {
+		ms := stats[model]
+		ms.P95Latency = percentile(values, 0.95)
+		stats[model] = ms
+	}
+
+	return stats
+}
+
+// latencyRing holds the most recent latencies, up to latencyWindow.
+type latencyRing struct {
+	values []time.Duration
+	next   int
+}
+
+func (r *latencyRing) add(latency time.Duration) {
+	if len(r.values) < latencyWindow {
+		r.values = append(r.values, latency)
+		return
+	}
+	r.values[r.next] = latency
+	r.next = (r.next + 1) % latencyWindow
+}
+
+// percentile returns the p-th percentile of latencies using the nearest-rank
+// method. It sorts latencies in place.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	slices.Sort(latencies)
+
+	rank := int(math.Ceil(float64(len(latencies))*p)) - 1
+	return latencies[min(max(rank, 0), len(latencies)-1)]
+}
@@ -0,0 +1,91 @@
+package imagegen_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+)
+
+func TestManager_Stats(t *testing.T) {
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 10000, RequestsPerMinute: 3}
+	info.Pricing = imagegen.Pricing{ImageGenerationCost: 0.1}
+	info.Capabilities.MaxInputImages = 1
+
+	gen := &imagegentest.MockGenerator{
+		ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+		GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+			time.Sleep(time.Millisecond)
+			return imagegentest.DefaultResult(), nil
+		},
+	}
+	model := imagegen.Model(info.Name)
+	manager := imagegen.NewManager(gen, imagegen.WithDefaultModel(model))
+	defer manager.Close()
+
+	if s := manager.Stats()[model]; s.Requests != 0 || s.RateLimit == nil || s.RateLimit.RemainingRequests != 3 {
+		t.Fatalf("unexpected initial stats: %+v", s)
+	}
+
+	ctx := context.Background()
+	for range 2 {
+		if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+	}
+	// Rejected by validation, so it doesn't consume the last request
+	img := imagegentest.TestImage()
+	if _, err := manager.EditMultiple(ctx, []imagegen.InputImage{img, img}, "a cat", nil); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := manager.Generate(ctx, "a cat", nil); !imagegen.IsRateLimitError(err) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	// Requests for unknown models are not recorded
+	if _, err := manager.Generate(ctx, "a cat", &imagegen.GenerateConfig{Model: "no-such-model"}); err == nil {
+		t.Fatal("expected an error for an unknown model")
+	}
+	if _, ok := manager.Stats()["no-such-model"]; ok {
+		t.Error("stats recorded for an unknown model")
+	}
+
+	s := manager.Stats()[model]
+	usage := imagegentest.DefaultResult().UsageMetadata
+
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"requests", s.Requests, 5},
+		{"successes", s.Successes, 3},
+		{"failures", s.Failures, 2},
+		{"rate limit failures", s.FailuresByKind["rate_limit"], 1},
+		{"invalid request failures", s.FailuresByKind["invalid_request"], 1},
+		{"images", s.Images, 3},
+		{"prompt tokens", s.PromptTokens, 3 * int64(usage.PromptTokens)},
+		{"output tokens", s.OutputTokens, 3 * int64(usage.CandidatesTokens)},
+		{"remaining requests", int64(s.RateLimit.RemainingRequests), 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if s.EstimatedCost < 0.299 || s.EstimatedCost > 0.301 {
+		t.Errorf("estimated cost = %v, want 0.3", s.EstimatedCost)
+	}
+	if s.AverageLatency <= 0 || s.P95Latency < time.Millisecond {
+		t.Errorf("latency avg %v p95 %v, want p95 >= 1ms", s.AverageLatency, s.P95Latency)
+	}
+	if s.RateLimit.TimeUntilAvailable <= 0 {
+		t.Error("expected a wait with no requests remaining")
+	}
+}
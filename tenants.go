@@ -0,0 +1,338 @@
+package imagegen
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+// MetadataTenant is the GenerateConfig.Metadata key identifying the tenant of
+// a request, when the context does not (see WithTenant).
+const MetadataTenant = "tenant"
+
+// ScopeTenant is the RateLimitError.Scope of a refusal by a tenant's limits.
+const ScopeTenant = "tenant"
+
+// Tenant limit types reported in RateLimitError.LimitType.
+const (
+	LimitTypeImagesPerDay  = "images_per_day"
+	LimitTypeSpendPerMonth = "spend_per_month"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context identifying the tenant its requests are made
+// for. It takes precedence over GenerateConfig.Metadata[MetadataTenant].
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set with WithTenant, or "".
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenantOf returns the tenant of a request: from ctx, then config metadata.
+func tenantOf(ctx context.Context, config *GenerateConfig) string {
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		return tenant
+	}
+	if config != nil {
+		return config.Metadata[MetadataTenant]
+	}
+	return ""
+}
+
+// TenantLimits are the limits applied to one tenant's requests, on top of
+// the model and provider limits. Zero means unlimited.
+//
+// ImagesPerDay and SpendPerMonth are checked before each request against the
+// usage recorded so far, so the request that crosses a quota is allowed.
+// Days and months are calendar periods in UTC.
+type TenantLimits struct {
+	RequestsPerMinute int     `json:"requests_per_minute,omitempty"`
+	ImagesPerDay      int     `json:"images_per_day,omitempty"`
+	SpendPerMonth     float64 `json:"spend_per_month_usd,omitempty"`
+}
+
+// TenantConfig is the complete tenant limits configuration. It can be
+// loaded from JSON and applied at runtime with SetTenantConfig.
+type TenantConfig struct {
+	// Default applies to tenants without their own entry. Nil means those
+	// tenants are unlimited.
+	Default *TenantLimits `json:"default,omitempty"`
+
+	Tenants map[string]TenantLimits `json:"tenants,omitempty"`
+}
+
+// TenantUsage is a tenant's usage in the current periods.
+type TenantUsage struct {
+	Tenant string
+	Limits TenantLimits
+
+	ImagesToday    int
+	SpendThisMonth float64
+
+	// RemainingRequests is how many requests the tenant can make now, or -1
+	// if it has no RequestsPerMinute limit.
+	RemainingRequests int
+}
+
+// SetTenantConfig replaces the tenant limits configuration. Usage recorded
+// so far is kept.
+func (m *Manager) SetTenantConfig(config TenantConfig) *Manager {
+	m.tenants.setConfig(config)
+	return m
+}
+
+// SetTenantLimits sets the limits for one tenant, leaving the rest of the
+// configuration unchanged.
+func (m *Manager) SetTenantLimits(tenant string, limits TenantLimits) *Manager {
+	m.tenants.setLimits(tenant, limits)
+	return m
+}
+
+// TenantUsage returns a tenant's usage in the current periods.
+func (m *Manager) TenantUsage(tenant string) TenantUsage {
+	return m.tenants.usage(tenant)
+}
+
+// tenantSweepInterval is how often idle tenant states are evicted.
+const tenantSweepInterval = time.Minute
+
+// tenantRegistry enforces and tracks tenant limits.
+type tenantRegistry struct {
+	config TenantConfig
+	states map[string]*tenantState
+	now    func() time.Time
+
+	// lastSweep is when idle states were last evicted
+	lastSweep time.Time
+
+	mu sync.Mutex
+}
+
+// tenantState is the usage of one tenant.
+type tenantState struct {
+	// requests enforces RequestsPerMinute; nil if unlimited
+	requests    *ratelimiter.GCRA
+	requestsRPM int
+
+	day    time.Time
+	images int
+
+	month time.Time
+	spend float64
+}
+
+func newTenantRegistry() *tenantRegistry {
+	return &tenantRegistry{
+		states: make(map[string]*tenantState),
+		now:    time.Now,
+	}
+}
+
+func (r *tenantRegistry) setConfig(config TenantConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tenants := make(map[string]TenantLimits, len(config.Tenants))
+	for tenant, limits := range config.Tenants {
+		tenants[tenant] = limits
+	}
+	config.Tenants = tenants
+	r.config = config
+}
+
+func (r *tenantRegistry) setLimits(tenant string, limits TenantLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.config.Tenants == nil {
+		r.config.Tenants = make(map[string]TenantLimits)
+	}
+	r.config.Tenants[tenant] = limits
+}
+
+// limitsLocked returns the limits for tenant and whether it has any.
+// Must be called while holding r.mu.
+func (r *tenantRegistry) limitsLocked(tenant string) (TenantLimits, bool) {
+	if tenant == "" {
+		return TenantLimits{}, false
+	}
+	if limits, ok := r.config.Tenants[tenant]; ok {
+		return limits, true
+	}
+	if r.config.Default != nil {
+		return *r.config.Default, true
+	}
+	return TenantLimits{}, false
+}
+
+// stateLocked returns the usage of tenant, rolling over expired periods and
+// keeping its request limiter in step with limits.
+// Must be called while holding r.mu.
+func (r *tenantRegistry) stateLocked(tenant string, limits TenantLimits) *tenantState {
+	s := r.states[tenant]
+	if s == nil {
+		r.evictIdleLocked()
+		s = &tenantState{}
+		r.states[tenant] = s
+	}
+
+	now := r.now().UTC()
+	if day := startOfDay(now); !s.day.Equal(day) {
+		s.day, s.images = day, 0
+	}
+	if month := startOfMonth(now); !s.month.Equal(month) {
+		s.month, s.spend = month, 0
+	}
+
+	if s.requestsRPM != limits.RequestsPerMinute {
+		s.requestsRPM = limits.RequestsPerMinute
+		s.requests = nil
+		if limits.RequestsPerMinute > 0 {
+			s.requests = ratelimiter.NewGCRA(0, limits.RequestsPerMinute)
+		}
+	}
+
+	return s
+}
+
+// evictIdleLocked removes the states of tenants with no usage in the current
+// periods and a full requests limiter, which are the same as new states, so
+// tenant IDs seen once do not accumulate. It runs at most once per
+// tenantSweepInterval. Must be called while holding r.mu.
+func (r *tenantRegistry) evictIdleLocked() {
+	now := r.now()
+	if now.Sub(r.lastSweep) < tenantSweepInterval {
+		return
+	}
+	r.lastSweep = now
+
+	day, month := startOfDay(now.UTC()), startOfMonth(now.UTC())
+	for tenant, s := range r.states {
+		if s.idle(day, month) {
+			delete(r.states, tenant)
+		}
+	}
+}
+
+// idle reports whether s has no usage in the periods starting at day and
+// month and its requests limiter, if any, is full.
+func (s *tenantState) idle(day, month time.Time) bool {
+	if (s.images > 0 && s.day.Equal(day)) || (s.spend > 0 && s.month.Equal(month)) {
+		return false
+	}
+	if s.requests == nil {
+		return true
+	}
+	b, ok := s.requests.Snapshot().Bucket(ratelimiter.BucketRequests)
+	return !ok || b.Remaining == b.Capacity
+}
+
+// limiter returns the tenant's requests limiter, or nil if it has none.
+func (r *tenantRegistry) limiter(tenant string) ratelimiter.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limits, ok := r.limitsLocked(tenant)
+	if !ok || limits.RequestsPerMinute <= 0 {
+		return nil
+	}
+	return r.stateLocked(tenant, limits).requests
+}
+
+// checkQuotas returns a RateLimitError if tenant has used its daily images or
+// monthly spend.
+func (r *tenantRegistry) checkQuotas(tenant string, model Model) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limits, ok := r.limitsLocked(tenant)
+	if !ok {
+		return nil
+	}
+	s := r.stateLocked(tenant, limits)
+
+	switch {
+	case limits.ImagesPerDay > 0 && s.images >= limits.ImagesPerDay:
+		return &RateLimitError{
+			RetryAfter: s.day.AddDate(0, 0, 1).Sub(r.now()),
+			LimitType:  LimitTypeImagesPerDay,
+			Model:      string(model),
+			Scope:      ScopeTenant,
+			Tenant:     tenant,
+		}
+	case limits.SpendPerMonth > 0 && s.spend >= limits.SpendPerMonth:
+		return &RateLimitError{
+			RetryAfter: s.month.AddDate(0, 1, 0).Sub(r.now()),
+			LimitType:  LimitTypeSpendPerMonth,
+			Model:      string(model),
+			Scope:      ScopeTenant,
+			Tenant:     tenant,
+		}
+	}
+	return nil
+}
+
+// record adds a successful request's images and cost to tenant's usage.
+func (r *tenantRegistry) record(tenant string, result *GenerateResult, cost float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limits, ok := r.limitsLocked(tenant)
+	if !ok {
+		return
+	}
+	s := r.stateLocked(tenant, limits)
+	s.images += len(result.Images)
+	s.spend += cost
+}
+
+func (r *tenantRegistry) usage(tenant string) TenantUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limits, _ := r.limitsLocked(tenant)
+	u := TenantUsage{
+		Tenant:            tenant,
+		Limits:            limits,
+		RemainingRequests: -1,
+	}
+	if limits.RequestsPerMinute > 0 {
+		u.RemainingRequests = limits.RequestsPerMinute
+	}
+
+	// Read the state without creating it, so queries for tenants that have
+	// made no requests do not grow the registry
+	s := r.states[tenant]
+	if s == nil {
+		return u
+	}
+
+	now := r.now().UTC()
+	if s.day.Equal(startOfDay(now)) {
+		u.ImagesToday = s.images
+	}
+	if s.month.Equal(startOfMonth(now)) {
+		u.SpendThisMonth = s.spend
+	}
+	if s.requests != nil && s.requestsRPM == limits.RequestsPerMinute {
+		if b, ok := s.requests.Snapshot().Bucket(ratelimiter.BucketRequests); ok {
+			u.RemainingRequests = b.Remaining
+		}
+	}
+	return u
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,40 @@
+package imagegen
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTenantRegistry_EvictsIdleStates(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := newTenantRegistry()
+	r.now = func() time.Time { return now }
+	r.setConfig(TenantConfig{Default: &TenantLimits{RequestsPerMinute: 10, ImagesPerDay: 5}})
+
+	for i := range 100 {
+		r.checkQuotas(fmt.Sprintf("tenant-%d", i), "model")
+	}
+	r.record("acme", &GenerateResult{Images: []GeneratedImage{{}}}, 0)
+	busy := r.limiter("busy")
+	busy.TryConsume(0)
+
+	// Tenants with usage today or a partly used limiter are kept
+	now = now.Add(tenantSweepInterval)
+	r.checkQuotas("newcomer", "model")
+	if got := len(r.states); got != 3 {
+		t.Errorf("%d tenant states after the sweep, want 3", got)
+	}
+
+	// The next day, only the busy tenant's limiter is still in use
+	now = now.Add(24 * time.Hour)
+	r.checkQuotas("tomorrow", "model")
+	for _, tenant := range []string{"busy", "tomorrow"} {
+		if r.states[tenant] == nil {
+			t.Errorf("%s was evicted", tenant)
+		}
+	}
+	if got := len(r.states); got != 2 {
+		t.Errorf("%d tenant states the next day, want 2", got)
+	}
+}
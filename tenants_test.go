@@ -0,0 +1,212 @@
+package imagegen_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/imagegen"
+	"github.com/mhpenta/imagegen/imagegentest"
+	"github.com/mhpenta/imagegen/ratelimiter"
+)
+
+// newTenantManager returns a Manager whose model produces one image costing
+// $0.50 per request.
+func newTenantManager(t *testing.T) *imagegen.Manager {
+	t.Helper()
+
+	info := imagegentest.MockModelInfo
+	info.RateLimits = imagegen.RateLimits{TokensPerMinute: 100_000, RequestsPerMinute: 100}
+	info.Pricing = imagegen.Pricing{ImageGenerationCost: 0.5}
+
+	manager := imagegen.NewManager(
+		&imagegentest.MockGenerator{
+			ModelsFunc: func() []imagegen.ModelInfo { return []imagegen.ModelInfo{info} },
+			GenerateFunc: func(ctx context.Context, prompt string, config *imagegen.GenerateConfig) (*imagegen.GenerateResult, error) {
+				return &imagegen.GenerateResult{Images: []imagegen.GeneratedImage{{MIMEType: "image/png"}}}, nil
+			},
+		},
+		imagegen.WithDefaultModel(imagegen.Model(info.Name)),
+	)
+	t.Cleanup(func() { manager.Close() })
+
+	return manager
+}
+
+func TestManager_TenantLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		limits        imagegen.TenantLimits
+		allowed       int
+		wantLimitType string
+		wantRetryMax  time.Duration
+	}{
+		{"requests per minute", imagegen.TenantLimits{RequestsPerMinute: 2}, 2, imagegen.LimitTypeRequests, time.Minute},
+		{"images per day", imagegen.TenantLimits{ImagesPerDay: 3}, 3, imagegen.LimitTypeImagesPerDay, 24 * time.Hour},
+		{"spend per month", imagegen.TenantLimits{SpendPerMonth: 1}, 2, imagegen.LimitTypeSpendPerMonth, 31 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTenantManager(t)
+			manager.SetTenantLimits("acme", tt.limits)
+
+			ctx := imagegen.WithTenant(context.Background(), "acme")
+			for i := range tt.allowed {
+				if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+			}
+
+			_, err := manager.Generate(ctx, "a cat", nil)
+			var rateLimitErr *imagegen.RateLimitError
+			if !errors.As(err, &rateLimitErr) {
+				t.Fatalf("expected RateLimitError, got %v", err)
+			}
+			if rateLimitErr.Scope != imagegen.ScopeTenant || rateLimitErr.Tenant != "acme" || rateLimitErr.LimitType != tt.wantLimitType {
+				t.Errorf("unexpected error details: %+v", rateLimitErr)
+			}
+			if rateLimitErr.RetryAfter <= 0 || rateLimitErr.RetryAfter > tt.wantRetryMax {
+				t.Errorf("RetryAfter = %v, want within (0, %v]", rateLimitErr.RetryAfter, tt.wantRetryMax)
+			}
+
+			// Other tenants and untagged requests are unaffected
+			other := imagegen.WithTenant(context.Background(), "globex")
+			if _, err := manager.Generate(other, "a cat", nil); err != nil {
+				t.Errorf("other tenant: %v", err)
+			}
+			if _, err := manager.Generate(context.Background(), "a cat", nil); err != nil {
+				t.Errorf("no tenant: %v", err)
+			}
+		})
+	}
+}
+
+func TestManager_TenantLimits_WaitOnRateLimit(t *testing.T) {
+	manager := newTenantManager(t)
+	manager.SetTenantLimits("acme", imagegen.TenantLimits{RequestsPerMinute: 1})
+
+	ctx := imagegen.WithTenant(context.Background(), "acme")
+	config := &imagegen.GenerateConfig{WaitOnRateLimit: true, MaxWaitDuration: time.Second}
+	if _, err := manager.Generate(ctx, "a cat", config); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+
+	// A wait that would exceed MaxWaitDuration is a tenant RateLimitError
+	_, err := manager.Generate(ctx, "a cat", config)
+	var rateLimitErr *imagegen.RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if rateLimitErr.Scope != imagegen.ScopeTenant || rateLimitErr.Tenant != "acme" {
+		t.Errorf("unexpected error details: %+v", rateLimitErr)
+	}
+	if !errors.Is(err, ratelimiter.ErrMaxWaitExceeded) {
+		t.Errorf("expected the limiter error to be wrapped, got %v", err)
+	}
+}
+
+func TestManager_TenantUsage_NoRequests(t *testing.T) {
+	manager := newTenantManager(t)
+	manager.SetTenantConfig(imagegen.TenantConfig{
+		Default: &imagegen.TenantLimits{RequestsPerMinute: 5, ImagesPerDay: 10},
+	})
+
+	want := imagegen.TenantUsage{
+		Tenant:            "initech",
+		Limits:            imagegen.TenantLimits{RequestsPerMinute: 5, ImagesPerDay: 10},
+		RemainingRequests: 5,
+	}
+	for range 2 {
+		if usage := manager.TenantUsage("initech"); usage != want {
+			t.Errorf("usage = %+v, want %+v", usage, want)
+		}
+	}
+
+	ctx := imagegen.WithTenant(context.Background(), "initech")
+	if _, err := manager.Generate(ctx, "a cat", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	usage := manager.TenantUsage("initech")
+	if usage.RemainingRequests != 4 || usage.ImagesToday != 1 {
+		t.Errorf("usage after a request = %+v, want 4 remaining requests and 1 image", usage)
+	}
+}
+
+func TestManager_TenantFromMetadata(t *testing.T) {
+	manager := newTenantManager(t)
+	manager.SetTenantLimits("acme", imagegen.TenantLimits{RequestsPerMinute: 1})
+
+	config := &imagegen.GenerateConfig{Metadata: map[string]string{imagegen.MetadataTenant: "acme"}}
+	ctx := context.Background()
+	if _, err := manager.Generate(ctx, "a cat", config); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := manager.Generate(ctx, "a cat", config); !imagegen.IsRateLimitError(err) {
+		t.Errorf("expected RateLimitError, got %v", err)
+	}
+
+	// The context takes precedence over metadata
+	if _, err := manager.Generate(imagegen.WithTenant(ctx, "globex"), "a cat", config); err != nil {
+		t.Errorf("context tenant: %v", err)
+	}
+
+	// The refused request did not consume the model's limit
+	stats := manager.Stats()[imagegen.Model(imagegentest.MockModelInfo.Name)]
+	if got := stats.RateLimit.RemainingRequests; got != 98 {
+		t.Errorf("model remaining requests = %d, want 98", got)
+	}
+}
+
+func TestManager_SetTenantConfig(t *testing.T) {
+	manager := newTenantManager(t)
+
+	var config imagegen.TenantConfig
+	err := json.Unmarshal([]byte(`{
+		"default": {"images_per_day": 1},
+		"tenants": {"acme": {"images_per_day": 5, "spend_per_month_usd": 10}}
+	}`), &config)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	manager.SetTenantConfig(config)
+
+	ctx := context.Background()
+	acme := imagegen.WithTenant(ctx, "acme")
+	for range 2 {
+		if _, err := manager.Generate(acme, "a cat", nil); err != nil {
+			t.Fatalf("acme: %v", err)
+		}
+	}
+
+	// Tenants without an entry get the default
+	globex := imagegen.WithTenant(ctx, "globex")
+	if _, err := manager.Generate(globex, "a cat", nil); err != nil {
+		t.Fatalf("globex: %v", err)
+	}
+	if _, err := manager.Generate(globex, "a cat", nil); !imagegen.IsRateLimitError(err) {
+		t.Errorf("expected default limit to refuse globex, got %v", err)
+	}
+
+	usage := manager.TenantUsage("acme")
+	want := imagegen.TenantUsage{
+		Tenant:            "acme",
+		Limits:            imagegen.TenantLimits{ImagesPerDay: 5, SpendPerMonth: 10},
+		ImagesToday:       2,
+		SpendThisMonth:    1,
+		RemainingRequests: -1,
+	}
+	if usage != want {
+		t.Errorf("usage = %+v, want %+v", usage, want)
+	}
+
+	// Reloading the configuration keeps usage
+	manager.SetTenantConfig(imagegen.TenantConfig{})
+	if _, err := manager.Generate(globex, "a cat", nil); err != nil {
+		t.Errorf("globex after reload: %v", err)
+	}
+	if got := manager.TenantUsage("acme").ImagesToday; got != 2 {
+		t.Errorf("acme images after reload = %d, want 2", got)
+	}
+}
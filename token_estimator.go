@@ -9,14 +9,42 @@ type TokenEstimator interface {
 	EstimateTokens(text string) int
 }
 
+// ImageTokenEstimator is an optional extension of TokenEstimator for
+// estimating the input token cost of an image.
+type ImageTokenEstimator interface {
+	EstimateImageTokens(img InputImage) int
+}
+
+// EstimateInputTokens estimates the input tokens for a prompt and its input
+// images. Images are only counted if estimator implements ImageTokenEstimator.
+func EstimateInputTokens(estimator TokenEstimator, prompt string, images []InputImage) int {
+	tokens := estimator.EstimateTokens(prompt)
+
+	if imgEstimator, ok := estimator.(ImageTokenEstimator); ok {
+		for _, img := range images {
+			tokens += imgEstimator.EstimateImageTokens(img)
+		}
+	}
+
+	return tokens
+}
+
+// DefaultImageTokens is the per-image input estimate used by SimpleTokenEstimator.
+// Gemini 3 bills up to 1120 tokens per image at high media resolution.
+const DefaultImageTokens = 1120
+
 // SimpleTokenEstimator - fast approximation of token usage for warnings
 type SimpleTokenEstimator struct {
 	SafetyMargin float64
+
+	// ImageTokens is the flat estimate charged per input image
+	ImageTokens int
 }
 
 func NewSimpleTokenEstimator() *SimpleTokenEstimator {
 	return &SimpleTokenEstimator{
 		SafetyMargin: 1.2,
+		ImageTokens:  DefaultImageTokens,
 	}
 }
 
@@ -31,3 +59,7 @@ func (e *SimpleTokenEstimator) EstimateTokens(text string) int {
 
 	return int(math.Ceil(tokenEstimate)) + 3
 }
+
+func (e *SimpleTokenEstimator) EstimateImageTokens(img InputImage) int {
+	return e.ImageTokens
+}
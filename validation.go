@@ -3,15 +3,18 @@ package imagegen
 import (
 	"errors"
 	"fmt"
+	"slices"
 )
 
 // Validation errors
 var (
-	ErrEmptyPrompt       = errors.New("prompt cannot be empty")
-	ErrEmptyImageData    = errors.New("image data cannot be empty")
-	ErrInvalidMIMEType   = errors.New("invalid or unsupported MIME type")
-	ErrImageTooLarge     = errors.New("image data exceeds maximum size")
-	ErrTooManyImages     = errors.New("too many input images")
+	ErrEmptyPrompt            = errors.New("prompt cannot be empty")
+	ErrEmptyImageData         = errors.New("image data cannot be empty")
+	ErrInvalidMIMEType        = errors.New("invalid or unsupported MIME type")
+	ErrImageTooLarge          = errors.New("image data exceeds maximum size")
+	ErrTooManyImages          = errors.New("too many input images")
+	ErrUnsupportedAspectRatio = errors.New("aspect ratio not supported by model")
+	ErrUnsupportedImageSize   = errors.New("image size not supported by model")
 )
 
 // Image size limits
@@ -80,3 +83,32 @@ func ValidateInputImages(images []InputImage) error {
 
 	return nil
 }
+
+// ValidateForModel validates a request's config and input image count against
+// a model's constraints. Zero-valued constraints in info are treated as unlimited.
+func ValidateForModel(info *ModelInfo, config *GenerateConfig, numImages int) error {
+	if info == nil {
+		return nil
+	}
+
+	if maxImages := info.Capabilities.MaxInputImages; maxImages > 0 && numImages > maxImages {
+		return fmt.Errorf("%w: %d (max %d for %s)", ErrTooManyImages, numImages, maxImages, info.Name)
+	}
+
+	if config == nil {
+		return nil
+	}
+
+	constraints := info.ImageConstraints
+	if config.AspectRatio != AspectRatioAuto && len(constraints.SupportedAspectRatios) > 0 &&
+		!slices.Contains(constraints.SupportedAspectRatios, config.AspectRatio) {
+		return fmt.Errorf("%w: %s (model %s)", ErrUnsupportedAspectRatio, config.AspectRatio, info.Name)
+	}
+
+	if config.Size != "" && len(constraints.SupportedSizes) > 0 &&
+		!slices.Contains(constraints.SupportedSizes, config.Size) {
+		return fmt.Errorf("%w: %s (model %s)", ErrUnsupportedImageSize, config.Size, info.Name)
+	}
+
+	return nil
+}
@@ -149,3 +149,83 @@ func TestValidateInputImages(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateForModel(t *testing.T) {
+	info := &ModelInfo{
+		Name: "test-model",
+		Capabilities: ModelCapabilities{
+			MaxInputImages: 2,
+		},
+		ImageConstraints: ImageConstraints{
+			SupportedAspectRatios: []AspectRatio{AspectRatio1x1, AspectRatio16x9},
+			SupportedSizes:        []ImageSize{ImageSize1K},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		info      *ModelInfo
+		config    *GenerateConfig
+		numImages int
+		wantErr   error
+	}{
+		{
+			name:    "nil info",
+			info:    nil,
+			config:  &GenerateConfig{Size: ImageSize4K},
+			wantErr: nil,
+		},
+		{
+			name:    "nil config",
+			info:    info,
+			config:  nil,
+			wantErr: nil,
+		},
+		{
+			name:    "supported config",
+			info:    info,
+			config:  &GenerateConfig{Size: ImageSize1K, AspectRatio: AspectRatio16x9},
+			wantErr: nil,
+		},
+		{
+			name:    "auto aspect ratio",
+			info:    info,
+			config:  &GenerateConfig{AspectRatio: AspectRatioAuto},
+			wantErr: nil,
+		},
+		{
+			name:    "unsupported aspect ratio",
+			info:    info,
+			config:  &GenerateConfig{AspectRatio: AspectRatio21x9},
+			wantErr: ErrUnsupportedAspectRatio,
+		},
+		{
+			name:    "unsupported size",
+			info:    info,
+			config:  &GenerateConfig{Size: ImageSize4K},
+			wantErr: ErrUnsupportedImageSize,
+		},
+		{
+			name:      "too many images for model",
+			info:      info,
+			config:    &GenerateConfig{},
+			numImages: 3,
+			wantErr:   ErrTooManyImages,
+		},
+		{
+			name:    "unconstrained model",
+			info:    &ModelInfo{Name: "open-model"},
+			config:  &GenerateConfig{Size: ImageSize4K, AspectRatio: AspectRatio21x9},
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateForModel(tt.info, tt.config, tt.numImages)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateForModel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}